@@ -12,10 +12,6 @@ import (
 	"github.com/chromium/hstspreload/chromium/preloadlist"
 )
 
-const (
-	parallelism = 100
-)
-
 // CertSummary summarizes interesting info about an X509.Certificate
 // Hashes of public certs can be looked up at https://crt.sh/
 type CertSummary struct {
@@ -27,96 +23,81 @@ type CertSummary struct {
 
 // A Result holds the outcome of PreloadableDomain() for a given Domain.
 type Result struct {
-	Domain          string                 `json:"domain"`
-	Header          string                 `json:"header,omitempty"`
-	ParsedHeader    hstspreload.HSTSHeader `json:"parsed_header,omitempty"`
-	Issues          hstspreload.Issues     `json:"issues"`
-	LeafCertSummary CertSummary            `json:"leaf_cert_summary,omitempty"`
+	Domain          string                  `json:"domain"`
+	Header          string                  `json:"header,omitempty"`
+	ParsedHeader    hstspreload.HSTSHeader  `json:"parsed_header,omitempty"`
+	Issues          hstspreload.Issues      `json:"issues"`
+	LeafCertSummary CertSummary             `json:"leaf_cert_summary,omitempty"`
+	TLSFeatures     hstspreload.TLSFeatures `json:"tls_features,omitempty"`
+	Attempts        hstspreload.Attempts    `json:"attempts"`
 }
 
-func worker(in chan string, out chan Result) {
-	for d := range in {
+// check runs hstspreload.EligibleDomainResponseWithOptions() for a domain
+// under a policy, and summarizes the result.
+func check(d string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result {
+	header, issues, resp, details := hstspreload.EligibleDomainResponseWithOptions(d, policy, opts)
 
-		header, issues, resp := hstspreload.EligibleDomainResponse(d, preloadlist.Bulk1Year)
-
-		r := Result{
-			Domain: d,
-			Issues: issues,
-		}
-		if resp != nil &&
-			resp.TLS != nil &&
-			resp.TLS.VerifiedChains != nil &&
-			len(resp.TLS.VerifiedChains) > 0 &&
-			len(resp.TLS.VerifiedChains[0]) > 0 {
-			leafCert := resp.TLS.VerifiedChains[0][0]
-			r.LeafCertSummary = CertSummary{
-				IssuerCommonName: leafCert.Issuer.CommonName,
-				NotBefore:        leafCert.NotBefore,
-				NotAfter:         leafCert.NotAfter,
-				SHA256Hash:       fmt.Sprintf("%x", sha256.Sum256(leafCert.Raw)),
-			}
-		}
-		if header != nil {
-			r.Header = *header
-			ParsedHeader, _ := hstspreload.ParseHeaderString(*header)
-			r.ParsedHeader = ParsedHeader
+	r := Result{
+		Domain:      d,
+		Issues:      issues,
+		TLSFeatures: details.TLSFeatures,
+		Attempts:    details.Attempts,
+	}
+	if resp != nil &&
+		resp.TLS != nil &&
+		resp.TLS.VerifiedChains != nil &&
+		len(resp.TLS.VerifiedChains) > 0 &&
+		len(resp.TLS.VerifiedChains[0]) > 0 {
+		leafCert := resp.TLS.VerifiedChains[0][0]
+		r.LeafCertSummary = CertSummary{
+			IssuerCommonName: leafCert.Issuer.CommonName,
+			NotBefore:        leafCert.NotBefore,
+			NotAfter:         leafCert.NotAfter,
+			SHA256Hash:       fmt.Sprintf("%x", sha256.Sum256(leafCert.Raw)),
 		}
-
-		out <- r
 	}
+	if header != nil {
+		r.Header = *header
+		ParsedHeader, _ := hstspreload.ParseHeaderString(*header)
+		r.ParsedHeader = ParsedHeader
+	}
+
+	return r
 }
 
 // Preloadable runs hstspreload.PreloadableDomain() over the given domains
-// in parallel, and returns the results in an arbitrary order.
+// in parallel using DefaultScanner, and returns the results in an
+// arbitrary order.
 func Preloadable(domains []string) chan Result {
-	in := make(chan string)
-	out := make(chan Result)
-	for i := 0; i < parallelism; i++ {
-		go worker(in, out)
-	}
-
-	go func() {
-		for _, d := range domains {
-			in <- d
-		}
-	}()
-
-	results := make(chan Result)
-	go func() {
-		for range domains {
-			results <- (<-out)
-		}
-		close(in)
-		close(out)
-		close(results)
-	}()
-
-	return results
+	return DefaultScanner.Scan(domains)
 }
 
-// Fprint runs BatchPreloadable on the given domains and prints the results.
+// Fprint runs Preloadable on the given domains and prints the results.
 // Aborts and returns an error if an error in JSON serialization is encountered..
 func Fprint(w io.Writer, domains []string) error {
-	fmt.Fprintln(w, "[")
-	results := Preloadable(domains)
-	for i := range domains {
-		r := <-results
+	return DefaultScanner.Fprint(w, domains)
+}
+
+// Print is a wrapper for Fprint that prints to stdout.
+func Print(domains []string) error {
+	return Fprint(os.Stdout, domains)
+}
+
+// Fprint scans the given domains and prints the results as a JSON array.
+// Aborts and returns an error if an error in JSON serialization is encountered.
+func (s *Scanner) Fprint(w io.Writer, domains []string) error {
+	fmt.Fprint(w, "[")
+	results := s.Scan(domains)
+	comma := ""
+	for r := range results {
 		j, err := json.MarshalIndent(r, "  ", "  ")
 		if err != nil {
 			return err
 		}
-		comma := ""
-		if i != len(domains)-1 {
-			comma = ","
-		}
-		fmt.Fprintf(w, "  %s%s\n", j, comma)
+		fmt.Fprintf(w, "%s\n  %s", comma, j)
+		comma = ","
 	}
-	fmt.Fprintln(w, "]")
+	fmt.Fprintln(w, "\n]")
 
 	return nil
 }
-
-// Print is a wrapper for Fprint that prints to stdout.
-func Print(domains []string) error {
-	return Fprint(os.Stdout, domains)
-}
@@ -0,0 +1,68 @@
+package batch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+func TestCheckAttempts(t *testing.T) {
+	tests := []struct {
+		retryable bool
+		expected  hstspreload.Attempts
+	}{
+		// The initial request is tried with and without certificate
+		// verification.
+		{false, hstspreload.Attempts{HTTPS: 2}},
+		{true, hstspreload.Attempts{HTTPS: 6}},
+	}
+
+	for _, tt := range tests {
+		retryable := tt.retryable
+		retry := &hstspreload.RetryPolicy{
+			MaxAttempts: 3,
+			Retryable:   func(error) bool { return retryable },
+		}
+		// The .invalid TLD never resolves.
+		r := check("attempts.invalid", preloadlist.Bulk1Year, hstspreload.CheckOptions{Retry: retry})
+		if r.Attempts != tt.expected {
+			t.Errorf("Retryable: %t: Attempts = %#v, expected %#v", tt.retryable, r.Attempts, tt.expected)
+		}
+		if len(r.Issues.Errors) != 1 || r.Issues.Errors[0].Code != "domain.tls.cannot_connect" {
+			t.Errorf("Retryable: %t: unexpected issues: %#v", tt.retryable, r.Issues)
+		}
+	}
+}
+
+func TestScannerRetry(t *testing.T) {
+	retry := &hstspreload.RetryPolicy{MaxAttempts: 5}
+	var got *hstspreload.RetryPolicy
+	s := &Scanner{
+		Retry: retry,
+		check: func(domain string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result {
+			got = opts.Retry
+			return Result{Domain: domain, Attempts: hstspreload.Attempts{HTTPS: 2, WWW: 3}}
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := s.FprintNDJSON(&buf, []string{"a.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if got != retry {
+		t.Errorf("The scanner's retry policy was not used")
+	}
+
+	// Attempts survive the round trip through the output.
+	err := ReadResults(&buf, func(r Result) error {
+		if expected := (hstspreload.Attempts{HTTPS: 2, WWW: 3}); r.Attempts != expected {
+			t.Errorf("Attempts = %#v, expected %#v", r.Attempts, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
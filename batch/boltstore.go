@@ -0,0 +1,224 @@
+package batch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/chromium/hstspreload"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of a BoltStore.
+var (
+	// resultsBucket maps domain + 0x00 + big-endian UnixNano to the JSON of
+	// a Result, so that the results for a domain are adjacent and ordered
+	// by time.
+	resultsBucket = []byte("results")
+	// latestBucket maps each domain to the latestRecord of its most recent
+	// result, so that WithIssue does not need to read every result.
+	latestBucket = []byte("latest")
+)
+
+// latestRecord summarizes the most recent result for a domain.
+type latestRecord struct {
+	Time  time.Time               `json:"time"`
+	Codes []hstspreload.IssueCode `json:"codes"`
+}
+
+// A BoltStore is a Store in a single file, using the pure-Go bbolt
+// database. Only one process can open the file at a time.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the store in the file at `path`, creating it if
+// needed.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{resultsBucket, latestBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// domainPrefix returns the prefix of the keys of the results for `domain`.
+func domainPrefix(domain string) []byte {
+	return append([]byte(domain), 0)
+}
+
+func resultKey(domain string, t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(domainPrefix(domain), uint64(t.UnixNano()))
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[len(key)-8:]))).UTC()
+}
+
+// Put implements Store. Concurrent calls are combined into a single
+// transaction, so calling Put for each result of a scan is efficient.
+func (s *BoltStore) Put(t time.Time, results ...Result) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		resultsB := tx.Bucket(resultsBucket)
+		latestB := tx.Bucket(latestBucket)
+		for _, r := range results {
+			value, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := resultsB.Put(resultKey(r.Domain, t), value); err != nil {
+				return err
+			}
+
+			var latest latestRecord
+			if b := latestB.Get([]byte(r.Domain)); b != nil {
+				if err := json.Unmarshal(b, &latest); err != nil {
+					return err
+				}
+				if latest.Time.After(t) {
+					continue
+				}
+			}
+			latest = latestRecord{Time: t}
+			for code := range statusOf(r).codes {
+				latest.Codes = append(latest.Codes, code)
+			}
+			value, err = json.Marshal(latest)
+			if err != nil {
+				return err
+			}
+			if err := latestB.Put([]byte(r.Domain), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// storedResult decodes a key and value of the results bucket.
+func storedResult(k, v []byte) (StoredResult, error) {
+	sr := StoredResult{Time: keyTime(k)}
+	err := json.Unmarshal(v, &sr.Result)
+	return sr, err
+}
+
+// eachBackwards calls `fn` for the results for `domain`, most recent
+// first, until it returns false.
+func (s *BoltStore) eachBackwards(domain string, fn func(StoredResult) bool) error {
+	prefix := domainPrefix(domain)
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(resultsBucket).Cursor()
+		// Keys for `domain` sort before domain + 0x01.
+		end := append([]byte(domain), 1)
+		k, v := c.Seek(end)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Prev() {
+			sr, err := storedResult(k, v)
+			if err != nil {
+				return err
+			}
+			if !fn(sr) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Latest implements Store.
+func (s *BoltStore) Latest(domain string) (StoredResult, bool, error) {
+	var latest StoredResult
+	found := false
+	err := s.eachBackwards(domain, func(sr StoredResult) bool {
+		latest, found = sr, true
+		return false
+	})
+	return latest, found, err
+}
+
+// History implements Store.
+func (s *BoltStore) History(domain string) ([]StoredResult, error) {
+	var history []StoredResult
+	prefix := domainPrefix(domain)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(resultsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			sr, err := storedResult(k, v)
+			if err != nil {
+				return err
+			}
+			history = append(history, sr)
+		}
+		return nil
+	})
+	return history, err
+}
+
+// WithIssue implements Store.
+func (s *BoltStore) WithIssue(code hstspreload.IssueCode) ([]string, error) {
+	var domains []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(latestBucket).ForEach(func(k, v []byte) error {
+			var latest latestRecord
+			if err := json.Unmarshal(v, &latest); err != nil {
+				return err
+			}
+			for _, c := range latest.Codes {
+				if c == code {
+					domains = append(domains, string(k))
+					break
+				}
+			}
+			return nil
+		})
+	})
+	return domains, err
+}
+
+// FirstFailing implements Store.
+func (s *BoltStore) FirstFailing(domain string) (time.Time, bool, error) {
+	var since time.Time
+	failing := false
+	err := s.eachBackwards(domain, func(sr StoredResult) bool {
+		if len(sr.Result.Issues.Errors) == 0 {
+			return false
+		}
+		since, failing = sr.Time, true
+		return true
+	})
+	return since, failing, err
+}
+
+// Domains implements Store.
+func (s *BoltStore) Domains() ([]string, error) {
+	var domains []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(latestBucket).ForEach(func(k, v []byte) error {
+			domains = append(domains, string(k))
+			return nil
+		})
+	})
+	return domains, err
+}
+
+// Close implements Store.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
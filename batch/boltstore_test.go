@@ -0,0 +1,170 @@
+package batch
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chromium/hstspreload"
+)
+
+func openTestStore(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	s, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func storedTimes(history []StoredResult) []time.Time {
+	var times []time.Time
+	for _, sr := range history {
+		times = append(times, sr.Time)
+	}
+	return times
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	s := openTestStore(t, path)
+
+	day := func(n int) time.Time { return time.Date(2026, 1, n, 0, 0, 0, 0, time.UTC) }
+	failing := func(domain string) Result {
+		return issuesResult(domain, []hstspreload.IssueCode{"e.one"}, nil)
+	}
+	passing := func(domain string) Result {
+		return issuesResult(domain, nil, []hstspreload.IssueCode{"w.one"})
+	}
+
+	puts := []struct {
+		t       time.Time
+		results []Result
+	}{
+		{day(1), []Result{failing("a.example"), passing("a.example.org"), failing("b.example")}},
+		{day(2), []Result{failing("a.example"), failing("a.example.org")}},
+		{day(4), []Result{failing("a.example"), passing("b.example")}},
+		// Results for an older scan can be added later.
+		{day(3), []Result{passing("a.example"), failing("b.example")}},
+		{day(5), []Result{failing("a.example")}},
+	}
+	for _, p := range puts {
+		if err := s.Put(p.t, p.results...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reopening the store keeps the results.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, path)
+	defer s.Close()
+
+	history, err := s.History("a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []time.Time{day(1), day(2), day(3), day(4), day(5)}; !reflect.DeepEqual(storedTimes(history), expected) {
+		t.Errorf("History(a.example) at %v, expected %v", storedTimes(history), expected)
+	}
+	if history[2].Result.Domain != "a.example" || len(history[2].Result.Issues.Errors) != 0 {
+		t.Errorf("Unexpected result: %#v", history[2].Result)
+	}
+
+	// Results for domains that share a prefix are kept apart.
+	history, err = s.History("a.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []time.Time{day(1), day(2)}; !reflect.DeepEqual(storedTimes(history), expected) {
+		t.Errorf("History(a.example.org) at %v, expected %v", storedTimes(history), expected)
+	}
+	if history, _ := s.History("a.exampl"); len(history) != 0 {
+		t.Errorf("Expected no history for a prefix of a domain, got %v", history)
+	}
+
+	latestTests := []struct {
+		domain string
+		found  bool
+		t      time.Time
+	}{
+		{"a.example", true, day(5)},
+		{"a.example.org", true, day(2)},
+		{"b.example", true, day(4)},
+		{"c.example", false, time.Time{}},
+		{"", false, time.Time{}},
+	}
+	for _, tt := range latestTests {
+		sr, found, err := s.Latest(tt.domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != tt.found || !sr.Time.Equal(tt.t) || (found && sr.Result.Domain != tt.domain) {
+			t.Errorf("Latest(%q) = %v (%t), expected %v (%t)", tt.domain, sr.Time, found, tt.t, tt.found)
+		}
+	}
+
+	failingTests := []struct {
+		domain  string
+		failing bool
+		since   time.Time
+	}{
+		// a.example passed on day 3.
+		{"a.example", true, day(4)},
+		{"a.example.org", true, day(2)},
+		{"b.example", false, time.Time{}},
+		{"c.example", false, time.Time{}},
+	}
+	for _, tt := range failingTests {
+		since, failing, err := s.FirstFailing(tt.domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failing != tt.failing || !since.Equal(tt.since) {
+			t.Errorf("FirstFailing(%q) = %v (%t), expected %v (%t)", tt.domain, since, failing, tt.since, tt.failing)
+		}
+	}
+
+	issueTests := []struct {
+		code     hstspreload.IssueCode
+		expected []string
+	}{
+		{"e.one", []string{"a.example", "a.example.org"}},
+		// The result for day 3 is older than the one for day 4.
+		{"w.one", []string{"b.example"}},
+		{"e.other", nil},
+	}
+	for _, tt := range issueTests {
+		domains, err := s.WithIssue(tt.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(domains, tt.expected) {
+			t.Errorf("WithIssue(%s) = %v, expected %v", tt.code, domains, tt.expected)
+		}
+	}
+
+	domains, err := s.Domains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.example", "a.example.org", "b.example"}; !reflect.DeepEqual(domains, expected) {
+		t.Errorf("Domains() = %v, expected %v", domains, expected)
+	}
+}
+
+func TestScannerStore(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "store.db"))
+	defer s.Close()
+
+	scanner := &Scanner{Workers: 4, Store: s, check: stubCheck(nil)}
+	collectDomains(scanner.Scan(testDomains(10)))
+
+	for _, d := range testDomains(10) {
+		if _, found, err := s.Latest(d); err != nil || !found {
+			t.Errorf("No result for %s in the store (%v)", d, err)
+		}
+	}
+}
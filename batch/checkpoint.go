@@ -0,0 +1,125 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// A Checkpoint records the results of a scan in a file, one JSON object per
+// line, so that an interrupted scan can be resumed. Set Scanner.Checkpoint
+// to use it.
+//
+// A Checkpoint is safe for concurrent use.
+type Checkpoint struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+	err  error
+}
+
+// OpenCheckpoint opens the checkpoint file at `path`, creating it if needed.
+//
+// If `resume` is true, the results already in the file are kept and the
+// domains they are for are skipped by the scan. A partial last line, left
+// by an interrupted write, is discarded. If `resume` is false, the file is
+// emptied so that the scan starts over.
+func OpenCheckpoint(path string, resume bool) (*Checkpoint, error) {
+	flags := os.O_RDWR | os.O_CREATE
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checkpoint{f: f, done: make(map[string]bool)}
+	if err := c.load(path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the existing results, and positions the file after the last
+// complete one.
+func (c *Checkpoint) load(path string) error {
+	br := bufio.NewReader(c.f)
+	var offset int64
+	for lineNum := 1; ; lineNum++ {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A line without a newline was not completely written.
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			var r Result
+			if err := json.Unmarshal(line, &r); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNum, err)
+			}
+			c.done[r.Domain] = true
+		}
+		offset += int64(len(line))
+	}
+
+	if err := c.f.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := c.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Done returns whether the checkpoint has a result for `domain`.
+func (c *Checkpoint) Done(domain string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[domain]
+}
+
+// Len returns the number of domains that the checkpoint has results for.
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+// Record appends a result to the checkpoint. After the first error, Record
+// does nothing and returns that error again; it is also returned by Close.
+func (c *Checkpoint) Record(r Result) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if _, c.err = c.f.Write(append(line, '\n')); c.err != nil {
+		return c.err
+	}
+	c.done[r.Domain] = true
+	return nil
+}
+
+// Close closes the checkpoint file, and returns the first error
+// encountered while recording results.
+func (c *Checkpoint) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.f.Close(); c.err == nil {
+		c.err = err
+	}
+	return c.err
+}
@@ -0,0 +1,95 @@
+package batch
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.ndjson")
+	// The last line was only partially written before an interruption.
+	existing := `{"domain":"a.example","issues":{"errors":[],"warnings":[]}}
+{"domain":"b.example","issues":{"errors":[],"warnings":[]}}
+
+{"domain":"c.exa`
+	if err := os.WriteFile(path, []byte(existing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 2 || !c.Done("a.example") || !c.Done("b.example") || c.Done("c.example") {
+		t.Errorf("Unexpected checkpoint contents: %v", c.done)
+	}
+
+	var checked []string
+	s := &Scanner{
+		Checkpoint: c,
+		check:      stubCheck(func(d string) { checked = append(checked, d) }),
+	}
+	results := collectDomains(s.Scan([]string{"a.example", "b.example", "c.example", "d.example"}))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Domains with results in the checkpoint are skipped, and produce no
+	// result.
+	sort.Strings(checked)
+	if strings.Join(checked, " ") != "c.example d.example" {
+		t.Errorf("Unexpected domains checked: %v", checked)
+	}
+	if len(results) != 2 {
+		t.Errorf("Unexpected results: %v", results)
+	}
+
+	// The partial line is replaced by the new results.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 5 || strings.Contains(string(b), `"c.exa"`) || !strings.HasSuffix(string(b), "}\n") {
+		t.Errorf("Unexpected checkpoint file:\n%s", b)
+	}
+
+	c, err = OpenCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Len() != 4 {
+		t.Errorf("Expected 4 domains after resuming again, got %d", c.Len())
+	}
+}
+
+func TestCheckpointNoResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.ndjson")
+	if err := os.WriteFile(path, []byte(`{"domain":"a.example"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Len() != 0 {
+		t.Errorf("Expected an empty checkpoint, got %d domains", c.Len())
+	}
+}
+
+func TestCheckpointInvalidLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.ndjson")
+	if err := os.WriteFile(path, []byte("{\"domain\":\"a.example\"}\nnot json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenCheckpoint(path, true); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("Expected an error for line 2, got %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default settings of a distributed scan.
+const (
+	// DefaultShardSize is the number of domains in each shard.
+	DefaultShardSize = 100
+	// DefaultLeaseTimeout is how long a worker has to return the results
+	// of a shard before the shard is handed out again.
+	DefaultLeaseTimeout = 10 * time.Minute
+	// defaultPollInterval is how long a worker waits before asking for a
+	// shard again when all remaining shards are leased.
+	defaultPollInterval = 5 * time.Second
+)
+
+// Paths of the coordinator's HTTP/JSON protocol. Both take POST requests.
+const (
+	// shardPath leases a shard. It responds with a shardLease (200), with
+	// no content (204) if all remaining shards are leased, or with 410 Gone
+	// if the scan is complete.
+	shardPath = "/shard"
+	// resultsPath takes a shardResults for a leased shard. It responds with
+	// no content (204) if they are accepted, 409 Conflict if the shard is
+	// already done, 403 Forbidden if the lease token is invalid, or 400 Bad
+	// Request if the results do not match the shard.
+	resultsPath = "/results"
+)
+
+// shardLease is a shard handed out to a worker.
+type shardLease struct {
+	ID      int      `json:"id"`
+	Lease   string   `json:"lease"`
+	Domains []string `json:"domains"`
+}
+
+// shardResults are the results of a leased shard.
+type shardResults struct {
+	ID      int      `json:"id"`
+	Lease   string   `json:"lease"`
+	Results []Result `json:"results"`
+}
+
+type shardState int
+
+const (
+	shardPending shardState = iota
+	shardLeased
+	shardDone
+)
+
+type shard struct {
+	domains []string
+	state   shardState
+	// leases are the tokens of all the leases of the shard, including the
+	// expired ones.
+	leases  map[string]bool
+	expires time.Time
+}
+
+// A Coordinator splits the domains of a scan into shards, hands them out
+// to Workers over HTTP, and collects their results. It implements
+// http.Handler.
+//
+// A shard whose results do not come back within the lease timeout (e.g.
+// because its worker died) is handed out again. Results are accepted for
+// any lease of a shard, including an expired one, since a slow worker did
+// the same work as the one that replaced it; the first results are kept.
+// Results must come with a lease token, and have exactly one result for
+// each domain of the shard.
+type Coordinator struct {
+	leaseTimeout time.Duration
+	results      chan Result
+
+	mu     sync.Mutex
+	shards []*shard
+	done   int
+	// sending counts the accepted shards whose results are being sent,
+	// so that the results channel is only closed after the last one.
+	sending sync.WaitGroup
+
+	// now returns the current time. If nil, time.Now is used.
+	now func() time.Time
+}
+
+// NewCoordinator returns a Coordinator for a scan of `domains`, split into
+// shards of `shardSize` domains whose leases last `leaseTimeout`. Values
+// below 1 select DefaultShardSize and DefaultLeaseTimeout.
+func NewCoordinator(domains []string, shardSize int, leaseTimeout time.Duration) *Coordinator {
+	if shardSize < 1 {
+		shardSize = DefaultShardSize
+	}
+	if leaseTimeout <= 0 {
+		leaseTimeout = DefaultLeaseTimeout
+	}
+
+	c := &Coordinator{
+		leaseTimeout: leaseTimeout,
+		results:      make(chan Result),
+	}
+	for start := 0; start < len(domains); start += shardSize {
+		end := start + shardSize
+		if end > len(domains) {
+			end = len(domains)
+		}
+		c.shards = append(c.shards, &shard{domains: domains[start:end], leases: make(map[string]bool)})
+	}
+	if len(c.shards) == 0 {
+		close(c.results)
+	}
+	return c
+}
+
+// Results returns the results sent by workers, in an arbitrary order. The
+// channel is closed once every shard is done. Workers are blocked until
+// the results of their shard have been received.
+func (c *Coordinator) Results() <-chan Result {
+	return c.results
+}
+
+// Progress returns the number of shards that are done, and the total
+// number of shards.
+func (c *Coordinator) Progress() (done int, total int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done, len(c.shards)
+}
+
+func (c *Coordinator) currentTime() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func newLeaseToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// lease leases the next available shard. It returns false if there is
+// none, and whether the scan is complete.
+func (c *Coordinator) lease() (l shardLease, ok bool, complete bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done == len(c.shards) {
+		return shardLease{}, false, true
+	}
+	now := c.currentTime()
+	for id, s := range c.shards {
+		if s.state == shardPending || (s.state == shardLeased && now.After(s.expires)) {
+			token := newLeaseToken()
+			s.state = shardLeased
+			s.leases[token] = true
+			s.expires = now.Add(c.leaseTimeout)
+			return shardLease{ID: id, Lease: token, Domains: s.domains}, true, false
+		}
+	}
+	return shardLease{}, false, false
+}
+
+// Errors returned by Coordinator.complete.
+var (
+	// errShardDone is returned when results are sent for a shard that is
+	// already done.
+	errShardDone = errors.New("shard already done")
+	// errInvalidLease is returned when results are sent with a lease token
+	// that was not issued for the shard.
+	errInvalidLease = errors.New("invalid lease")
+)
+
+// matchesDomains returns whether `results` has exactly one result for each
+// of `domains`.
+func matchesDomains(results []Result, domains []string) bool {
+	if len(results) != len(domains) {
+		return false
+	}
+	remaining := make(map[string]int)
+	for _, d := range domains {
+		remaining[d]++
+	}
+	for _, r := range results {
+		if remaining[r.Domain] == 0 {
+			return false
+		}
+		remaining[r.Domain]--
+	}
+	return true
+}
+
+// complete accepts the results of a shard, and sends them to the results
+// channel.
+func (c *Coordinator) complete(r shardResults) error {
+	c.mu.Lock()
+	if r.ID < 0 || r.ID >= len(c.shards) {
+		c.mu.Unlock()
+		return fmt.Errorf("unknown shard %d", r.ID)
+	}
+	s := c.shards[r.ID]
+	if s.state == shardDone {
+		c.mu.Unlock()
+		return errShardDone
+	}
+	if !s.leases[r.Lease] {
+		c.mu.Unlock()
+		return fmt.Errorf("%w for shard %d", errInvalidLease, r.ID)
+	}
+	if !matchesDomains(r.Results, s.domains) {
+		c.mu.Unlock()
+		return fmt.Errorf("the results do not match the domains of shard %d", r.ID)
+	}
+	s.state = shardDone
+	c.done++
+	last := c.done == len(c.shards)
+	c.sending.Add(1)
+	c.mu.Unlock()
+
+	for _, res := range r.Results {
+		c.results <- res
+	}
+	c.sending.Done()
+	if last {
+		go func() {
+			c.sending.Wait()
+			close(c.results)
+		}()
+	}
+	return nil
+}
+
+// ServeHTTP implements the coordinator's side of the protocol.
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case shardPath:
+		l, ok, complete := c.lease()
+		switch {
+		case complete:
+			w.WriteHeader(http.StatusGone)
+		case !ok:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(l)
+		}
+
+	case resultsPath:
+		var res shardResults
+		if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := c.complete(res)
+		switch {
+		case errors.Is(err, errShardDone):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, errInvalidLease):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// A Worker scans shards handed out by a Coordinator.
+type Worker struct {
+	// Coordinator is the base URL of the coordinator, e.g.
+	// "http://localhost:8080".
+	Coordinator string
+	// Scanner is used to scan each shard. If nil, DefaultScanner is used.
+	// Its Checkpoint is ignored, since the coordinator needs a result for
+	// every domain of a shard.
+	Scanner *Scanner
+	// Client is used for requests to the coordinator. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+	// PollInterval is how long to wait before asking for a shard again
+	// when all remaining shards are leased by other workers. If 0, 5
+	// seconds are used.
+	PollInterval time.Duration
+}
+
+func (w *Worker) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return http.DefaultClient
+}
+
+// post sends a POST request with a JSON body (if `body` is not nil) to the
+// coordinator.
+func (w *Worker) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(w.Coordinator, "/")+path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return w.client().Do(req)
+}
+
+// Run scans shards until the coordinator reports that the scan is
+// complete (and returns nil), `ctx` is cancelled, or the coordinator
+// cannot be reached.
+func (w *Worker) Run(ctx context.Context) error {
+	scanner := *DefaultScanner
+	if w.Scanner != nil {
+		scanner = *w.Scanner
+	}
+	scanner.Checkpoint = nil
+	poll := w.PollInterval
+	if poll <= 0 {
+		poll = defaultPollInterval
+	}
+
+	for {
+		resp, err := w.post(ctx, shardPath, nil)
+		if err != nil {
+			return err
+		}
+		var l shardLease
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&l)
+		case http.StatusNoContent:
+		case http.StatusGone:
+			resp.Body.Close()
+			return nil
+		default:
+			err = fmt.Errorf("coordinator returned status code %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusNoContent {
+			select {
+			case <-time.After(poll):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		res := shardResults{ID: l.ID, Lease: l.Lease, Results: []Result{}}
+		for r := range scanner.Scan(l.Domains) {
+			res.Results = append(res.Results, r)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		resp, err = w.post(ctx, resultsPath, res)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// A conflict means that another worker finished the shard first.
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusConflict {
+			return fmt.Errorf("coordinator rejected the results of shard %d with status code %d", l.ID, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+// fakeClock is a time that tests can advance.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// waitFor polls `cond` until it is true, or fails the test after a few
+// seconds.
+func waitFor(t *testing.T, description string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+	}
+}
+
+func TestCoordinatorAndWorkers(t *testing.T) {
+	domains := testDomains(10)
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := NewCoordinator(domains, 3, time.Minute)
+	c.now = clock.now
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	counts := make(map[string]int)
+	collected := make(chan struct{})
+	go func() {
+		for r := range c.Results() {
+			if r.Header != "survivor" {
+				t.Errorf("Got a result for %s from the dead worker", r.Domain)
+			}
+			counts[r.Domain]++
+		}
+		close(collected)
+	}()
+
+	// The first worker leases a shard and dies while scanning it.
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	ctx, kill := context.WithCancel(context.Background())
+	dead := &Worker{
+		Coordinator: server.URL,
+		Scanner: &Scanner{Workers: 3, check: stubCheck(func(string) {
+			started <- struct{}{}
+			<-release
+		})},
+	}
+	deadErr := make(chan error)
+	go func() { deadErr <- dead.Run(ctx) }()
+	<-started
+
+	survivor := &Worker{
+		Coordinator:  server.URL,
+		PollInterval: time.Millisecond,
+		Scanner: &Scanner{Workers: 2, check: func(domain string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result {
+			return Result{Domain: domain, Header: "survivor"}
+		}},
+	}
+	survivorErr := make(chan error)
+	go func() { survivorErr <- survivor.Run(context.Background()) }()
+
+	// The other shards are done, and the survivor waits for the lease of
+	// the dead worker's shard to expire.
+	waitFor(t, "the other shards", func() bool {
+		done, total := c.Progress()
+		return done == total-1
+	})
+	kill()
+	close(release)
+	if err := <-deadErr; err != context.Canceled {
+		t.Errorf("Expected the dead worker to stop with context.Canceled, got %v", err)
+	}
+
+	clock.advance(2 * time.Minute)
+	if err := <-survivorErr; err != nil {
+		t.Errorf("Unexpected error from the surviving worker: %s", err)
+	}
+
+	select {
+	case <-collected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The results channel was not closed")
+	}
+	for _, d := range domains {
+		if counts[d] != 1 {
+			t.Errorf("Got %d results for %s, expected 1", counts[d], d)
+		}
+	}
+	if len(counts) != len(domains) {
+		t.Errorf("Got results for %d domains, expected %d", len(counts), len(domains))
+	}
+}
+
+func postJSON(t *testing.T, url string, body interface{}) *http.Response {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCoordinatorRejectsResults(t *testing.T) {
+	c := NewCoordinator([]string{"a.example", "b.example"}, 2, time.Minute)
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+shardPath, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l shardLease
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	tests := []struct {
+		description string
+		results     shardResults
+		status      int
+	}{
+		{"unknown shard", shardResults{ID: 1, Lease: l.Lease}, http.StatusBadRequest},
+		{"made-up lease", shardResults{ID: 0, Lease: "made-up", Results: []Result{{Domain: "a.example"}, {Domain: "b.example"}}}, http.StatusForbidden},
+		{"missing domain", shardResults{ID: 0, Lease: l.Lease, Results: []Result{{Domain: "a.example"}}}, http.StatusBadRequest},
+		{"duplicate domain", shardResults{ID: 0, Lease: l.Lease, Results: []Result{{Domain: "a.example"}, {Domain: "a.example"}}}, http.StatusBadRequest},
+		{"other domain", shardResults{ID: 0, Lease: l.Lease, Results: []Result{{Domain: "a.example"}, {Domain: "c.example"}}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if resp := postJSON(t, server.URL+resultsPath, tt.results); resp.StatusCode != tt.status {
+			t.Errorf("%s: status code %d, expected %d", tt.description, resp.StatusCode, tt.status)
+		}
+	}
+
+	valid := shardResults{ID: 0, Lease: l.Lease, Results: []Result{{Domain: "b.example"}, {Domain: "a.example"}}}
+	go func() {
+		for range c.Results() {
+		}
+	}()
+	if resp := postJSON(t, server.URL+resultsPath, valid); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Valid results: status code %d, expected %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if resp := postJSON(t, server.URL+resultsPath, valid); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Results for a done shard: status code %d, expected %d", resp.StatusCode, http.StatusConflict)
+	}
+
+	resp, err = http.Post(server.URL+shardPath, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("Lease after the scan: status code %d, expected %d", resp.StatusCode, http.StatusGone)
+	}
+}
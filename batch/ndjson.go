@@ -0,0 +1,78 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// FprintNDJSON scans the given domains and prints each result as a single
+// line of JSON (NDJSON) as soon as it is available. Unlike Fprint, the
+// output is valid up to the last complete line if the scan is interrupted.
+func (s *Scanner) FprintNDJSON(w io.Writer, domains []string) error {
+	return s.writeNDJSON(w, func(send func(string) bool) error {
+		for _, d := range domains {
+			if !send(d) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// StreamNDJSON reads domains from `r`, one per line, and prints each
+// result as a single line of JSON (NDJSON) as soon as it is available.
+// Blank lines are ignored.
+//
+// Domains are read as workers become available, so memory use does not
+// depend on the size of the input.
+func (s *Scanner) StreamNDJSON(w io.Writer, r io.Reader) error {
+	return s.writeNDJSON(w, func(send func(string) bool) error {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			d := strings.TrimSpace(sc.Text())
+			if d == "" {
+				continue
+			}
+			if !send(d) {
+				return nil
+			}
+		}
+		return sc.Err()
+	})
+}
+
+// writeNDJSON scans the domains passed to `send` by `feed`, and writes the
+// results to `w`. If writing fails, `send` returns false and the remaining
+// checks in progress are discarded.
+func (s *Scanner) writeNDJSON(w io.Writer, feed func(send func(string) bool) error) error {
+	in := make(chan string)
+	stop := make(chan struct{})
+	feedErr := make(chan error, 1)
+	go func() {
+		defer close(in)
+		feedErr <- feed(func(d string) bool {
+			select {
+			case in <- d:
+				return true
+			case <-stop:
+				return false
+			}
+		})
+	}()
+
+	results := s.ScanStream(in)
+	enc := json.NewEncoder(w)
+	for r := range results {
+		if err := enc.Encode(r); err != nil {
+			close(stop)
+			go func() {
+				for range results {
+				}
+			}()
+			return err
+		}
+	}
+	return <-feedErr
+}
@@ -0,0 +1,91 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// decodeNDJSON returns the sorted domains of the results in `output`, one
+// JSON object per line.
+func decodeNDJSON(t *testing.T, output string) []string {
+	t.Helper()
+	var domains []string
+	sc := bufio.NewScanner(strings.NewReader(output))
+	for sc.Scan() {
+		var r Result
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("Invalid line %q: %s", sc.Text(), err)
+		}
+		domains = append(domains, r.Domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func TestFprintNDJSON(t *testing.T) {
+	s := &Scanner{Workers: 3, check: stubCheck(nil)}
+	var buf bytes.Buffer
+	if err := s.FprintNDJSON(&buf, testDomains(5)); err != nil {
+		t.Fatal(err)
+	}
+	if domains := decodeNDJSON(t, buf.String()); strings.Join(domains, " ") != strings.Join(testDomains(5), " ") {
+		t.Errorf("Unexpected results: %v", domains)
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", nil},
+		{"a.example\nb.example\n", []string{"a.example", "b.example"}},
+		// Blank lines and surrounding space are ignored, and the last line
+		// does not need a newline.
+		{"  a.example \n\n\t\nb.example", []string{"a.example", "b.example"}},
+	}
+
+	for _, tt := range tests {
+		s := &Scanner{Workers: 2, check: stubCheck(nil)}
+		var buf bytes.Buffer
+		if err := s.StreamNDJSON(&buf, strings.NewReader(tt.input)); err != nil {
+			t.Fatal(err)
+		}
+		if domains := decodeNDJSON(t, buf.String()); strings.Join(domains, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("%q: got %v, expected %v", tt.input, domains, tt.expected)
+		}
+	}
+}
+
+// failingWriter fails after `n` writes.
+type failingWriter struct {
+	n int
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errWrite
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestStreamNDJSONWriteError(t *testing.T) {
+	checked := make(chan string, 100)
+	s := &Scanner{Workers: 2, check: stubCheck(func(d string) { checked <- d })}
+	input := strings.Repeat("a.example\n", 50)
+	if err := s.StreamNDJSON(&failingWriter{n: 2}, strings.NewReader(input)); err != errWrite {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+	// Reading the input stops after the error.
+	if len(checked) == 50 {
+		t.Errorf("All domains were checked despite the write error")
+	}
+}
@@ -0,0 +1,86 @@
+package batch
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScannerOrdered(t *testing.T) {
+	domains := testDomains(12)
+	delays := make(map[string]time.Duration)
+	for i, d := range domains {
+		// Later domains finish first.
+		delays[d] = time.Duration(len(domains)-i) * 3 * time.Millisecond
+	}
+
+	tests := []struct {
+		workers int
+		skip    []string
+	}{
+		{1, nil},
+		{4, nil},
+		{12, nil},
+		// Skipped domains keep their place without producing a result.
+		{4, []string{domains[0], domains[5]}},
+	}
+
+	for _, tt := range tests {
+		s := &Scanner{
+			Workers: tt.workers,
+			Ordered: true,
+			check:   stubCheck(func(d string) { time.Sleep(delays[d]) }),
+		}
+		if tt.skip != nil {
+			s.Checkpoint = &Checkpoint{done: make(map[string]bool)}
+			for _, d := range tt.skip {
+				s.Checkpoint.done[d] = true
+			}
+		}
+
+		var expected []string
+		for _, d := range domains {
+			if s.Checkpoint == nil || !s.Checkpoint.Done(d) {
+				expected = append(expected, d)
+			}
+		}
+		// A checkpoint without a file cannot record results.
+		if s.Checkpoint != nil {
+			s.Checkpoint.err = errWrite
+		}
+
+		got := collectDomains(s.Scan(domains))
+		if strings.Join(got, " ") != strings.Join(expected, " ") {
+			t.Errorf("Workers: %d, skipped: %v: got %v, expected %v", tt.workers, tt.skip, got, expected)
+		}
+	}
+}
+
+func TestScannerOrderedWindow(t *testing.T) {
+	release := make(chan struct{})
+	var started int32
+	s := &Scanner{
+		Workers: 2,
+		Ordered: true,
+		check: stubCheck(func(d string) {
+			atomic.AddInt32(&started, 1)
+			if d == "d00.example" {
+				<-release
+			}
+		}),
+	}
+
+	results := s.Scan(testDomains(20))
+	time.Sleep(50 * time.Millisecond)
+	// The first domain holds up the scan once the window of 4 × Workers
+	// domains is full.
+	if n := atomic.LoadInt32(&started); n != 2*reorderWindowPerWorker {
+		t.Errorf("%d checks were started while the first one was pending, expected %d", n, 2*reorderWindowPerWorker)
+	}
+	close(release)
+
+	if got := collectDomains(results); strings.Join(got, " ") != strings.Join(testDomains(20), " ") {
+		t.Errorf("Unexpected results: %v", got)
+	}
+}
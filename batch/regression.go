@@ -0,0 +1,251 @@
+package batch
+
+import (
+	"sort"
+	"time"
+
+	"github.com/chromium/hstspreload"
+)
+
+// resultStatus is the part of a Result that is needed to compare scans.
+type resultStatus struct {
+	failing bool
+	codes   map[hstspreload.IssueCode]bool
+}
+
+func statusOf(r Result) resultStatus {
+	s := resultStatus{
+		failing: len(r.Issues.Errors) > 0,
+		codes:   make(map[hstspreload.IssueCode]bool),
+	}
+	for _, list := range [][]hstspreload.Issue{r.Issues.Errors, r.Issues.Warnings} {
+		for _, issue := range list {
+			s.codes[issue.Code] = true
+		}
+	}
+	return s
+}
+
+// A Regression describes how the result for a domain got worse between
+// two scans.
+type Regression struct {
+	Domain string `json:"domain"`
+	// NewlyFailing is set if the domain had no errors in the earlier scan,
+	// but has errors now.
+	NewlyFailing bool `json:"newly_failing"`
+	// NewIssueCodes are the codes of the errors and warnings that the
+	// domain has now, but did not have in the earlier scan, in
+	// alphabetical order.
+	NewIssueCodes []hstspreload.IssueCode `json:"new_issue_codes"`
+}
+
+// A Baseline holds the results of an earlier scan, to find the domains
+// that regressed since then.
+//
+// Only the outcome and issue codes of each result are kept, so that a
+// baseline for a full scan of the preload list fits in memory.
+type Baseline struct {
+	statuses map[string]resultStatus
+}
+
+// NewBaseline returns an empty Baseline.
+func NewBaseline() *Baseline {
+	return &Baseline{statuses: make(map[string]resultStatus)}
+}
+
+// NewBaselineFromStore returns a Baseline of the most recent result of
+// each domain in `store` that was recorded before `before`. Comparing a
+// scan with it finds the domains that regressed since their previous
+// result, even if they were not all checked in the same scan.
+func NewBaselineFromStore(store Store, before time.Time) (*Baseline, error) {
+	domains, err := store.Domains()
+	if err != nil {
+		return nil, err
+	}
+
+	b := NewBaseline()
+	for _, domain := range domains {
+		history, err := store.History(domain)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Time.Before(before) {
+				b.Add(history[i].Result)
+				break
+			}
+		}
+	}
+	return b, nil
+}
+
+// Add adds a result of the earlier scan to the baseline.
+func (b *Baseline) Add(r Result) {
+	b.statuses[r.Domain] = statusOf(r)
+}
+
+// Len returns the number of domains in the baseline.
+func (b *Baseline) Len() int {
+	return len(b.statuses)
+}
+
+// Compare compares a result of a later scan with the baseline, and
+// returns how the domain regressed. It returns false if the domain is not
+// in the baseline, or did not regress.
+func (b *Baseline) Compare(r Result) (Regression, bool) {
+	old, ok := b.statuses[r.Domain]
+	if !ok {
+		return Regression{}, false
+	}
+	current := statusOf(r)
+
+	reg := Regression{
+		Domain:       r.Domain,
+		NewlyFailing: !old.failing && current.failing,
+	}
+	for code := range current.codes {
+		if !old.codes[code] {
+			reg.NewIssueCodes = append(reg.NewIssueCodes, code)
+		}
+	}
+	sort.Slice(reg.NewIssueCodes, func(i, j int) bool {
+		return reg.NewIssueCodes[i] < reg.NewIssueCodes[j]
+	})
+
+	return reg, reg.NewlyFailing || len(reg.NewIssueCodes) > 0
+}
+
+// A RemovalSignal is a property of a domain's HSTS header that suggests
+// that the site no longer wants to be preloaded.
+type RemovalSignal string
+
+// Possible RemovalSignal values.
+const (
+	// SignalMaxAgeZero indicates a header with max-age=0, which tells
+	// browsers to forget the HSTS policy.
+	SignalMaxAgeZero RemovalSignal = "max_age_zero"
+	// SignalNoPreload indicates a header without the preload directive.
+	SignalNoPreload RemovalSignal = "no_preload"
+	// SignalRemovableHeader indicates a header that meets the requirements
+	// for removal from the preload list (see hstspreload.RemovableHeader).
+	SignalRemovableHeader RemovalSignal = "removable_header"
+)
+
+// RemovalSignals returns the removal signals of a result, in the order of
+// the constants above. Results without a header have none, since a domain
+// that could not be checked has not shown anything.
+func RemovalSignals(r Result) []RemovalSignal {
+	if r.Header == "" {
+		return nil
+	}
+	var signals []RemovalSignal
+	if r.ParsedHeader.MaxAge != nil && r.ParsedHeader.MaxAge.Seconds == 0 {
+		signals = append(signals, SignalMaxAgeZero)
+	}
+	if !r.ParsedHeader.Preload {
+		signals = append(signals, SignalNoPreload)
+	}
+	if len(hstspreload.RemovableHeaderString(r.Header).Errors) == 0 {
+		signals = append(signals, SignalRemovableHeader)
+	}
+	return signals
+}
+
+// A Streak is a number of consecutive scans, up to the latest one, in which
+// a domain showed a removal signal.
+type Streak struct {
+	Domain string        `json:"domain"`
+	Signal RemovalSignal `json:"signal"`
+	Scans  int           `json:"scans"`
+}
+
+// A StreakTracker follows the removal signals of domains over a series of
+// scans. For each scan, call Add for its results and then EndScan.
+//
+// A domain that is missing from a scan, or that could not be checked,
+// ends its streaks.
+type StreakTracker struct {
+	streaks map[string]map[RemovalSignal]int
+	current map[string][]RemovalSignal
+	scans   int
+}
+
+// NewStreakTracker returns a StreakTracker without any scans.
+func NewStreakTracker() *StreakTracker {
+	return &StreakTracker{
+		streaks: make(map[string]map[RemovalSignal]int),
+		current: make(map[string][]RemovalSignal),
+	}
+}
+
+// Add adds a result of the current scan.
+func (t *StreakTracker) Add(r Result) {
+	if signals := RemovalSignals(r); len(signals) > 0 {
+		t.current[r.Domain] = signals
+	}
+}
+
+// EndScan finishes the current scan, extending the streaks of the signals
+// it showed and ending the others.
+func (t *StreakTracker) EndScan() {
+	next := make(map[string]map[RemovalSignal]int)
+	for domain, signals := range t.current {
+		next[domain] = make(map[RemovalSignal]int)
+		for _, s := range signals {
+			next[domain][s] = t.streaks[domain][s] + 1
+		}
+	}
+	t.streaks = next
+	t.current = make(map[string][]RemovalSignal)
+	t.scans++
+}
+
+// Scans returns the number of finished scans.
+func (t *StreakTracker) Scans() int {
+	return t.scans
+}
+
+// Streaks returns the streaks of at least `n` scans, sorted by domain and
+// signal.
+func (t *StreakTracker) Streaks(n int) []Streak {
+	var streaks []Streak
+	for domain, signals := range t.streaks {
+		for s, scans := range signals {
+			if scans >= n {
+				streaks = append(streaks, Streak{Domain: domain, Signal: s, Scans: scans})
+			}
+		}
+	}
+	sort.Slice(streaks, func(i, j int) bool {
+		if streaks[i].Domain != streaks[j].Domain {
+			return streaks[i].Domain < streaks[j].Domain
+		}
+		return streaks[i].Signal < streaks[j].Signal
+	})
+	return streaks
+}
+
+// StoreStreaks returns the streaks of at least `n` scans in `store`, sorted
+// by domain and signal. Each result recorded for a domain counts as a scan
+// of that domain, so the streaks are those of its most recent results.
+func StoreStreaks(store Store, n int) ([]Streak, error) {
+	domains, err := store.Domains()
+	if err != nil {
+		return nil, err
+	}
+
+	var streaks []Streak
+	for _, domain := range domains {
+		history, err := store.History(domain)
+		if err != nil {
+			return nil, err
+		}
+		tracker := NewStreakTracker()
+		for _, sr := range history {
+			tracker.Add(sr.Result)
+			tracker.EndScan()
+		}
+		streaks = append(streaks, tracker.Streaks(n)...)
+	}
+	return streaks, nil
+}
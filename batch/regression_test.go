@@ -0,0 +1,304 @@
+package batch
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chromium/hstspreload"
+)
+
+func issuesResult(domain string, errors []hstspreload.IssueCode, warnings []hstspreload.IssueCode) Result {
+	r := Result{Domain: domain}
+	for _, code := range errors {
+		r.Issues.Errors = append(r.Issues.Errors, issue(code))
+	}
+	for _, code := range warnings {
+		r.Issues.Warnings = append(r.Issues.Warnings, issue(code))
+	}
+	return r
+}
+
+func headerResult(domain string, header string) Result {
+	parsed, _ := hstspreload.ParseHeaderString(header)
+	return Result{Domain: domain, Header: header, ParsedHeader: parsed}
+}
+
+func TestBaselineCompare(t *testing.T) {
+	b := NewBaseline()
+	b.Add(issuesResult("passing.example", nil, nil))
+	b.Add(issuesResult("warning.example", nil, []hstspreload.IssueCode{"w.one"}))
+	b.Add(issuesResult("failing.example", []hstspreload.IssueCode{"e.one"}, nil))
+	if b.Len() != 3 {
+		t.Errorf("Len() = %d, expected 3", b.Len())
+	}
+
+	tests := []struct {
+		description string
+		result      Result
+		regressed   bool
+		expected    Regression
+	}{
+		{
+			"not in baseline",
+			issuesResult("new.example", []hstspreload.IssueCode{"e.one"}, nil),
+			false, Regression{},
+		},
+		{
+			"still passing",
+			issuesResult("passing.example", nil, nil),
+			false, Regression{Domain: "passing.example"},
+		},
+		{
+			"newly failing",
+			issuesResult("passing.example", []hstspreload.IssueCode{"e.two", "e.one"}, nil),
+			true, Regression{Domain: "passing.example", NewlyFailing: true, NewIssueCodes: []hstspreload.IssueCode{"e.one", "e.two"}},
+		},
+		{
+			"new warning",
+			issuesResult("warning.example", nil, []hstspreload.IssueCode{"w.one", "w.two"}),
+			true, Regression{Domain: "warning.example", NewIssueCodes: []hstspreload.IssueCode{"w.two"}},
+		},
+		{
+			"warning fixed",
+			issuesResult("warning.example", nil, nil),
+			false, Regression{Domain: "warning.example"},
+		},
+		{
+			"still failing with the same error",
+			issuesResult("failing.example", []hstspreload.IssueCode{"e.one"}, nil),
+			false, Regression{Domain: "failing.example"},
+		},
+		{
+			"still failing with a new error",
+			issuesResult("failing.example", []hstspreload.IssueCode{"e.two"}, nil),
+			true, Regression{Domain: "failing.example", NewIssueCodes: []hstspreload.IssueCode{"e.two"}},
+		},
+	}
+
+	for _, tt := range tests {
+		reg, regressed := b.Compare(tt.result)
+		if regressed != tt.regressed || !reflect.DeepEqual(reg, tt.expected) {
+			t.Errorf("%s: got %#v (%t), expected %#v (%t)", tt.description, reg, regressed, tt.expected, tt.regressed)
+		}
+	}
+}
+
+func TestRemovalSignals(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []RemovalSignal
+	}{
+		{"", nil},
+		{"max-age=31536000; includeSubDomains; preload", nil},
+		{"max-age=31536000; includeSubDomains", []RemovalSignal{SignalNoPreload, SignalRemovableHeader}},
+		{"max-age=0", []RemovalSignal{SignalMaxAgeZero, SignalNoPreload, SignalRemovableHeader}},
+		{"max-age=0; includeSubDomains; preload", []RemovalSignal{SignalMaxAgeZero}},
+	}
+
+	for _, tt := range tests {
+		if signals := RemovalSignals(headerResult("a.example", tt.header)); !reflect.DeepEqual(signals, tt.expected) {
+			t.Errorf("%q: got %v, expected %v", tt.header, signals, tt.expected)
+		}
+	}
+}
+
+func TestStreakTracker(t *testing.T) {
+	const (
+		preloaded = "max-age=31536000; includeSubDomains; preload"
+		noPreload = "max-age=31536000; includeSubDomains"
+		zero      = "max-age=0; includeSubDomains; preload"
+	)
+	scans := [][]Result{
+		{
+			headerResult("steady.example", noPreload),
+			headerResult("broken.example", noPreload),
+			headerResult("fixed.example", zero),
+			headerResult("late.example", preloaded),
+			headerResult("gone.example", noPreload),
+		},
+		{
+			headerResult("steady.example", noPreload),
+			// A domain that could not be checked ends its streaks.
+			{Domain: "broken.example"},
+			headerResult("fixed.example", zero),
+			headerResult("late.example", zero),
+			headerResult("gone.example", noPreload),
+		},
+		{
+			headerResult("steady.example", noPreload),
+			headerResult("broken.example", noPreload),
+			headerResult("fixed.example", preloaded),
+			headerResult("late.example", zero),
+			// A domain missing from a scan ends its streaks too.
+		},
+	}
+
+	tracker := NewStreakTracker()
+	for _, scan := range scans {
+		for _, r := range scan {
+			tracker.Add(r)
+		}
+		tracker.EndScan()
+	}
+
+	if tracker.Scans() != 3 {
+		t.Errorf("Scans() = %d, expected 3", tracker.Scans())
+	}
+	expected := []Streak{
+		{"steady.example", SignalNoPreload, 3},
+		{"steady.example", SignalRemovableHeader, 3},
+	}
+	if streaks := tracker.Streaks(3); !reflect.DeepEqual(streaks, expected) {
+		t.Errorf("Streaks(3) = %v, expected %v", streaks, expected)
+	}
+	expected = []Streak{
+		{"late.example", SignalMaxAgeZero, 2},
+		{"steady.example", SignalNoPreload, 3},
+		{"steady.example", SignalRemovableHeader, 3},
+	}
+	if streaks := tracker.Streaks(2); !reflect.DeepEqual(streaks, expected) {
+		t.Errorf("Streaks(2) = %v, expected %v", streaks, expected)
+	}
+	expected = []Streak{
+		{"broken.example", SignalNoPreload, 1},
+		{"broken.example", SignalRemovableHeader, 1},
+		{"late.example", SignalMaxAgeZero, 2},
+		{"steady.example", SignalNoPreload, 3},
+		{"steady.example", SignalRemovableHeader, 3},
+	}
+	if streaks := tracker.Streaks(1); !reflect.DeepEqual(streaks, expected) {
+		t.Errorf("Streaks(1) = %v, expected %v", streaks, expected)
+	}
+}
+
+func TestBaselineFromStore(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "store.db"))
+	defer s.Close()
+
+	day := func(n int) time.Time { return time.Date(2026, 1, n, 0, 0, 0, 0, time.UTC) }
+	puts := []struct {
+		t       time.Time
+		results []Result
+	}{
+		{day(1), []Result{
+			issuesResult("passing.example", nil, nil),
+			issuesResult("recovered.example", []hstspreload.IssueCode{"e.one"}, nil),
+		}},
+		{day(2), []Result{
+			issuesResult("recovered.example", nil, nil),
+			issuesResult("warning.example", nil, []hstspreload.IssueCode{"w.one"}),
+		}},
+		// Results from the scan being compared are not part of the
+		// baseline.
+		{day(3), []Result{
+			issuesResult("passing.example", []hstspreload.IssueCode{"e.one"}, nil),
+			issuesResult("new.example", nil, nil),
+		}},
+	}
+	for _, p := range puts {
+		if err := s.Put(p.t, p.results...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := NewBaselineFromStore(s, day(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 3 {
+		t.Errorf("Len() = %d, expected 3", b.Len())
+	}
+
+	tests := []struct {
+		result    Result
+		regressed bool
+		expected  Regression
+	}{
+		{
+			issuesResult("passing.example", []hstspreload.IssueCode{"e.one"}, nil),
+			true,
+			Regression{Domain: "passing.example", NewlyFailing: true, NewIssueCodes: []hstspreload.IssueCode{"e.one"}},
+		},
+		// Compared with the most recent result before day 3, which passed.
+		{
+			issuesResult("recovered.example", []hstspreload.IssueCode{"e.one"}, nil),
+			true,
+			Regression{Domain: "recovered.example", NewlyFailing: true, NewIssueCodes: []hstspreload.IssueCode{"e.one"}},
+		},
+		{issuesResult("warning.example", nil, []hstspreload.IssueCode{"w.one"}), false, Regression{}},
+		{issuesResult("new.example", []hstspreload.IssueCode{"e.one"}, nil), false, Regression{}},
+	}
+	for _, tt := range tests {
+		reg, regressed := b.Compare(tt.result)
+		if regressed != tt.regressed || (regressed && !reflect.DeepEqual(reg, tt.expected)) {
+			t.Errorf("Compare(%s) = %#v, %t, expected %#v, %t", tt.result.Domain, reg, regressed, tt.expected, tt.regressed)
+		}
+	}
+}
+
+func TestStoreStreaks(t *testing.T) {
+	const (
+		preloaded = "max-age=31536000; includeSubDomains; preload"
+		noPreload = "max-age=31536000; includeSubDomains"
+		zero      = "max-age=0; includeSubDomains; preload"
+	)
+	s := openTestStore(t, filepath.Join(t.TempDir(), "store.db"))
+	defer s.Close()
+
+	day := func(n int) time.Time { return time.Date(2026, 1, n, 0, 0, 0, 0, time.UTC) }
+	puts := []struct {
+		t       time.Time
+		results []Result
+	}{
+		{day(1), []Result{
+			headerResult("steady.example", noPreload),
+			headerResult("broken.example", noPreload),
+			headerResult("late.example", preloaded),
+		}},
+		{day(2), []Result{
+			headerResult("steady.example", noPreload),
+			// A domain that could not be checked ends its streaks.
+			{Domain: "broken.example"},
+			headerResult("late.example", zero),
+		}},
+		{day(3), []Result{
+			headerResult("steady.example", noPreload),
+			headerResult("broken.example", noPreload),
+			headerResult("late.example", zero),
+		}},
+		// Results are ordered by time, not by when they were added.
+		{day(0), []Result{headerResult("late.example", zero)}},
+	}
+	for _, p := range puts {
+		if err := s.Put(p.t, p.results...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	streaks, err := StoreStreaks(s, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Streak{
+		{"steady.example", SignalNoPreload, 3},
+		{"steady.example", SignalRemovableHeader, 3},
+	}
+	if !reflect.DeepEqual(streaks, expected) {
+		t.Errorf("StoreStreaks(3) = %v, expected %v", streaks, expected)
+	}
+
+	streaks, err = StoreStreaks(s, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []Streak{
+		{"late.example", SignalMaxAgeZero, 2},
+		{"steady.example", SignalNoPreload, 3},
+		{"steady.example", SignalRemovableHeader, 3},
+	}
+	if !reflect.DeepEqual(streaks, expected) {
+		t.Errorf("StoreStreaks(2) = %v, expected %v", streaks, expected)
+	}
+}
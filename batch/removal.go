@@ -0,0 +1,291 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+// A FailureRecord tracks a preloaded domain that has been failing the
+// requirements of its policy.
+type FailureRecord struct {
+	// FirstFailed is the time of the first scan of the current run of
+	// failing scans.
+	FirstFailed time.Time `json:"first_failed"`
+	// Scans is the number of consecutive failing scans, not counting the
+	// scans in which the domain could not be reached.
+	Scans int `json:"scans"`
+}
+
+// RemovalState is the state that a RemovalPipeline keeps between runs, so
+// that domains are only proposed for removal after failing for a while.
+type RemovalState struct {
+	Failures map[string]*FailureRecord `json:"failures"`
+}
+
+// LoadRemovalState reads the state saved at `path`. If the file does not
+// exist, it returns an empty state.
+func LoadRemovalState(path string) (*RemovalState, error) {
+	s := &RemovalState{Failures: make(map[string]*FailureRecord)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.Failures == nil {
+		s.Failures = make(map[string]*FailureRecord)
+	}
+	return s, nil
+}
+
+// Save writes the state to `path`, replacing it atomically.
+func (s *RemovalState) Save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// A RemovalCandidate is a preload list entry that is proposed for removal,
+// along with the evidence for it.
+type RemovalCandidate struct {
+	Entry preloadlist.Entry `json:"entry"`
+	// FirstFailed and FailedScans describe how long the domain has been
+	// failing.
+	FirstFailed time.Time `json:"first_failed"`
+	FailedScans int       `json:"failed_scans"`
+	// Result is the result of the latest scan, checked against the
+	// requirements of the entry's policy.
+	Result Result `json:"result"`
+}
+
+// A RemovalProposal lists the entries that a RemovalPipeline proposes to
+// remove from the preload list.
+type RemovalProposal struct {
+	Time     time.Time                `json:"time"`
+	Policies []preloadlist.PolicyType `json:"policies"`
+	// Scanned is the number of entries with the selected policies.
+	Scanned int `json:"scanned"`
+	// Failing is the number of those entries that failed the latest scan,
+	// including the ones that are still in their grace period.
+	Failing int `json:"failing"`
+	// Unreachable is the number of those entries that could not be
+	// reached in the latest scan, and were neither passing nor failing.
+	Unreachable int                `json:"unreachable"`
+	Candidates  []RemovalCandidate `json:"candidates"`
+	// Changes are the edits that remove the candidates from the list, as
+	// made by PreloadList.Remove.
+	Changes []preloadlist.Change `json:"changes"`
+	// Skipped are the entries that would be candidates, but cannot be
+	// removed with PreloadList.Remove (e.g. because their name is not
+	// normalized). They need to be removed by hand.
+	Skipped []SkippedRemoval `json:"skipped"`
+}
+
+// A SkippedRemoval is a RemovalCandidate that could not be removed from
+// the list.
+type SkippedRemoval struct {
+	RemovalCandidate
+	// Error is the reason that the entry could not be removed.
+	Error string `json:"error"`
+}
+
+// Apply removes the candidates of the proposal from `list`, by applying
+// its Changes.
+func (p RemovalProposal) Apply(list *preloadlist.PreloadList) error {
+	return list.Apply(p.Changes)
+}
+
+// Defaults of a RemovalPipeline.
+const (
+	// DefaultRemovalMinScans is the default number of consecutive failing
+	// scans before an entry is proposed for removal.
+	DefaultRemovalMinScans = 3
+	// DefaultRemovalGracePeriod is the default minimum time between the
+	// first failing scan and a proposal to remove the entry.
+	DefaultRemovalGracePeriod = 14 * 24 * time.Hour
+)
+
+// unreachableIssueCodes are the errors of a domain that could not be
+// reached, rather than one that was reached and failed the requirements.
+// They are often caused by network problems on either side, and are
+// already retried if they look transient (see hstspreload.RetryPolicy).
+var unreachableIssueCodes = map[hstspreload.IssueCode]bool{
+	"batch.timeout":             true,
+	"domain.tls.cannot_connect": true,
+	"redirects.follow_error":    true,
+}
+
+// inconclusive returns whether a failing result only has errors from
+// unreachableIssueCodes.
+func inconclusive(r Result) bool {
+	for _, issue := range r.Issues.Errors {
+		if !unreachableIssueCodes[issue.Code] {
+			return false
+		}
+	}
+	return true
+}
+
+// A RemovalPipeline finds preload list entries that no longer meet the
+// requirements of the policy they were preloaded under, and proposes to
+// remove the ones that have been failing for long enough.
+//
+// Each run scans the selected entries once. A domain that passes a scan
+// is forgiven, so a candidate must fail every scan of its grace period. A
+// domain that could not be reached (e.g. because the connection or the
+// scan timed out) neither fails nor passes the scan: its failure record is
+// kept as it is, so unreachable domains are never proposed for removal.
+type RemovalPipeline struct {
+	// Scanner is used to scan the entries. Its Policy is overridden by the
+	// policy of each entry. If nil, DefaultScanner's settings are used.
+	Scanner *Scanner
+	// Policies are the policies of the entries to scan, e.g.
+	// preloadlist.Bulk18Weeks and preloadlist.BulkLegacy.
+	Policies []preloadlist.PolicyType
+	// MinScans is the number of consecutive failing scans before an entry
+	// is proposed for removal. If less than 1, DefaultRemovalMinScans is
+	// used.
+	MinScans int
+	// GracePeriod is the minimum time between the first failing scan and a
+	// proposal to remove the entry. If 0, DefaultRemovalGracePeriod is
+	// used. A negative value disables the grace period.
+	GracePeriod time.Duration
+
+	// now returns the current time. If nil, time.Now is used.
+	now func() time.Time
+}
+
+func (p *RemovalPipeline) currentTime() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// Run scans the entries of `list` with the selected policies, updates
+// `state` with the results, and returns the resulting proposal. Entries
+// that are not in ForceHTTPS mode (e.g. pin-only entries) are skipped.
+//
+// Records in `state` for domains that are no longer selected are dropped.
+// Candidates that cannot be removed from the list are reported in
+// Skipped instead of Candidates.
+func (p *RemovalPipeline) Run(list preloadlist.PreloadList, state *RemovalState) (RemovalProposal, error) {
+	now := p.currentTime()
+	proposal := RemovalProposal{
+		Time:       now,
+		Policies:   p.Policies,
+		Candidates: []RemovalCandidate{},
+		Changes:    []preloadlist.Change{},
+		Skipped:    []SkippedRemoval{},
+	}
+
+	selected := make(map[preloadlist.PolicyType]bool)
+	for _, policy := range p.Policies {
+		selected[policy] = true
+	}
+	entries := make(map[string]preloadlist.Entry)
+	byPolicy := make(map[preloadlist.PolicyType][]string)
+	for _, e := range list.Entries {
+		if selected[e.Policy] && e.Mode == preloadlist.ForceHTTPS {
+			entries[e.Name] = e
+			byPolicy[e.Policy] = append(byPolicy[e.Policy], e.Name)
+		}
+	}
+	proposal.Scanned = len(entries)
+
+	for name := range state.Failures {
+		if _, ok := entries[name]; !ok {
+			delete(state.Failures, name)
+		}
+	}
+
+	scanner := *DefaultScanner
+	if p.Scanner != nil {
+		scanner = *p.Scanner
+	}
+	minScans := p.MinScans
+	if minScans < 1 {
+		minScans = DefaultRemovalMinScans
+	}
+	grace := p.GracePeriod
+	if grace == 0 {
+		grace = DefaultRemovalGracePeriod
+	}
+
+	for _, policy := range p.Policies {
+		scanner.Policy = policy
+		for r := range scanner.Scan(byPolicy[policy]) {
+			if len(r.Issues.Errors) == 0 {
+				delete(state.Failures, r.Domain)
+				continue
+			}
+			if inconclusive(r) {
+				proposal.Unreachable++
+				continue
+			}
+			proposal.Failing++
+
+			record := state.Failures[r.Domain]
+			if record == nil {
+				record = &FailureRecord{FirstFailed: now}
+				state.Failures[r.Domain] = record
+			}
+			record.Scans++
+
+			if record.Scans >= minScans && now.Sub(record.FirstFailed) >= grace {
+				proposal.Candidates = append(proposal.Candidates, RemovalCandidate{
+					Entry:       entries[r.Domain],
+					FirstFailed: record.FirstFailed,
+					FailedScans: record.Scans,
+					Result:      r,
+				})
+			}
+		}
+	}
+
+	sort.Slice(proposal.Candidates, func(i, j int) bool {
+		return proposal.Candidates[i].Entry.Name < proposal.Candidates[j].Entry.Name
+	})
+
+	edited := list
+	candidates := proposal.Candidates
+	proposal.Candidates = []RemovalCandidate{}
+	for _, c := range candidates {
+		change, err := edited.Remove(c.Entry.Name)
+		if err != nil {
+			proposal.Skipped = append(proposal.Skipped, SkippedRemoval{RemovalCandidate: c, Error: err.Error()})
+			continue
+		}
+		proposal.Candidates = append(proposal.Candidates, c)
+		proposal.Changes = append(proposal.Changes, change)
+	}
+
+	return proposal, nil
+}
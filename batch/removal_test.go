@@ -0,0 +1,251 @@
+package batch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+// outcomeCheck returns a check function that returns the outcome of
+// `outcomes[domain]` for the current run: "p" passes, "f" fails the
+// requirements, "u" could not be reached, and "U" could not be reached
+// and also failed the requirements, and "w" has a www subdomain without
+// HTTPS.
+func outcomeCheck(outcomes map[string]string, run *int) func(string, preloadlist.PolicyType, hstspreload.CheckOptions) Result {
+	return func(domain string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result {
+		r := Result{Domain: domain}
+		switch outcomes[domain][*run] {
+		case 'f':
+			r.Issues.Errors = []hstspreload.Issue{issue("header.preloadable.no_preload")}
+		case 'u':
+			r.Issues.Errors = []hstspreload.Issue{issue("domain.tls.cannot_connect")}
+		case 'U':
+			r.Issues.Errors = []hstspreload.Issue{issue("batch.timeout"), issue("header.preloadable.no_preload")}
+		case 'w':
+			r.Issues.Errors = []hstspreload.Issue{issue("domain.www.no_tls")}
+		}
+		return r
+	}
+}
+
+func candidateNames(p RemovalProposal) string {
+	var names []string
+	for _, c := range p.Candidates {
+		names = append(names, c.Entry.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestRemovalPipeline(t *testing.T) {
+	list := preloadlist.PreloadList{Entries: []preloadlist.Entry{
+		{Name: "always.example", Policy: preloadlist.Bulk18Weeks, Mode: preloadlist.ForceHTTPS},
+		{Name: "gap.example", Policy: preloadlist.Bulk18Weeks, Mode: preloadlist.ForceHTTPS},
+		{Name: "unreachable.example", Policy: preloadlist.BulkLegacy, Mode: preloadlist.ForceHTTPS},
+		{Name: "recovered.example", Policy: preloadlist.BulkLegacy, Mode: preloadlist.ForceHTTPS},
+		{Name: "mixed.example", Policy: preloadlist.BulkLegacy, Mode: preloadlist.ForceHTTPS},
+		{Name: "other-policy.example", Policy: preloadlist.Bulk1Year, Mode: preloadlist.ForceHTTPS},
+		{Name: "pinned.example", Policy: preloadlist.BulkLegacy},
+	}}
+	outcomes := map[string]string{
+		"always.example":       "ffff",
+		"gap.example":          "fuff",
+		"unreachable.example":  "uuuu",
+		"recovered.example":    "ffpf",
+		"mixed.example":        "UUUU",
+		"other-policy.example": "ffff",
+		"pinned.example":       "ffff",
+	}
+
+	run := 0
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := RemovalPipeline{
+		Scanner:  &Scanner{check: outcomeCheck(outcomes, &run)},
+		Policies: []preloadlist.PolicyType{preloadlist.Bulk18Weeks, preloadlist.BulkLegacy},
+		MinScans: 3,
+		now:      func() time.Time { return start.Add(time.Duration(run) * 7 * 24 * time.Hour) },
+	}
+	state := &RemovalState{Failures: make(map[string]*FailureRecord)}
+
+	tests := []struct {
+		failing     int
+		unreachable int
+		candidates  string
+	}{
+		{4, 1, ""},
+		{3, 2, ""},
+		// Three failing scans over the default grace period of 2 weeks.
+		{3, 1, "always.example mixed.example"},
+		// Unreachable scans do not break a streak.
+		{4, 1, "always.example gap.example mixed.example"},
+	}
+
+	for i, tt := range tests {
+		run = i
+		proposal, err := p.Run(list, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proposal.Scanned != 5 || proposal.Failing != tt.failing || proposal.Unreachable != tt.unreachable {
+			t.Errorf("Run %d: %d scanned, %d failing, %d unreachable, expected 5, %d, %d",
+				i, proposal.Scanned, proposal.Failing, proposal.Unreachable, tt.failing, tt.unreachable)
+		}
+		if names := candidateNames(proposal); names != tt.candidates {
+			t.Errorf("Run %d: candidates %q, expected %q", i, names, tt.candidates)
+		}
+		if len(proposal.Changes) != len(proposal.Candidates) {
+			t.Errorf("Run %d: %d changes for %d candidates", i, len(proposal.Changes), len(proposal.Candidates))
+		}
+	}
+
+	if _, ok := state.Failures["unreachable.example"]; ok {
+		t.Errorf("Unreachable domains must not have a failure record")
+	}
+	if r := state.Failures["gap.example"]; r == nil || r.Scans != 3 || !r.FirstFailed.Equal(start) {
+		t.Errorf("Unexpected record for gap.example: %#v", r)
+	}
+	if r := state.Failures["recovered.example"]; r == nil || r.Scans != 1 {
+		t.Errorf("Unexpected record for recovered.example: %#v", r)
+	}
+	if len(list.Entries) != 7 {
+		t.Errorf("Run must not modify the list, got %d entries", len(list.Entries))
+	}
+
+	proposal, _ := p.Run(list, state)
+	if err := proposal.Apply(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 4 {
+		t.Errorf("Expected 4 entries after applying the proposal, got %d", len(list.Entries))
+	}
+}
+
+func TestRemovalPipelineSkipped(t *testing.T) {
+	list := preloadlist.PreloadList{Entries: []preloadlist.Entry{
+		{Name: "Upper.example", Policy: preloadlist.Bulk18Weeks, Mode: preloadlist.ForceHTTPS},
+		{Name: "failing.example", Policy: preloadlist.Bulk18Weeks, Mode: preloadlist.ForceHTTPS},
+		{Name: "no-www.example", Policy: preloadlist.Bulk18Weeks, Mode: preloadlist.ForceHTTPS},
+	}}
+	run := 0
+	p := RemovalPipeline{
+		Scanner: &Scanner{check: outcomeCheck(map[string]string{
+			"Upper.example":   "f",
+			"failing.example": "f",
+			"no-www.example":  "w",
+		}, &run)},
+		Policies:    []preloadlist.PolicyType{preloadlist.Bulk18Weeks},
+		MinScans:    1,
+		GracePeriod: -1,
+	}
+	state := &RemovalState{Failures: make(map[string]*FailureRecord)}
+
+	proposal, err := p.Run(list, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A www subdomain without HTTPS is a failure, not an unreachable domain.
+	if proposal.Failing != 3 || proposal.Unreachable != 0 {
+		t.Errorf("%d failing, %d unreachable, expected 3, 0", proposal.Failing, proposal.Unreachable)
+	}
+	if names := candidateNames(proposal); names != "failing.example no-www.example" {
+		t.Errorf("Unexpected candidates %q", names)
+	}
+	if len(proposal.Changes) != 2 {
+		t.Errorf("%d changes, expected 2", len(proposal.Changes))
+	}
+	if len(proposal.Skipped) != 1 || proposal.Skipped[0].Entry.Name != "Upper.example" || proposal.Skipped[0].Error == "" {
+		t.Errorf("Unexpected skipped entries: %#v", proposal.Skipped)
+	}
+
+	if err := proposal.Apply(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 1 || list.Entries[0].Name != "Upper.example" {
+		t.Errorf("Unexpected entries after applying the proposal: %v", list.Entries)
+	}
+}
+
+func TestRemovalPipelineGracePeriod(t *testing.T) {
+	list := preloadlist.PreloadList{Entries: []preloadlist.Entry{
+		{Name: "a.example", Policy: preloadlist.Bulk18Weeks, Mode: preloadlist.ForceHTTPS},
+	}}
+	run := 0
+	check := outcomeCheck(map[string]string{"a.example": "fff"}, &run)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		grace    time.Duration
+		expected string
+	}{
+		{10 * 24 * time.Hour, "--x"},
+		{-1, "xxx"},
+		// The default grace period is 2 weeks.
+		{0, "---"},
+	}
+
+	for _, tt := range tests {
+		p := RemovalPipeline{
+			Scanner:     &Scanner{check: check},
+			Policies:    []preloadlist.PolicyType{preloadlist.Bulk18Weeks},
+			MinScans:    1,
+			GracePeriod: tt.grace,
+			now:         func() time.Time { return start.Add(time.Duration(run) * 5 * 24 * time.Hour) },
+		}
+		state := &RemovalState{Failures: make(map[string]*FailureRecord)}
+
+		got := ""
+		for run = 0; run < 3; run++ {
+			proposal, err := p.Run(list, state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(proposal.Candidates) > 0 {
+				got += "x"
+			} else {
+				got += "-"
+			}
+		}
+		if got != tt.expected {
+			t.Errorf("Grace period %s: got %s, expected %s", tt.grace, got, tt.expected)
+		}
+	}
+}
+
+func TestRemovalState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	state, err := LoadRemovalState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Failures) != 0 {
+		t.Errorf("Expected an empty state, got %v", state.Failures)
+	}
+
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	state.Failures["a.example"] = &FailureRecord{FirstFailed: first, Scans: 2}
+	if err := state.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRemovalState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("Loaded %#v, expected %#v", loaded, state)
+	}
+
+	// No temporary file is left behind.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the state file, got %v", entries)
+	}
+}
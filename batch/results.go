@@ -0,0 +1,67 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ReadResults reads the results of a scan from `r`, and calls `fn` for
+// each of them. Both the JSON array written by Fprint and the NDJSON
+// written by FprintNDJSON (or a checkpoint file) are accepted.
+//
+// The results are decoded one at a time, so that arbitrarily large scans
+// can be read. If `fn` returns an error, ReadResults stops and returns it.
+func ReadResults(r io.Reader, fn func(Result) error) error {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+
+	array := false
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			br.ReadByte()
+			continue
+		}
+		array = b[0] == '['
+		break
+	}
+
+	if !array {
+		for i := 1; ; i++ {
+			var res Result
+			err := dec.Decode(&res)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("result %d: %w", i, err)
+			}
+			if err := fn(res); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for i := 1; dec.More(); i++ {
+		var res Result
+		if err := dec.Decode(&res); err != nil {
+			return fmt.Errorf("result %d: %w", i, err)
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
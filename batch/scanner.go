@@ -0,0 +1,403 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+const (
+	// resolveTimeout is the maximum time spent resolving a domain to find
+	// its host for MaxPerHost.
+	resolveTimeout = 10 * time.Second
+)
+
+// A Scanner runs hstspreload.EligibleDomainResponse() over batches of
+// domains, with limits that keep it from overwhelming servers that host
+// many domains.
+//
+// The zero value uses a single worker and no other limits.
+type Scanner struct {
+	// Workers is the number of domains checked in parallel. If less than 1,
+	// a single worker is used.
+	Workers int
+	// RequestsPerSecond limits how many network requests (and
+	// connections) are made per second, across all workers. Each check
+	// makes several requests, including one for each redirect that is
+	// followed and each retry. If 0, there is no limit.
+	RequestsPerSecond float64
+	// MaxPerHost limits how many domains that resolve to the same host are
+	// checked at the same time. If 0, there is no limit. Domains that
+	// cannot be resolved are not limited. Domains whose host is busy wait
+	// in a queue, while workers move on to other domains.
+	MaxPerHost int
+	// GroupBySubnet makes MaxPerHost apply to each /24 (for IPv4) or /64
+	// (for IPv6) network rather than to each IP address.
+	GroupBySubnet bool
+	// Timeout is the maximum time to wait for the checks of a domain. If a
+	// domain takes longer, its result has a single batch.timeout error. The
+	// check makes no new requests after the timeout, and its worker waits
+	// for the requests in progress before moving on. If 0, there is no
+	// timeout beyond those of the individual connections.
+	Timeout time.Duration
+	// Retry controls how network operations that fail with a transient
+	// error are retried. If nil, hstspreload.DefaultRetryPolicy is used.
+	Retry *hstspreload.RetryPolicy
+	// Policy is the policy whose requirements domains are checked against.
+	// If empty, preloadlist.Bulk1Year is used.
+	Policy preloadlist.PolicyType
+	// Checkpoint, if set, records each result as it is produced. Domains
+	// that it already has results for are skipped, and produce no result.
+	Checkpoint *Checkpoint
+	// Store, if set, records each result as it is produced, with the time
+	// the check finished. Errors from Store.Put do not stop the scan; wrap
+	// the Store to handle them.
+	Store Store
+	// Ordered makes the results come out in the same order as the input.
+	// Domains are still checked in parallel, but at most four times
+	// Workers domains are in progress or waiting for an earlier result at
+	// any time, so a single slow domain can hold up the scan until it
+	// finishes (or times out).
+	Ordered bool
+
+	// check runs the checks for a domain. If nil, the package's check
+	// function is used.
+	check func(domain string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result
+	// hostKey returns the key of the host of a domain for MaxPerHost. If
+	// nil, the package's hostKey function is used.
+	hostKey func(domain string, subnet bool) string
+}
+
+// reorderWindowPerWorker is the number of results per worker that an
+// Ordered scan buffers while waiting for an earlier result.
+const reorderWindowPerWorker = 4
+
+// scanItem is a domain to check, with its position in the input.
+type scanItem struct {
+	seq    int
+	domain string
+	// host is the key of the domain's host for MaxPerHost, once it is
+	// known.
+	host string
+}
+
+// scanOutput is the outcome of a scanItem. Domains that were skipped
+// because of the Checkpoint have no result, but still take up their place
+// in the order.
+type scanOutput struct {
+	seq     int
+	result  Result
+	skipped bool
+}
+
+func (s *Scanner) checkDomain(domain string, opts hstspreload.CheckOptions) Result {
+	if s.check != nil {
+		return s.check(domain, s.policy(), opts)
+	}
+	return check(domain, s.policy(), opts)
+}
+
+func (s *Scanner) hostKeyOf(domain string) string {
+	if s.hostKey != nil {
+		return s.hostKey(domain, s.GroupBySubnet)
+	}
+	return hostKey(domain, s.GroupBySubnet)
+}
+
+func (s *Scanner) policy() preloadlist.PolicyType {
+	if s.Policy == "" {
+		return preloadlist.Bulk1Year
+	}
+	return s.Policy
+}
+
+// DefaultScanner is the Scanner used by Preloadable, Fprint and Print.
+var DefaultScanner = &Scanner{Workers: 100}
+
+// Scan checks the given domains in parallel, and returns the results in an
+// arbitrary order (unless Ordered is set). The channel is closed after the
+// last result.
+func (s *Scanner) Scan(domains []string) chan Result {
+	in := make(chan string)
+	go func() {
+		for _, d := range domains {
+			in <- d
+		}
+		close(in)
+	}()
+	return s.ScanStream(in)
+}
+
+// ScanStream checks domains as they are received from `domains`, and
+// returns the results in an arbitrary order (unless Ordered is set). The
+// returned channel is closed after `domains` is closed and the last result
+// has been sent.
+//
+// Only a bounded number of domains are held in memory at once, so
+// ScanStream can be used on arbitrarily long inputs.
+func (s *Scanner) ScanStream(domains <-chan string) chan Result {
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	limiter := hstspreload.NewRequestLimiter(s.RequestsPerSecond)
+	var hosts *hostLimiter
+	if s.MaxPerHost > 0 {
+		hosts = newHostLimiter(s.MaxPerHost, maxHostQueuePerWorker*workers)
+	}
+
+	// In an Ordered scan, a slot in the window is taken for each domain
+	// when it is sent to a worker, and freed when its result is emitted.
+	var window chan struct{}
+	if s.Ordered {
+		window = make(chan struct{}, reorderWindowPerWorker*workers)
+	}
+
+	items := make(chan scanItem)
+	go func() {
+		seq := 0
+		for d := range domains {
+			if window != nil {
+				window <- struct{}{}
+			}
+			items <- scanItem{seq: seq, domain: d}
+			seq++
+		}
+		close(items)
+	}()
+
+	outputs := make(chan scanOutput)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				s.run(item, limiter, hosts, outputs)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(outputs)
+	}()
+
+	results := make(chan Result)
+	go func() {
+		if s.Ordered {
+			reorder(outputs, results, window)
+		} else {
+			for o := range outputs {
+				if !o.skipped {
+					results <- o.result
+				}
+			}
+		}
+		close(results)
+	}()
+
+	return results
+}
+
+// reorder sends the results from `outputs` to `results` in input order,
+// freeing a slot in `window` for each output.
+func reorder(outputs <-chan scanOutput, results chan<- Result, window <-chan struct{}) {
+	pending := make(map[int]scanOutput)
+	next := 0
+	for o := range outputs {
+		pending[o.seq] = o
+		for {
+			o, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-window
+			if !o.skipped {
+				results <- o.result
+			}
+		}
+	}
+}
+
+// run processes an item, unless its host is busy, in which case it is
+// queued. When a check finishes, the items queued for its host are
+// processed in turn.
+func (s *Scanner) run(item scanItem, limiter *hstspreload.RequestLimiter, hosts *hostLimiter, outputs chan<- scanOutput) {
+	if s.Checkpoint != nil && s.Checkpoint.Done(item.domain) {
+		outputs <- scanOutput{seq: item.seq, skipped: true}
+		return
+	}
+	if hosts != nil {
+		item.host = s.hostKeyOf(item.domain)
+		if !hosts.acquire(item) {
+			return
+		}
+	}
+
+	for {
+		o, finished := s.process(item, limiter)
+		outputs <- o
+		// The worker (and the host) stays busy until the check actually
+		// finishes, even if it has timed out.
+		<-finished
+
+		next, ok := hosts.release(item.host)
+		if !ok {
+			return
+		}
+		item = next
+	}
+}
+
+// process checks the domain of an item, and records the result in the
+// Checkpoint and Store. The returned channel is closed once the check has
+// finished, which can be after the result if it timed out.
+func (s *Scanner) process(item scanItem, limiter *hstspreload.RequestLimiter) (scanOutput, <-chan struct{}) {
+	r, finished := s.scanDomain(item.domain, limiter)
+	if s.Checkpoint != nil {
+		// Errors are reported by Checkpoint.Close, and do not affect the
+		// scan.
+		_ = s.Checkpoint.Record(r)
+	}
+	if s.Store != nil {
+		_ = s.Store.Put(time.Now(), r)
+	}
+	return scanOutput{seq: item.seq, result: r}, finished
+}
+
+// scanDomain checks a single domain. The returned channel is closed once
+// the check has finished.
+func (s *Scanner) scanDomain(domain string, limiter *hstspreload.RequestLimiter) (Result, <-chan struct{}) {
+	opts := hstspreload.CheckOptions{Retry: s.Retry, RequestLimiter: limiter}
+	finished := make(chan struct{})
+	if s.Timeout <= 0 {
+		defer close(finished)
+		return s.checkDomain(domain, opts), finished
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	opts.Context = ctx
+	done := make(chan Result, 1)
+	go func() {
+		defer close(finished)
+		defer cancel()
+		done <- s.checkDomain(domain, opts)
+	}()
+
+	select {
+	case r := <-done:
+		return r, finished
+	case <-ctx.Done():
+		return Result{
+			Domain: domain,
+			Issues: hstspreload.Issues{Errors: []hstspreload.Issue{{
+				Code:    "batch.timeout",
+				Summary: "Timed out",
+				Message: fmt.Sprintf("The checks for %s did not finish within %s.", domain, s.Timeout),
+			}}},
+		}, finished
+	}
+}
+
+// hostKey returns the key of the host that `domain` resolves to, for
+// MaxPerHost. If the domain cannot be resolved, it returns "".
+func hostKey(domain string, subnet bool) string {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, domain)
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+
+	ip := addrs[0].IP
+	if !subnet {
+		return ip.String()
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// maxHostQueuePerWorker is the number of domains per worker that can wait
+// for their host (see MaxPerHost) before workers wait too.
+const maxHostQueuePerWorker = 4
+
+// A hostLimiter limits the number of concurrent checks for each host, and
+// queues the domains whose host is busy. A nil *hostLimiter does not limit
+// anything, and neither does the "" key.
+type hostLimiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	max     int
+	active  map[string]int
+	waiting map[string][]scanItem
+	// queued is the number of items in waiting, which is at most
+	// maxQueued.
+	queued    int
+	maxQueued int
+}
+
+func newHostLimiter(max int, maxQueued int) *hostLimiter {
+	l := &hostLimiter{
+		max:       max,
+		active:    make(map[string]int),
+		waiting:   make(map[string][]scanItem),
+		maxQueued: maxQueued,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire returns true if a check for the host of `item` is allowed. If
+// the host is busy, the item is queued to be handed out by release, and
+// acquire returns false. If the queue is full, acquire blocks until the
+// item can be queued or checked.
+func (l *hostLimiter) acquire(item scanItem) bool {
+	if l == nil || item.host == "" {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active[item.host] >= l.max {
+		if l.queued < l.maxQueued {
+			l.waiting[item.host] = append(l.waiting[item.host], item)
+			l.queued++
+			return false
+		}
+		l.cond.Wait()
+	}
+	l.active[item.host]++
+	return true
+}
+
+// release marks a check for `key` as finished. If an item is waiting for
+// the host, its check takes over, and it is returned.
+func (l *hostLimiter) release(key string) (scanItem, bool) {
+	if l == nil || key == "" {
+		return scanItem{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.cond.Broadcast()
+	if waiting := l.waiting[key]; len(waiting) > 0 {
+		if len(waiting) == 1 {
+			delete(l.waiting, key)
+		} else {
+			l.waiting[key] = waiting[1:]
+		}
+		l.queued--
+		return waiting[0], true
+	}
+	if l.active[key]--; l.active[key] == 0 {
+		delete(l.active, key)
+	}
+	return scanItem{}, false
+}
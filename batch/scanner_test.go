@@ -0,0 +1,287 @@
+package batch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+// stubCheck returns a check function that calls `fn` (if not nil) and
+// returns a result without issues.
+func stubCheck(fn func(domain string)) func(string, preloadlist.PolicyType, hstspreload.CheckOptions) Result {
+	return func(domain string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result {
+		if fn != nil {
+			fn(domain)
+		}
+		return Result{Domain: domain}
+	}
+}
+
+// concurrency tracks the maximum number of concurrent calls for each key.
+type concurrency struct {
+	mu     sync.Mutex
+	active map[string]int
+	max    map[string]int
+}
+
+func newConcurrency() *concurrency {
+	return &concurrency{active: make(map[string]int), max: make(map[string]int)}
+}
+
+func (c *concurrency) run(key string, d time.Duration) {
+	c.mu.Lock()
+	c.active[key]++
+	if c.active[key] > c.max[key] {
+		c.max[key] = c.active[key]
+	}
+	c.mu.Unlock()
+
+	time.Sleep(d)
+
+	c.mu.Lock()
+	c.active[key]--
+	c.mu.Unlock()
+}
+
+func testDomains(n int) []string {
+	var domains []string
+	for i := 0; i < n; i++ {
+		domains = append(domains, fmt.Sprintf("d%02d.example", i))
+	}
+	return domains
+}
+
+func collectDomains(results chan Result) []string {
+	var domains []string
+	for r := range results {
+		domains = append(domains, r.Domain)
+	}
+	return domains
+}
+
+func TestScannerWorkers(t *testing.T) {
+	tests := []struct {
+		workers  int
+		expected int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+	}
+
+	for _, tt := range tests {
+		c := newConcurrency()
+		s := &Scanner{
+			Workers: tt.workers,
+			check:   stubCheck(func(string) { c.run("", 20*time.Millisecond) }),
+		}
+		domains := collectDomains(s.Scan(testDomains(9)))
+		sort.Strings(domains)
+		if strings.Join(domains, " ") != strings.Join(testDomains(9), " ") {
+			t.Errorf("Workers: %d: unexpected results: %v", tt.workers, domains)
+		}
+		if c.max[""] != tt.expected {
+			t.Errorf("Workers: %d: %d checks ran at the same time, expected %d", tt.workers, c.max[""], tt.expected)
+		}
+	}
+}
+
+func TestScannerRequestsPerSecond(t *testing.T) {
+	var mu sync.Mutex
+	var starts []time.Time
+	s := &Scanner{
+		Workers:           5,
+		RequestsPerSecond: 50,
+		check: func(domain string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result {
+			// Each check makes three requests.
+			for i := 0; i < 3; i++ {
+				opts.RequestLimiter.Wait()
+				mu.Lock()
+				starts = append(starts, time.Now())
+				mu.Unlock()
+			}
+			return Result{Domain: domain}
+		},
+	}
+	collectDomains(s.Scan(testDomains(2)))
+
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	// 6 requests at 50 per second are spread over at least 100ms.
+	if elapsed := starts[len(starts)-1].Sub(starts[0]); elapsed < 90*time.Millisecond {
+		t.Errorf("Requests were made over %s, expected at least 100ms", elapsed)
+	}
+}
+
+func TestScannerMaxPerHost(t *testing.T) {
+	c := newConcurrency()
+	hostOf := func(domain string, subnet bool) string {
+		if strings.HasPrefix(domain, "unresolved") {
+			return ""
+		}
+		return domain[:1]
+	}
+	// Domains that cannot be resolved are not limited, so the checks for
+	// all of them can run at the same time.
+	var unresolved sync.WaitGroup
+	unresolved.Add(4)
+	allUnresolved := make(chan struct{})
+	go func() {
+		unresolved.Wait()
+		close(allUnresolved)
+	}()
+	s := &Scanner{
+		Workers:    8,
+		MaxPerHost: 2,
+		hostKey:    hostOf,
+		check: stubCheck(func(domain string) {
+			key := hostOf(domain, false)
+			if key != "" {
+				c.run(key, 20*time.Millisecond)
+				return
+			}
+			unresolved.Done()
+			select {
+			case <-allUnresolved:
+			case <-time.After(5 * time.Second):
+				t.Errorf("The check for %s was not run at the same time as the other unresolved domains", domain)
+			}
+		}),
+	}
+
+	var domains []string
+	for i := 0; i < 4; i++ {
+		domains = append(domains,
+			fmt.Sprintf("a%d.example", i),
+			fmt.Sprintf("b%d.example", i),
+			fmt.Sprintf("unresolved%d.example", i))
+	}
+	if n := len(collectDomains(s.Scan(domains))); n != len(domains) {
+		t.Errorf("Got %d results, expected %d", n, len(domains))
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if c.max[key] != 2 {
+			t.Errorf("%d checks for host %s ran at the same time, expected 2", c.max[key], key)
+		}
+	}
+}
+
+func TestScannerTimeout(t *testing.T) {
+	s := &Scanner{
+		Workers: 2,
+		Timeout: 20 * time.Millisecond,
+		check: func(domain string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result {
+			if domain == "slow.example" {
+				<-opts.Context.Done()
+			}
+			return Result{Domain: domain}
+		},
+	}
+
+	results := make(map[string]Result)
+	for r := range s.Scan([]string{"slow.example", "fast.example"}) {
+		results[r.Domain] = r
+	}
+
+	slow := results["slow.example"].Issues
+	if len(slow.Errors) != 1 || slow.Errors[0].Code != "batch.timeout" {
+		t.Errorf("Expected a single batch.timeout error for slow.example, got %#v", slow)
+	}
+	if fast := results["fast.example"].Issues; len(fast.Errors) != 0 {
+		t.Errorf("Unexpected errors for fast.example: %#v", fast)
+	}
+}
+
+func TestScannerTimeoutKeepsWorker(t *testing.T) {
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	release := make(chan struct{})
+	s := &Scanner{
+		Workers: 1,
+		Timeout: 10 * time.Millisecond,
+		check: stubCheck(func(domain string) {
+			mu.Lock()
+			if active++; active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			if domain == "d00.example" {
+				<-release
+			}
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}),
+	}
+
+	results := s.Scan(testDomains(3))
+	if r := <-results; len(r.Issues.Errors) != 1 || r.Issues.Errors[0].Code != "batch.timeout" {
+		t.Errorf("Expected a timeout for %s, got %#v", r.Domain, r.Issues)
+	}
+	// The next domain is only checked once the timed out check returns.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if n := len(collectDomains(results)); n != 2 {
+		t.Errorf("Got %d more results, expected 2", n)
+	}
+	if maxActive != 1 {
+		t.Errorf("%d checks ran at the same time, expected 1", maxActive)
+	}
+}
+
+func TestScannerMaxPerHostQueue(t *testing.T) {
+	// A run of domains on one host does not hold up the other domains.
+	otherChecked := make(chan struct{})
+	s := &Scanner{
+		Workers:    2,
+		MaxPerHost: 1,
+		hostKey:    func(domain string, subnet bool) string { return domain[:1] },
+		check: stubCheck(func(domain string) {
+			if domain == "b.example" {
+				close(otherChecked)
+				return
+			}
+			select {
+			case <-otherChecked:
+			case <-time.After(5 * time.Second):
+				t.Errorf("b.example was not checked while %s was", domain)
+			}
+		}),
+	}
+
+	domains := []string{"a0.example", "a1.example", "a2.example", "a3.example", "b.example"}
+	if n := len(collectDomains(s.Scan(domains))); n != len(domains) {
+		t.Errorf("Got %d results, expected %d", n, len(domains))
+	}
+}
+
+func TestScannerPolicy(t *testing.T) {
+	tests := []struct {
+		policy   preloadlist.PolicyType
+		expected preloadlist.PolicyType
+	}{
+		{"", preloadlist.Bulk1Year},
+		{preloadlist.Bulk18Weeks, preloadlist.Bulk18Weeks},
+	}
+
+	for _, tt := range tests {
+		var got preloadlist.PolicyType
+		s := &Scanner{
+			Policy: tt.policy,
+			check: func(domain string, policy preloadlist.PolicyType, opts hstspreload.CheckOptions) Result {
+				got = policy
+				return Result{Domain: domain}
+			},
+		}
+		collectDomains(s.Scan([]string{"a.example"}))
+		if got != tt.expected {
+			t.Errorf("Policy %q: checked against %q, expected %q", tt.policy, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package batch
+
+import (
+	"time"
+
+	"github.com/chromium/hstspreload"
+)
+
+// A StoredResult is a result recorded in a Store, with the time of the
+// scan.
+type StoredResult struct {
+	Time   time.Time `json:"time"`
+	Result Result    `json:"result"`
+}
+
+// A Store records the results of scans over time. Set Scanner.Store to
+// record results as they are produced.
+//
+// Implementations must be safe for concurrent use. OpenBoltStore returns
+// the default implementation.
+type Store interface {
+	// Put records results of a scan at time `t`.
+	Put(t time.Time, results ...Result) error
+	// Latest returns the most recent result for a domain. It returns false
+	// if the store has no results for the domain.
+	Latest(domain string) (StoredResult, bool, error)
+	// History returns the results for a domain, oldest first.
+	History(domain string) ([]StoredResult, error)
+	// WithIssue returns the domains whose most recent result has an error
+	// or warning with the given code, in alphabetical order.
+	WithIssue(code hstspreload.IssueCode) ([]string, error)
+	// FirstFailing returns the time of the first result in the current run
+	// of failing results for a domain, i.e. since when it has been failing.
+	// It returns false if the most recent result for the domain has no
+	// errors, or if there is none.
+	FirstFailing(domain string) (time.Time, bool, error)
+	// Domains returns the domains that have results, in alphabetical
+	// order.
+	Domains() ([]string, error)
+	// Close releases the resources of the store.
+	Close() error
+}
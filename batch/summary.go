@@ -0,0 +1,198 @@
+package batch
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+// Labels used in a Summary for domains without a policy.
+const (
+	// NoPolicy is the Policies key for preloaded domains whose entry has no
+	// policy.
+	NoPolicy = "(none)"
+	// NotPreloaded is the Policies key for domains that are not on the
+	// preload list.
+	NotPreloaded = "(not preloaded)"
+)
+
+// Totals counts the domains of a scan by outcome.
+type Totals struct {
+	Domains int `json:"domains"`
+	// Passed counts the domains without errors or warnings.
+	Passed int `json:"passed"`
+	// Warnings counts the domains with warnings but no errors.
+	Warnings int `json:"warnings"`
+	// Errors counts the domains with errors.
+	Errors int `json:"errors"`
+}
+
+func (t *Totals) add(issues hstspreload.Issues) {
+	t.Domains++
+	switch {
+	case len(issues.Errors) > 0:
+		t.Errors++
+	case len(issues.Warnings) > 0:
+		t.Warnings++
+	default:
+		t.Passed++
+	}
+}
+
+// A MaxAgeCount counts the domains whose header has a max-age in a range.
+type MaxAgeCount struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+// maxAgeRanges are the ranges of a max-age distribution, from the lowest.
+// A max-age is in the last range whose minimum it reaches.
+var maxAgeRanges = []struct {
+	label string
+	min   uint64
+}{
+	{"0", 0},
+	{"under 1 day", 1},
+	{"1 day to 18 weeks", 86400},
+	{"18 weeks to 1 year", 18 * 7 * 86400},
+	{"1 year or more", 365 * 86400},
+}
+
+// noMaxAge is the range for headers that are missing or have no max-age.
+const noMaxAge = "missing"
+
+// A Summary aggregates the results of a scan.
+type Summary struct {
+	Totals Totals `json:"totals"`
+	// IssueCodes counts the domains with each issue code.
+	IssueCodes map[hstspreload.IssueCode]int `json:"issue_codes"`
+	// Policies breaks the totals down by the policy of the preload list
+	// entry for each domain. It is only set if the list is known.
+	Policies map[string]*Totals `json:"policies,omitempty"`
+	// Issuers counts the domains by the common name of the issuer of their
+	// leaf certificate.
+	Issuers map[string]int `json:"issuers"`
+	// MaxAges is the distribution of the max-age of the headers, from the
+	// lowest range. The last element counts domains without a max-age.
+	MaxAges []MaxAgeCount `json:"max_ages"`
+
+	list *preloadlist.IndexedEntries
+}
+
+// NewSummary returns an empty Summary. If `list` is not nil, it is used to
+// break down the results by policy.
+func NewSummary(list *preloadlist.IndexedEntries) *Summary {
+	s := &Summary{
+		IssueCodes: make(map[hstspreload.IssueCode]int),
+		Issuers:    make(map[string]int),
+		list:       list,
+	}
+	if list != nil {
+		s.Policies = make(map[string]*Totals)
+	}
+	for _, r := range maxAgeRanges {
+		s.MaxAges = append(s.MaxAges, MaxAgeCount{Range: r.label})
+	}
+	s.MaxAges = append(s.MaxAges, MaxAgeCount{Range: noMaxAge})
+	return s
+}
+
+// Add adds a result to the summary.
+func (s *Summary) Add(r Result) {
+	s.Totals.add(r.Issues)
+
+	codes := make(map[hstspreload.IssueCode]bool)
+	for _, list := range [][]hstspreload.Issue{r.Issues.Errors, r.Issues.Warnings} {
+		for _, issue := range list {
+			codes[issue.Code] = true
+		}
+	}
+	for code := range codes {
+		s.IssueCodes[code]++
+	}
+
+	if s.list != nil {
+		policy := NotPreloaded
+		if entry, found := s.list.Get(r.Domain); found != preloadlist.EntryNotFound {
+			policy = string(entry.Policy)
+			if policy == "" {
+				policy = NoPolicy
+			}
+		}
+		if s.Policies[policy] == nil {
+			s.Policies[policy] = &Totals{}
+		}
+		s.Policies[policy].add(r.Issues)
+	}
+
+	if issuer := r.LeafCertSummary.IssuerCommonName; issuer != "" {
+		s.Issuers[issuer]++
+	}
+
+	i := len(s.MaxAges) - 1
+	if r.ParsedHeader.MaxAge != nil {
+		for j, rng := range maxAgeRanges {
+			if r.ParsedHeader.MaxAge.Seconds >= rng.min {
+				i = j
+			}
+		}
+	}
+	s.MaxAges[i].Count++
+}
+
+// byCount returns the keys of `m`, with the highest count first and ties
+// broken alphabetically.
+func byCount[K ~string](m map[K]int) []K {
+	var keys []K
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// String describes the totals in a sentence.
+func (t Totals) String() string {
+	return fmt.Sprintf("%d domains: %d passed, %d with warnings, %d with errors",
+		t.Domains, t.Passed, t.Warnings, t.Errors)
+}
+
+// Fprint prints the summary as a text report.
+func (s *Summary) Fprint(w io.Writer) {
+	fmt.Fprintf(w, "%s\n", s.Totals)
+
+	fmt.Fprintf(w, "\nIssue codes:\n")
+	for _, code := range byCount(s.IssueCodes) {
+		fmt.Fprintf(w, "  %8d  %s\n", s.IssueCodes[code], code)
+	}
+
+	if s.Policies != nil {
+		fmt.Fprintf(w, "\nBy policy:\n")
+		var policies []string
+		for p := range s.Policies {
+			policies = append(policies, p)
+		}
+		sort.Strings(policies)
+		for _, p := range policies {
+			fmt.Fprintf(w, "  %-20s %s\n", p, s.Policies[p])
+		}
+	}
+
+	fmt.Fprintf(w, "\nCertificate issuers:\n")
+	for _, issuer := range byCount(s.Issuers) {
+		fmt.Fprintf(w, "  %8d  %s\n", s.Issuers[issuer], issuer)
+	}
+
+	fmt.Fprintf(w, "\nmax-age:\n")
+	for _, c := range s.MaxAges {
+		fmt.Fprintf(w, "  %8d  %s\n", c.Count, c.Range)
+	}
+}
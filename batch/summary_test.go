@@ -0,0 +1,154 @@
+package batch
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+func issue(code hstspreload.IssueCode) hstspreload.Issue {
+	return hstspreload.Issue{Code: code}
+}
+
+func withMaxAge(seconds uint64) hstspreload.HSTSHeader {
+	return hstspreload.HSTSHeader{MaxAge: &hstspreload.MaxAge{Seconds: seconds}}
+}
+
+func TestSummary(t *testing.T) {
+	list := preloadlist.PreloadList{Entries: []preloadlist.Entry{
+		{Name: "a.example", Policy: preloadlist.Bulk1Year, Mode: preloadlist.ForceHTTPS},
+		{Name: "b.example", Policy: preloadlist.Bulk1Year, Mode: preloadlist.ForceHTTPS},
+		{Name: "c.example", Mode: preloadlist.ForceHTTPS},
+	}}.Index()
+
+	s := NewSummary(&list)
+	s.Add(Result{
+		Domain:          "a.example",
+		ParsedHeader:    withMaxAge(31536000),
+		LeafCertSummary: CertSummary{IssuerCommonName: "Issuer A"},
+	})
+	s.Add(Result{
+		Domain:          "b.example",
+		ParsedHeader:    withMaxAge(0),
+		LeafCertSummary: CertSummary{IssuerCommonName: "Issuer B"},
+		// An issue code counts once per domain.
+		Issues: hstspreload.Issues{
+			Errors:   []hstspreload.Issue{issue("header.preloadable.max_age.zero"), issue("header.preloadable.max_age.zero")},
+			Warnings: []hstspreload.Issue{issue("redirects.http.www_first")},
+		},
+	})
+	s.Add(Result{
+		Domain:          "c.example",
+		ParsedHeader:    withMaxAge(86400),
+		LeafCertSummary: CertSummary{IssuerCommonName: "Issuer A"},
+		Issues:          hstspreload.Issues{Warnings: []hstspreload.Issue{issue("redirects.http.www_first")}},
+	})
+	s.Add(Result{
+		Domain: "unlisted.example",
+		Issues: hstspreload.Issues{Errors: []hstspreload.Issue{issue("domain.tls.cannot_connect")}},
+	})
+
+	if expected := (Totals{Domains: 4, Passed: 1, Warnings: 1, Errors: 2}); s.Totals != expected {
+		t.Errorf("Totals = %#v, expected %#v", s.Totals, expected)
+	}
+	expectedCodes := map[hstspreload.IssueCode]int{
+		"header.preloadable.max_age.zero": 1,
+		"redirects.http.www_first":        2,
+		"domain.tls.cannot_connect":       1,
+	}
+	if !reflect.DeepEqual(s.IssueCodes, expectedCodes) {
+		t.Errorf("IssueCodes = %v, expected %v", s.IssueCodes, expectedCodes)
+	}
+	expectedPolicies := map[string]*Totals{
+		string(preloadlist.Bulk1Year): {Domains: 2, Passed: 1, Errors: 1},
+		NoPolicy:                      {Domains: 1, Warnings: 1},
+		NotPreloaded:                  {Domains: 1, Errors: 1},
+	}
+	if !reflect.DeepEqual(s.Policies, expectedPolicies) {
+		t.Errorf("Unexpected Policies: %v", s.Policies)
+	}
+	if expected := map[string]int{"Issuer A": 2, "Issuer B": 1}; !reflect.DeepEqual(s.Issuers, expected) {
+		t.Errorf("Issuers = %v, expected %v", s.Issuers, expected)
+	}
+	expectedMaxAges := []MaxAgeCount{
+		{"0", 1},
+		{"under 1 day", 0},
+		{"1 day to 18 weeks", 1},
+		{"18 weeks to 1 year", 0},
+		{"1 year or more", 1},
+		{"missing", 1},
+	}
+	if !reflect.DeepEqual(s.MaxAges, expectedMaxAges) {
+		t.Errorf("MaxAges = %v, expected %v", s.MaxAges, expectedMaxAges)
+	}
+
+	var buf bytes.Buffer
+	s.Fprint(&buf)
+	for _, expected := range []string{
+		"4 domains: 1 passed, 1 with warnings, 2 with errors",
+		"         2  redirects.http.www_first\n         1  domain.tls.cannot_connect\n",
+		"  bulk-1-year          2 domains: 1 passed, 0 with warnings, 1 with errors",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Report does not contain %q:\n%s", expected, buf.String())
+		}
+	}
+
+	// Without a list, there is no breakdown by policy.
+	s = NewSummary(nil)
+	s.Add(Result{Domain: "a.example"})
+	if s.Policies != nil {
+		t.Errorf("Expected no Policies without a list, got %v", s.Policies)
+	}
+}
+
+func TestReadResults(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		expected    []string
+		err         bool
+	}{
+		{"empty", "", nil, false},
+		{"whitespace", " \n\t", nil, false},
+		{"empty array", "[]", nil, false},
+		{"array", `[
+  {"domain": "a.example"},
+  {"domain": "b.example"}
+]`, []string{"a.example", "b.example"}, false},
+		{"NDJSON", "{\"domain\": \"a.example\"}\n{\"domain\": \"b.example\"}\n", []string{"a.example", "b.example"}, false},
+		{"leading whitespace", "\n  [{\"domain\": \"a.example\"}]", []string{"a.example"}, false},
+		{"invalid array element", `[{"domain": "a.example"}, 3]`, []string{"a.example"}, true},
+		{"invalid line", "{\"domain\": \"a.example\"}\n{\"dom", []string{"a.example"}, true},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		err := ReadResults(strings.NewReader(tt.input), func(r Result) error {
+			got = append(got, r.Domain)
+			return nil
+		})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: unexpected error: %v", tt.description, err)
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: got %v, expected %v", tt.description, got, tt.expected)
+		}
+	}
+
+	// An error from the callback stops reading.
+	calls := 0
+	errStop := errors.New("stop")
+	err := ReadResults(strings.NewReader("[{}, {}, {}]"), func(Result) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("Expected a single call and the callback's error, got %d calls and %v", calls, err)
+	}
+}
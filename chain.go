@@ -0,0 +1,341 @@
+package hstspreload
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxChainLength limits how many issuers we will look for (including
+	// over AIA) when diagnosing a certificate chain.
+	maxChainLength = 8
+	// maxAIAResponseSize limits the size of an AIA response body.
+	maxAIAResponseSize = 1 << 20
+)
+
+// A chainProblem identifies why a certificate chain could not be verified.
+// The empty chainProblem means that no specific cause was found.
+type chainProblem string
+
+const (
+	chainMissingIntermediate chainProblem = "missing_intermediate"
+	chainWrongOrder          chainProblem = "wrong_order"
+	chainExpiredIntermediate chainProblem = "expired_intermediate"
+	chainSelfSignedLeaf      chainProblem = "self_signed_leaf"
+	chainNameMismatch        chainProblem = "name_mismatch"
+	chainUntrustedRoot       chainProblem = "untrusted_root"
+)
+
+// A chainDiagnosis is the result of diagnoseChain().
+type chainDiagnosis struct {
+	problem chainProblem
+	// The certificate most relevant to the problem, if any.
+	cert *x509.Certificate
+	// Whether fetching issuers from the Authority Information Access
+	// extension produces a chain that verifies.
+	aiaCompletes bool
+	// The error from verifying the chain as presented.
+	err error
+}
+
+// A chainVerifier holds the settings used to diagnose certificate chains.
+type chainVerifier struct {
+	// `roots` can be `nil` to use the system roots.
+	roots *x509.CertPool
+	// fetchAIA returns the certificates served at an AIA "CA Issuers" URL.
+	fetchAIA func(url string) ([]*x509.Certificate, error)
+	// `now` can be `nil` to use time.Now.
+	now func() time.Time
+}
+
+// defaultChainVerifier does not fetch issuers over AIA. Checks only do so
+// if CheckOptions.FetchAIA is set.
+var defaultChainVerifier = chainVerifier{}
+
+func (v chainVerifier) currentTime() time.Time {
+	if v.now == nil {
+		return time.Now()
+	}
+	return v.now()
+}
+
+func (v chainVerifier) verify(domain string, leaf *x509.Certificate, intermediates []*x509.Certificate) error {
+	pool := x509.NewCertPool()
+	for _, cert := range intermediates {
+		pool.AddCert(cert)
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       domain,
+		Roots:         v.roots,
+		Intermediates: pool,
+		CurrentTime:   v.currentTime(),
+	})
+	return err
+}
+
+// diagnoseChain determines why the chain of `certs` (as presented by the
+// server, starting with the leaf) does not verify for `domain`.
+func (v chainVerifier) diagnoseChain(domain string, certs []*x509.Certificate) chainDiagnosis {
+	if len(certs) == 0 {
+		return chainDiagnosis{err: errors.New("no certificates")}
+	}
+	leaf := certs[0]
+
+	if err := leaf.VerifyHostname(domain); err != nil {
+		return chainDiagnosis{problem: chainNameMismatch, cert: leaf, err: err}
+	}
+
+	if isSelfSigned(leaf) {
+		return chainDiagnosis{problem: chainSelfSignedLeaf, cert: leaf}
+	}
+
+	err := v.verify(domain, leaf, certs[1:])
+	if err == nil {
+		if !inOrder(certs) {
+			return chainDiagnosis{problem: chainWrongOrder}
+		}
+		return chainDiagnosis{}
+	}
+
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired && invalidErr.Cert != leaf {
+		return chainDiagnosis{problem: chainExpiredIntermediate, cert: invalidErr.Cert, err: err}
+	}
+
+	var authorityErr x509.UnknownAuthorityError
+	if !errors.As(err, &authorityErr) {
+		return chainDiagnosis{err: err}
+	}
+
+	chain, fetched := v.buildChain(certs)
+	top := chain[len(chain)-1]
+
+	// If there is more than one candidate issuer, the verifier can report
+	// an expired intermediate as an unknown authority, so we also check the
+	// validity period ourselves.
+	now := v.currentTime()
+	for _, cert := range chain[1:] {
+		if !isSelfSigned(cert) && now.After(cert.NotAfter) {
+			return chainDiagnosis{problem: chainExpiredIntermediate, cert: cert, err: err}
+		}
+	}
+
+	intermediates := append(append([]*x509.Certificate{}, certs[1:]...), fetched...)
+	if len(fetched) > 0 && v.verify(domain, leaf, intermediates) == nil {
+		return chainDiagnosis{problem: chainMissingIntermediate, cert: chain[len(chain)-len(fetched)-1], aiaCompletes: true, err: err}
+	}
+	if isSelfSigned(top) {
+		return chainDiagnosis{problem: chainUntrustedRoot, cert: top, err: err}
+	}
+	return chainDiagnosis{problem: chainMissingIntermediate, cert: top, err: err}
+}
+
+// buildChain orders `certs` into a chain starting from the leaf, using AIA to
+// fetch issuers that were not presented. `fetched` contains the issuers that
+// were retrieved over AIA, in chain order.
+func (v chainVerifier) buildChain(certs []*x509.Certificate) (chain []*x509.Certificate, fetched []*x509.Certificate) {
+	chain = []*x509.Certificate{certs[0]}
+
+	for len(chain) < maxChainLength {
+		current := chain[len(chain)-1]
+		if isSelfSigned(current) {
+			break
+		}
+
+		issuer := findIssuer(current, certs[1:])
+		if issuer == nil && v.fetchAIA != nil {
+			for _, u := range current.IssuingCertificateURL {
+				candidates, err := v.fetchAIA(u)
+				if err != nil {
+					continue
+				}
+				if issuer = findIssuer(current, candidates); issuer != nil {
+					fetched = append(fetched, issuer)
+					break
+				}
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+	}
+
+	return chain, fetched
+}
+
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if !candidate.Equal(cert) && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// inOrder reports whether each certificate in `certs` is issued by the one
+// that follows it.
+func inOrder(certs []*x509.Certificate) bool {
+	for i := 0; i+1 < len(certs); i++ {
+		if certs[i].CheckSignatureFrom(certs[i+1]) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// aiaAddressAllowed reports whether AIA fetches may connect to `ip`. The
+// URLs come from certificates served by the checked site, so we only
+// connect to public addresses.
+var aiaAddressAllowed = isPublicAddress
+
+func isPublicAddress(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// aiaDialControl rejects connections to addresses that are not allowed by
+// aiaAddressAllowed. It runs after name resolution, so it also covers names
+// that resolve to a local address.
+func aiaDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !aiaAddressAllowed(ip) {
+		return fmt.Errorf("AIA fetch from non-public address %s", host)
+	}
+	return nil
+}
+
+func checkAIAURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("AIA URL has unsupported scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// fetchAIAIssuers downloads the certificates served at `u`, which is taken
+// from the Authority Information Access extension of a certificate.
+// Both DER and PEM responses are accepted. Only http and https URLs on
+// public addresses are fetched, and the response is limited in time and
+// size.
+func fetchAIAIssuers(u string) ([]*x509.Certificate, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAIAURL(parsed); err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: aiaDialControl,
+	}
+	client := http.Client{
+		Timeout: dialTimeout,
+		Transport: &http.Transport{
+			DialContext: dialer.DialContext,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkAIAURL(req.URL)
+		},
+	}
+
+	resp, err := client.Get(parsed.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAIAResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if certs, err := x509.ParseCertificates(body); err == nil {
+		return certs, nil
+	}
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(body); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+// summary describes the problem of the chain, for use in a sentence. It
+// returns "" if the problem is not known.
+func (d chainDiagnosis) summary() string {
+	switch d.problem {
+	case chainMissingIntermediate:
+		aia := "fetching the missing certificate from the Authority Information Access URL would not complete the chain either"
+		if d.aiaCompletes {
+			aia = "some browsers fetch the missing certificate from the Authority Information Access URL, but many clients do not"
+		}
+		return fmt.Sprintf("missing intermediate certificate (the chain stops at %q; %s)", d.cert.Subject.CommonName, aia)
+	case chainWrongOrder:
+		return "certificates in the wrong order (each certificate should be followed by the certificate that issued it)"
+	case chainExpiredIntermediate:
+		return fmt.Sprintf("intermediate certificate %q expired on %s", d.cert.Subject.CommonName, d.cert.NotAfter.UTC().Format(time.RFC3339))
+	case chainSelfSignedLeaf:
+		return "self-signed certificate"
+	case chainNameMismatch:
+		return fmt.Sprintf("certificate not valid for this name (%s)", d.err)
+	case chainUntrustedRoot:
+		return fmt.Sprintf("untrusted root certificate %q", d.cert.Subject.CommonName)
+	}
+	return ""
+}
+
+// checkInvalidChain reports an invalid certificate chain. The diagnosis of
+// the chain, if any, is added to the message.
+func (v chainVerifier) checkInvalidChain(domain string, certs []*x509.Certificate) Issues {
+	issues := Issues{}
+
+	problem := ""
+	if summary := v.diagnoseChain(domain, certs).summary(); summary != "" {
+		problem = ": " + summary
+	}
+
+	return issues.addErrorf(
+		IssueCode("domain.tls.invalid_cert_chain"),
+		"Invalid Certificate Chain",
+		"https://%s uses an incomplete or "+
+			"invalid certificate chain%s. Check out your site at "+
+			"https://www.ssllabs.com/ssltest/",
+		domain,
+		problem,
+	)
+}
@@ -0,0 +1,272 @@
+package hstspreload
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestLeafWithAIA(t *testing.T, domain string, parent *testCert, aiaURL string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: domain},
+		DNSNames:              []string{domain},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IssuingCertificateURL: []string{aiaURL},
+	}, parent)
+}
+
+func TestDiagnoseChain(t *testing.T) {
+	root := newTestCA(t, "Test Root", nil)
+	intermediate := newTestCA(t, "Test Intermediate", root)
+	untrustedRoot := newTestCA(t, "Untrusted Root", nil)
+	untrustedIntermediate := newTestCA(t, "Untrusted Intermediate", untrustedRoot)
+	subIntermediate := newTestCA(t, "Test Sub-Intermediate", intermediate)
+	expiredIntermediate := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Expired Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotBefore:             testNow.Add(-48 * time.Hour),
+		NotAfter:              testNow.Add(-24 * time.Hour),
+	}, root)
+
+	// Serve the intermediate over AIA from a local server.
+	oldAllowed := aiaAddressAllowed
+	aiaAddressAllowed = func(ip net.IP) bool { return ip.IsLoopback() }
+	defer func() { aiaAddressAllowed = oldAllowed }()
+	aiaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/intermediate.cer" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(intermediate.cert.Raw)
+	}))
+	defer aiaServer.Close()
+
+	aiaLeaf := newTestLeafWithAIA(t, "example.com", intermediate, aiaServer.URL+"/intermediate.cer")
+	brokenAIALeaf := newTestLeafWithAIA(t, "example.com", intermediate, aiaServer.URL+"/missing.cer")
+	leaf := newTestLeaf(t, "example.com", intermediate)
+	subLeaf := newTestLeaf(t, "example.com", subIntermediate)
+	expiredIntermediateLeaf := newTestLeaf(t, "example.com", expiredIntermediate)
+	untrustedLeaf := newTestLeaf(t, "example.com", untrustedIntermediate)
+	selfSigned := newTestCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com"},
+	}, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	v := chainVerifier{
+		roots:    roots,
+		fetchAIA: fetchAIAIssuers,
+		now:      func() time.Time { return testNow },
+	}
+
+	tests := []struct {
+		description          string
+		domain               string
+		certs                []*testCert
+		expectedProblem      chainProblem
+		expectedAIACompletes bool
+		expectedIssues       Issues
+	}{
+		{
+			"valid chain",
+			"example.com",
+			[]*testCert{leaf, intermediate},
+			"",
+			false,
+			Issues{Errors: []Issue{{Code: "domain.tls.invalid_cert_chain", Summary: "Invalid Certificate Chain"}}},
+		},
+		{
+			"missing intermediate, completed by AIA",
+			"example.com",
+			[]*testCert{aiaLeaf},
+			chainMissingIntermediate,
+			true,
+			Issues{Errors: []Issue{{
+				Code:    "domain.tls.invalid_cert_chain",
+				Summary: "Invalid Certificate Chain",
+				Message: "https://example.com uses an incomplete or invalid certificate chain: missing intermediate certificate (the chain stops at \"example.com\"; some browsers fetch the missing certificate from the Authority Information Access URL, but many clients do not). Check out your site at https://www.ssllabs.com/ssltest/",
+			}}},
+		},
+		{
+			"missing intermediate, AIA fetch fails",
+			"example.com",
+			[]*testCert{brokenAIALeaf},
+			chainMissingIntermediate,
+			false,
+			Issues{Errors: []Issue{{
+				Code:    "domain.tls.invalid_cert_chain",
+				Summary: "Invalid Certificate Chain",
+				Message: "https://example.com uses an incomplete or invalid certificate chain: missing intermediate certificate (the chain stops at \"example.com\"; fetching the missing certificate from the Authority Information Access URL would not complete the chain either). Check out your site at https://www.ssllabs.com/ssltest/",
+			}}},
+		},
+		{
+			"missing intermediate, no AIA",
+			"example.com",
+			[]*testCert{subLeaf, intermediate},
+			chainMissingIntermediate,
+			false,
+			Issues{Errors: []Issue{{Code: "domain.tls.invalid_cert_chain"}}},
+		},
+		{
+			"wrong order",
+			"example.com",
+			[]*testCert{subLeaf, intermediate, subIntermediate},
+			chainWrongOrder,
+			false,
+			Issues{Errors: []Issue{{
+				Code:    "domain.tls.invalid_cert_chain",
+				Summary: "Invalid Certificate Chain",
+				Message: "https://example.com uses an incomplete or invalid certificate chain: certificates in the wrong order (each certificate should be followed by the certificate that issued it). Check out your site at https://www.ssllabs.com/ssltest/",
+			}}},
+		},
+		{
+			"expired intermediate",
+			"example.com",
+			[]*testCert{expiredIntermediateLeaf, expiredIntermediate},
+			chainExpiredIntermediate,
+			false,
+			Issues{Errors: []Issue{{
+				Code:    "domain.tls.invalid_cert_chain",
+				Summary: "Invalid Certificate Chain",
+				Message: "https://example.com uses an incomplete or invalid certificate chain: intermediate certificate \"Expired Intermediate\" expired on 2019-12-31T00:00:00Z. Check out your site at https://www.ssllabs.com/ssltest/",
+			}}},
+		},
+		{
+			"self-signed leaf",
+			"example.com",
+			[]*testCert{selfSigned},
+			chainSelfSignedLeaf,
+			false,
+			Issues{Errors: []Issue{{
+				Code:    "domain.tls.invalid_cert_chain",
+				Summary: "Invalid Certificate Chain",
+				Message: "https://example.com uses an incomplete or invalid certificate chain: self-signed certificate. Check out your site at https://www.ssllabs.com/ssltest/",
+			}}},
+		},
+		{
+			"name mismatch",
+			"example.net",
+			[]*testCert{leaf, intermediate},
+			chainNameMismatch,
+			false,
+			Issues{Errors: []Issue{{Code: "domain.tls.invalid_cert_chain"}}},
+		},
+		{
+			"untrusted root",
+			"example.com",
+			[]*testCert{untrustedLeaf, untrustedIntermediate, untrustedRoot},
+			chainUntrustedRoot,
+			false,
+			Issues{Errors: []Issue{{
+				Code:    "domain.tls.invalid_cert_chain",
+				Summary: "Invalid Certificate Chain",
+				Message: "https://example.com uses an incomplete or invalid certificate chain: untrusted root certificate \"Untrusted Root\". Check out your site at https://www.ssllabs.com/ssltest/",
+			}}},
+		},
+	}
+
+	for _, tt := range tests {
+		var certs []*x509.Certificate
+		for _, c := range tt.certs {
+			certs = append(certs, c.cert)
+		}
+
+		diagnosis := v.diagnoseChain(tt.domain, certs)
+		if diagnosis.problem != tt.expectedProblem {
+			t.Errorf("[%s] Unexpected problem: %q (%v)", tt.description, diagnosis.problem, diagnosis.err)
+		}
+		if diagnosis.aiaCompletes != tt.expectedAIACompletes {
+			t.Errorf("[%s] Unexpected AIA result: %t", tt.description, diagnosis.aiaCompletes)
+		}
+
+		issues := v.checkInvalidChain(tt.domain, certs)
+		if !issues.Match(tt.expectedIssues) {
+			t.Errorf("[%s] "+issuesShouldMatch, tt.description, issues, tt.expectedIssues)
+		}
+	}
+}
+
+func TestDiagnoseChainPluggableAIA(t *testing.T) {
+	root := newTestCA(t, "Test Root", nil)
+	intermediate := newTestCA(t, "Test Intermediate", root)
+	leaf := newTestLeafWithAIA(t, "example.com", intermediate, "http://aia.invalid/intermediate.cer")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	var fetched []string
+	v := chainVerifier{
+		roots: roots,
+		fetchAIA: func(u string) ([]*x509.Certificate, error) {
+			fetched = append(fetched, u)
+			return []*x509.Certificate{intermediate.cert}, nil
+		},
+		now: func() time.Time { return testNow },
+	}
+
+	diagnosis := v.diagnoseChain("example.com", []*x509.Certificate{leaf.cert})
+	if diagnosis.problem != chainMissingIntermediate || !diagnosis.aiaCompletes {
+		t.Errorf("Unexpected diagnosis: %+v", diagnosis)
+	}
+	if len(fetched) != 1 || fetched[0] != "http://aia.invalid/intermediate.cer" {
+		t.Errorf("Unexpected AIA fetches: %v", fetched)
+	}
+}
+
+func TestFetchAIAIssuersRestrictions(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	urls := []string{
+		server.URL + "/intermediate.cer",
+		"ftp://aia.example/intermediate.cer",
+		"ldap://aia.example/intermediate.cer",
+		"http://10.0.0.1/intermediate.cer",
+		"http://[::1]/intermediate.cer",
+	}
+	for _, u := range urls {
+		if _, err := fetchAIAIssuers(u); err == nil {
+			t.Errorf("Expected an error fetching %s", u)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("Unexpected requests to a local address: %d", requests)
+	}
+
+	for _, tt := range []struct {
+		ip       string
+		expected bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+	} {
+		if allowed := isPublicAddress(net.ParseIP(tt.ip)); allowed != tt.expected {
+			t.Errorf("isPublicAddress(%s) = %t, expected %t", tt.ip, allowed, tt.expected)
+		}
+	}
+}
+
+func TestFetchAIAIsOptIn(t *testing.T) {
+	if v := newRetrier(CheckOptions{}, new(int)).chainVerifier(); v.fetchAIA != nil {
+		t.Errorf("AIA fetching should be disabled by default")
+	}
+	if v := newRetrier(CheckOptions{FetchAIA: true}, new(int)).chainVerifier(); v.fetchAIA == nil {
+		t.Errorf("AIA fetching should be enabled with FetchAIA")
+	}
+}
@@ -0,0 +1,407 @@
+package preloadlist
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Special characters in the compiled trie. Names may only contain
+// characters in between.
+const (
+	// trieTerminalValue marks the end of a (reversed) name.
+	trieTerminalValue = 0
+	// trieEndOfTableValue marks the end of a dispatch table.
+	trieEndOfTableValue = 127
+)
+
+// Constants for the encoding of dispatch table positions.
+const (
+	shortOffsetMaxLength   = 7
+	longOffsetLengthLength = 4
+	maxOffsetLength        = shortOffsetMaxLength + 1<<longOffsetLengthLength
+)
+
+// A CompiledList is a preload list compiled into the Huffman-coded trie
+// that Chromium ships in transport_security_state_static.h, as produced by
+// net/tools/transport_security_state_generator.
+type CompiledList struct {
+	// HuffmanTree is the serialized Huffman tree for the characters of
+	// the trie (kHSTSHuffmanTree).
+	HuffmanTree []byte
+	// Trie holds the bits of the trie (kPreloadedHSTSData).
+	Trie []byte
+	// TrieBits is the number of bits of Trie that are used
+	// (kPreloadedHSTSBits).
+	TrieBits int
+	// RootPosition is the bit position of the root dispatch table
+	// (kHSTSRootPosition).
+	RootPosition int
+
+	// pinsets holds the names of the pinsets by ID, if known.
+	pinsets []string
+}
+
+// bitWriter writes bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	buf []byte
+	pos int
+}
+
+func (w *bitWriter) writeBits(bits uint32, n uint8) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.pos%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if bits>>uint(i)&1 == 1 {
+			w.buf[w.pos/8] |= 0x80 >> uint(w.pos%8)
+		}
+		w.pos++
+	}
+}
+
+// A trieElement is either a run of bits, or the position of the first
+// child of a dispatch table. The latter is encoded relative to the start
+// of the table, which is only known when the table is written out.
+type trieElement struct {
+	bits       uint32
+	n          uint8
+	isPosition bool
+	position   int
+}
+
+// trieBuffer collects the bits of a single dispatch table.
+type trieBuffer struct {
+	elements []trieElement
+	table    huffmanTable
+	usage    *huffmanBuilder
+}
+
+func (b *trieBuffer) writeBits(bits uint32, n uint8) {
+	b.elements = append(b.elements, trieElement{bits: bits, n: n})
+}
+
+func (b *trieBuffer) writeBit(bit bool) {
+	if bit {
+		b.writeBits(1, 1)
+	} else {
+		b.writeBits(0, 1)
+	}
+}
+
+func (b *trieBuffer) writeChar(c byte) {
+	if b.usage != nil {
+		b.usage.recordUsage(c)
+	}
+	code := b.table[c]
+	b.writeBits(code.bits, code.n)
+}
+
+// writeSize writes the length of a common prefix.
+func (b *trieBuffer) writeSize(size int) {
+	switch size {
+	case 0:
+		b.writeBits(0x0, 2)
+	case 1:
+		b.writeBits(0x4, 3)
+	case 2:
+		b.writeBits(0x5, 3)
+	case 3:
+		b.writeBits(0x6, 3)
+	default:
+		b.writeBit(size%2 == 1)
+		for n := (size + 1) / 2; n > 0; n-- {
+			b.writeBit(true)
+		}
+		b.writeBit(false)
+	}
+}
+
+// bitLength returns the number of bits needed to represent `v`.
+func bitLength(v int) uint8 {
+	var n uint8
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// writePosition writes the position of a child table. The first position
+// of a table is relative to the start of the table, and later ones are
+// relative to the previous position.
+func (b *trieBuffer) writePosition(position int, last *int) error {
+	if *last == -1 {
+		b.elements = append(b.elements, trieElement{isPosition: true, position: position})
+		*last = position
+		return nil
+	}
+
+	delta := position - *last
+	n := bitLength(delta)
+	switch {
+	case delta <= 0 || n > maxOffsetLength:
+		return fmt.Errorf("invalid position delta %d", delta)
+	case n <= shortOffsetMaxLength:
+		b.writeBits(0, 1)
+		b.writeBits(uint32(delta), shortOffsetMaxLength)
+	default:
+		b.writeBits(1, 1)
+		b.writeBits(uint32(n-shortOffsetMaxLength-1), longOffsetLengthLength)
+		b.writeBits(uint32(delta), n)
+	}
+	*last = position
+	return nil
+}
+
+// writeTo writes the table to `w`, returning its position.
+func (b *trieBuffer) writeTo(w *bitWriter) (int, error) {
+	start := w.pos
+	for _, e := range b.elements {
+		if !e.isPosition {
+			w.writeBits(e.bits, e.n)
+			continue
+		}
+		if e.position >= start {
+			return 0, fmt.Errorf("invalid child position %d for table at %d", e.position, start)
+		}
+		delta := start - e.position
+		n := bitLength(delta)
+		if n >= 32 {
+			return 0, fmt.Errorf("child position %d too far from table at %d", e.position, start)
+		}
+		w.writeBits(uint32(n), 5)
+		w.writeBits(uint32(delta), n)
+	}
+	return start, nil
+}
+
+// reversedEntry is an entry with the remaining (unwritten) part of its
+// reversed name, which ends in trieTerminalValue.
+type reversedEntry struct {
+	name  []byte
+	entry Entry
+}
+
+// trieWriter writes the dispatch tables of a trie.
+type trieWriter struct {
+	w       bitWriter
+	table   huffmanTable
+	usage   *huffmanBuilder
+	pinsets map[string]int
+}
+
+func (tw *trieWriter) writeEntry(b *trieBuffer, e Entry) error {
+	forceHTTPS := e.Mode == ForceHTTPS
+	// Simple entries, which only enable HSTS with include_subdomains, are
+	// written using a single bit.
+	if forceHTTPS && e.IncludeSubDomains && e.Pins == "" {
+		b.writeBit(true)
+		return nil
+	}
+	b.writeBit(false)
+
+	b.writeBit(e.IncludeSubDomains)
+	b.writeBit(forceHTTPS)
+	if e.Pins == "" {
+		b.writeBit(false)
+		return nil
+	}
+
+	id, ok := tw.pinsets[e.Pins]
+	if !ok {
+		return fmt.Errorf("%s: unknown pinset %q", e.Name, e.Pins)
+	}
+	b.writeBit(true)
+	b.writeBits(uint32(id), 4)
+	if !e.IncludeSubDomains {
+		b.writeBit(e.IncludeSubDomainsForPinning)
+	}
+	return nil
+}
+
+// commonPrefix returns the longest common prefix of the names of
+// `entries`, excluding the terminal value.
+func commonPrefix(entries []reversedEntry) []byte {
+	first := entries[0].name
+	var i int
+	for i = 0; i < len(first) && first[i] != trieTerminalValue; i++ {
+		for _, e := range entries[1:] {
+			if i >= len(e.name) || e.name[i] != first[i] {
+				return first[:i]
+			}
+		}
+	}
+	return first[:i]
+}
+
+// writeDispatchTables writes the tables for `entries`, which are sorted and
+// share the part of their names that has already been written. It returns
+// the position of the table for the entries.
+func (tw *trieWriter) writeDispatchTables(entries []reversedEntry) (int, error) {
+	b := trieBuffer{table: tw.table, usage: tw.usage}
+
+	prefix := commonPrefix(entries)
+	b.writeSize(len(prefix))
+	for _, c := range prefix {
+		b.writeChar(c)
+	}
+	for i := range entries {
+		entries[i].name = entries[i].name[len(prefix):]
+	}
+
+	last := -1
+	for len(entries) > 0 {
+		c := entries[0].name[0]
+		end := 1
+		for end < len(entries) && entries[end].name[0] == c {
+			end++
+		}
+
+		b.writeChar(c)
+		if c == trieTerminalValue {
+			if end != 1 {
+				return 0, fmt.Errorf("%s: duplicate entry", entries[0].entry.Name)
+			}
+			if err := tw.writeEntry(&b, entries[0].entry); err != nil {
+				return 0, err
+			}
+		} else {
+			for i := range entries[:end] {
+				entries[i].name = entries[i].name[1:]
+			}
+			position, err := tw.writeDispatchTables(entries[:end])
+			if err != nil {
+				return 0, err
+			}
+			if err := b.writePosition(position, &last); err != nil {
+				return 0, err
+			}
+		}
+		entries = entries[end:]
+	}
+	b.writeChar(trieEndOfTableValue)
+
+	return b.writeTo(&tw.w)
+}
+
+// reversedEntries returns the entries of `list` with reversed names, sorted
+// by their reversed names.
+func reversedEntries(list PreloadList) ([]reversedEntry, error) {
+	entries := make([]reversedEntry, 0, len(list.Entries))
+	for _, e := range list.Entries {
+		if e.Name == "" {
+			return nil, fmt.Errorf("entry without a name")
+		}
+		name := make([]byte, len(e.Name)+1)
+		for i := 0; i < len(e.Name); i++ {
+			c := e.Name[len(e.Name)-1-i]
+			if c == trieTerminalValue || c >= trieEndOfTableValue || ('A' <= c && c <= 'Z') {
+				return nil, fmt.Errorf("%s: name must be a lowercase ASCII name", e.Name)
+			}
+			name[i] = c
+		}
+		name[len(e.Name)] = trieTerminalValue
+		entries = append(entries, reversedEntry{name: name, entry: e})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].name, entries[j].name) < 0
+	})
+	return entries, nil
+}
+
+// Compile compiles the list into the Huffman-coded trie used by Chromium.
+//
+// Like Chromium's generator, Compile makes two passes: the first records
+// how often each character is written, and the second writes the trie
+// using the optimal Huffman code for those counts. Entries are encoded
+// with their HSTS fields and pinset (whose ID is the index of its name in
+// the sorted names of p.Pinsets); Expect-CT fields are not part of the
+// compiled form.
+func (p PreloadList) Compile() (CompiledList, error) {
+	if len(p.Entries) == 0 {
+		return CompiledList{}, fmt.Errorf("cannot compile an empty list")
+	}
+	if len(p.Pinsets) > 16 {
+		return CompiledList{}, fmt.Errorf("too many pinsets (%d)", len(p.Pinsets))
+	}
+	// Like Chromium's generator, which keeps pinsets in a map by name,
+	// pinset IDs follow the order of the names.
+	var pinsetNames []string
+	for _, pinset := range p.Pinsets {
+		pinsetNames = append(pinsetNames, pinset.Name)
+	}
+	sort.Strings(pinsetNames)
+	pinsets := make(map[string]int)
+	for i, name := range pinsetNames {
+		pinsets[name] = i
+	}
+
+	entries, err := reversedEntries(p)
+	if err != nil {
+		return CompiledList{}, err
+	}
+
+	// First pass: record character usage, using a code based on the
+	// characters of the names.
+	approximate := newHuffmanBuilder()
+	for _, e := range entries {
+		for _, c := range e.name {
+			approximate.recordUsage(c)
+		}
+		approximate.recordUsage(trieEndOfTableValue)
+	}
+	usage := newHuffmanBuilder()
+	first := trieWriter{table: approximate.table(), usage: usage, pinsets: pinsets}
+	if _, err := first.writeDispatchTables(entries); err != nil {
+		return CompiledList{}, err
+	}
+
+	// Second pass: write the trie with the optimal code.
+	entries, _ = reversedEntries(p)
+	second := trieWriter{table: usage.table(), pinsets: pinsets}
+	root, err := second.writeDispatchTables(entries)
+	if err != nil {
+		return CompiledList{}, err
+	}
+	tree, err := usage.tree()
+	if err != nil {
+		return CompiledList{}, err
+	}
+
+	return CompiledList{
+		HuffmanTree:  tree,
+		Trie:         second.w.buf,
+		TrieBits:     second.w.pos,
+		RootPosition: root,
+		pinsets:      pinsetNames,
+	}, nil
+}
+
+func writeByteArray(w io.Writer, name string, data []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "static const uint8_t %s[] = {", name)
+	for i, b := range data {
+		if i%12 == 0 {
+			buf.WriteString("\n   ")
+		}
+		fmt.Fprintf(&buf, " 0x%02x,", b)
+	}
+	buf.WriteString("\n};\n\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// WriteHeader writes the compiled list in the form of the HSTS part of
+// Chromium's transport_security_state_static.h.
+func (c CompiledList) WriteHeader(w io.Writer) error {
+	if err := writeByteArray(w, "kHSTSHuffmanTree", c.HuffmanTree); err != nil {
+		return err
+	}
+	if err := writeByteArray(w, "kPreloadedHSTSData", c.Trie); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "static const unsigned kPreloadedHSTSBits = %d;\n\nstatic const unsigned kHSTSRootPosition = %d;\n",
+		c.TrieBits, c.RootPosition)
+	return err
+}
@@ -0,0 +1,229 @@
+package preloadlist
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCompileSingleEntry(t *testing.T) {
+	list := PreloadList{Entries: []Entry{
+		{Name: "a", Policy: Test, Mode: ForceHTTPS, IncludeSubDomains: true},
+	}}
+	c, err := list.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The characters 127, 0 and 'a' are each used once, which gives the
+	// codes 0, 10 and 11. The trie is then a prefix of size 1 (100), 'a'
+	// (11), the terminal value (10), a simple entry (1), and the end of
+	// the table (0).
+	expected := CompiledList{
+		HuffmanTree:  []byte{0x80, 0xe1, 0xff, 0x00},
+		Trie:         []byte{0x9d, 0x00},
+		TrieBits:     9,
+		RootPosition: 0,
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Unexpected compiled list: %#v", c)
+	}
+}
+
+// The files in testdata/compile are a small preload list and the HSTS data
+// that Chromium's transport_security_state_generator produces for it. See
+// testdata/compile/README.md to regenerate them.
+const (
+	compileTestdataList   = "testdata/compile/transport_security_state_static.json"
+	compileTestdataHeader = "testdata/compile/transport_security_state_static.h"
+)
+
+func TestCompileMatchesChromium(t *testing.T) {
+	list, err := NewFromFile(compileTestdataList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(compileTestdataHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	golden, err := ParseCompiledHeader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := list.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.HuffmanTree, golden.HuffmanTree) {
+		t.Errorf("HuffmanTree = %x, expected %x", c.HuffmanTree, golden.HuffmanTree)
+	}
+	if !bytes.Equal(c.Trie, golden.Trie) {
+		t.Errorf("Trie = %x, expected %x", c.Trie, golden.Trie)
+	}
+	if c.TrieBits != golden.TrieBits {
+		t.Errorf("TrieBits = %d, expected %d", c.TrieBits, golden.TrieBits)
+	}
+	if c.RootPosition != golden.RootPosition {
+		t.Errorf("RootPosition = %d, expected %d", c.RootPosition, golden.RootPosition)
+	}
+
+	// Pinset IDs follow the sorted pinset names: example, google, test.
+	lookupTests := []struct {
+		domain   string
+		expected Entry
+	}{
+		{"google.com", Entry{Name: "google.com", Mode: ForceHTTPS, Pins: "pinset-1", IncludeSubDomainsForPinning: true}},
+		{"pinning-test.example.com", Entry{Name: "pinning-test.example.com", Pins: "pinset-2", IncludeSubDomains: true}},
+		{"pinned.example.org", Entry{Name: "pinned.example.org", Mode: ForceHTTPS, Pins: "pinset-0"}},
+		{"xn--bcher-kva.example", Entry{Name: "xn--bcher-kva.example", Mode: ForceHTTPS, IncludeSubDomains: true}},
+	}
+	for _, tt := range lookupTests {
+		entry, found, err := golden.Lookup(tt.domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != ExactEntryFound || entry != tt.expected {
+			t.Errorf("Lookup(%q) = %#v, %v, expected %#v", tt.domain, entry, found, tt.expected)
+		}
+	}
+}
+
+var compileTestList = PreloadList{
+	Pinsets: []Pinset{{Name: "test"}, {Name: "google"}},
+	Entries: []Entry{
+		{Name: "example.com", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "example.org", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "www.example.org", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "sub.example.com", Policy: Custom, Mode: ForceHTTPS},
+		{Name: "app", Policy: PublicSuffix, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "pinned.example", Policy: Custom, Mode: ForceHTTPS, Pins: "google", IncludeSubDomainsForPinning: true},
+		{Name: "pinned.example.net", Policy: Custom, Pins: "test", IncludeSubDomains: true},
+		{Name: "xn--bcher-kva.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+	},
+}
+
+func TestCompileEntries(t *testing.T) {
+	c, err := compileTestList.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries come back in the order of their reversed names, without
+	// policies.
+	expected := []Entry{
+		{Name: "xn--bcher-kva.example", Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "pinned.example", Mode: ForceHTTPS, Pins: "google", IncludeSubDomainsForPinning: true},
+		{Name: "example.org", Mode: ForceHTTPS},
+		{Name: "www.example.org", Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "example.com", Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "sub.example.com", Mode: ForceHTTPS},
+		{Name: "app", Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "pinned.example.net", Pins: "test", IncludeSubDomains: true},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Unexpected entries: %#v", entries)
+	}
+}
+
+func TestCompiledLookup(t *testing.T) {
+	c, err := compileTestList.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		domain string
+		entry  string
+		found  HstsPreloadEntryFound
+	}{
+		{"example.com", "example.com", ExactEntryFound},
+		{"EXAMPLE.com", "example.com", ExactEntryFound},
+		{"www.example.com", "example.com", AncestorEntryFound},
+		{"sub.example.com", "sub.example.com", ExactEntryFound},
+		// The most specific entry decides, even though example.com
+		// includes subdomains.
+		{"www.sub.example.com", "sub.example.com", EntryNotFound},
+		{"www.example.org", "www.example.org", ExactEntryFound},
+		{"a.www.example.org", "www.example.org", AncestorEntryFound},
+		{"foo.example.org", "example.org", EntryNotFound},
+		{"example.app", "app", AncestorEntryFound},
+		{"bücher.example", "xn--bcher-kva.example", ExactEntryFound},
+		{"example", "", EntryNotFound},
+		{"notexample.com", "", EntryNotFound},
+		{"xexample.com", "", EntryNotFound},
+		{"com", "", EntryNotFound},
+		{"", "", EntryNotFound},
+	}
+	for _, tt := range tests {
+		entry, found, err := c.Lookup(tt.domain)
+		if err != nil {
+			t.Errorf("Lookup(%q): %s", tt.domain, err)
+			continue
+		}
+		if entry.Name != tt.entry || found != tt.found {
+			t.Errorf("Lookup(%q) = %q, %v, expected %q, %v", tt.domain, entry.Name, found, tt.entry, tt.found)
+		}
+	}
+
+	entry, _, _ := c.Lookup("pinned.example")
+	expected := Entry{Name: "pinned.example", Mode: ForceHTTPS, Pins: "google", IncludeSubDomainsForPinning: true}
+	if entry != expected {
+		t.Errorf("Unexpected entry: %#v", entry)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []PreloadList{
+		{},
+		{Entries: []Entry{{Name: "Example.com"}}},
+		{Entries: []Entry{{Name: "example.com"}, {Name: "example.com"}}},
+		{Entries: []Entry{{Name: "example.com", Pins: "missing"}}},
+	}
+	for _, list := range tests {
+		if _, err := list.Compile(); err == nil {
+			t.Errorf("Expected an error compiling %v", list.Entries)
+		}
+	}
+}
+
+func TestCompiledHeader(t *testing.T) {
+	c, err := compileTestList.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteHeader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseCompiledHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.pinsets = nil
+	if !reflect.DeepEqual(parsed, c) {
+		t.Errorf("Header did not round-trip: %#v", parsed)
+	}
+
+	entry, _, err := parsed.Lookup("pinned.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// google sorts before test.
+	if entry.Pins != "pinset-0" {
+		t.Errorf("Unexpected pinset name %q", entry.Pins)
+	}
+
+	if _, err := ParseCompiledHeader(bytes.NewReader([]byte("static const unsigned kHSTSRootPosition = 1;"))); err == nil {
+		t.Errorf("Expected an error for an incomplete header")
+	}
+}
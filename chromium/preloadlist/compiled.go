@@ -0,0 +1,390 @@
+package preloadlist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errTrieEnd is returned when reading past the end of a compiled trie.
+var errTrieEnd = errors.New("unexpected end of compiled trie")
+
+// bitReader reads bits from a compiled trie, most significant bit first.
+type bitReader struct {
+	buf  []byte
+	bits int
+	pos  int
+}
+
+func (r *bitReader) next() (uint32, error) {
+	if r.pos >= r.bits {
+		return 0, errTrieEnd
+	}
+	bit := uint32(r.buf[r.pos/8]>>(7-uint(r.pos%8))) & 1
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) read(n uint32) (uint32, error) {
+	if n > 32 {
+		return 0, fmt.Errorf("cannot read %d bits", n)
+	}
+	var v uint32
+	for i := uint32(0); i < n; i++ {
+		bit, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	return v, nil
+}
+
+func (r *bitReader) seek(pos int) error {
+	if pos < 0 || pos >= r.bits {
+		return fmt.Errorf("invalid trie position %d", pos)
+	}
+	r.pos = pos
+	return nil
+}
+
+// readSize reads the length of a common prefix (see trieBuffer.writeSize).
+func (r *bitReader) readSize() (int, error) {
+	first, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+	ones := 0
+	if first == 1 {
+		// 100, 101 and 110 encode 1, 2 and 3. Longer odd sizes are 1
+		// followed by at least three 1s.
+		b, err := r.read(2)
+		if err != nil {
+			return 0, err
+		}
+		if b != 3 {
+			return int(b) + 1, nil
+		}
+		ones = 2
+	}
+	for {
+		bit, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		ones++
+	}
+	if first == 1 {
+		return 2*ones - 1, nil
+	}
+	return 2 * ones, nil
+}
+
+// readPosition reads the position of a child table (see
+// trieBuffer.writePosition).
+func (r *bitReader) readPosition(tableStart int, first bool, last int) (int, error) {
+	if first {
+		n, err := r.read(5)
+		if err != nil {
+			return 0, err
+		}
+		delta, err := r.read(n)
+		if err != nil {
+			return 0, err
+		}
+		if int(delta) > tableStart {
+			return 0, fmt.Errorf("invalid child position in table at %d", tableStart)
+		}
+		return tableStart - int(delta), nil
+	}
+
+	long, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+	n := uint32(shortOffsetMaxLength)
+	if long == 1 {
+		length, err := r.read(longOffsetLengthLength)
+		if err != nil {
+			return 0, err
+		}
+		n = length + shortOffsetMaxLength + 1
+	}
+	delta, err := r.read(n)
+	if err != nil {
+		return 0, err
+	}
+	position := last + int(delta)
+	if position >= tableStart {
+		return 0, fmt.Errorf("invalid child position in table at %d", tableStart)
+	}
+	return position, nil
+}
+
+func (c CompiledList) reader() *bitReader {
+	bits := c.TrieBits
+	if bits > 8*len(c.Trie) {
+		bits = 8 * len(c.Trie)
+	}
+	return &bitReader{buf: c.Trie, bits: bits}
+}
+
+// readEntry reads the fields of an entry (see trieWriter.writeEntry).
+func (c CompiledList) readEntry(r *bitReader, name string) (Entry, error) {
+	e := Entry{Name: name}
+	simple, err := r.next()
+	if err != nil {
+		return Entry{}, err
+	}
+	if simple == 1 {
+		e.Mode = ForceHTTPS
+		e.IncludeSubDomains = true
+		return e, nil
+	}
+
+	flags, err := r.read(3)
+	if err != nil {
+		return Entry{}, err
+	}
+	e.IncludeSubDomains = flags&4 != 0
+	if flags&2 != 0 {
+		e.Mode = ForceHTTPS
+	}
+	if flags&1 == 0 {
+		return e, nil
+	}
+
+	id, err := r.read(4)
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Pins = fmt.Sprintf("pinset-%d", id)
+	if int(id) < len(c.pinsets) {
+		e.Pins = c.pinsets[id]
+	}
+	if !e.IncludeSubDomains {
+		forPinning, err := r.next()
+		if err != nil {
+			return Entry{}, err
+		}
+		e.IncludeSubDomainsForPinning = forPinning == 1
+	}
+	return e, nil
+}
+
+func reverseString(s []byte) string {
+	b := make([]byte, len(s))
+	for i, c := range s {
+		b[len(s)-1-i] = c
+	}
+	return string(b)
+}
+
+// Entries decodes all entries of the compiled list, in the order of their
+// reversed names.
+//
+// Pinsets are named as in the list that was compiled, or "pinset-<id>" if
+// the list was parsed with ParseCompiledHeader.
+func (c CompiledList) Entries() ([]Entry, error) {
+	var entries []Entry
+	r := c.reader()
+
+	var walk func(pos int, reversed []byte) error
+	walk = func(pos int, reversed []byte) error {
+		if err := r.seek(pos); err != nil {
+			return err
+		}
+		size, err := r.readSize()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			ch, err := decodeHuffman(c.HuffmanTree, r)
+			if err != nil {
+				return err
+			}
+			reversed = append(reversed, ch)
+		}
+
+		type child struct {
+			pos int
+			ch  byte
+		}
+		var children []child
+		last := -1
+		for {
+			ch, err := decodeHuffman(c.HuffmanTree, r)
+			if err != nil {
+				return err
+			}
+			if ch == trieEndOfTableValue {
+				break
+			}
+			if ch == trieTerminalValue {
+				e, err := c.readEntry(r, reverseString(reversed))
+				if err != nil {
+					return err
+				}
+				entries = append(entries, e)
+				continue
+			}
+			last, err = r.readPosition(pos, last == -1, last)
+			if err != nil {
+				return err
+			}
+			children = append(children, child{last, ch})
+		}
+
+		for _, ch := range children {
+			name := append(reversed[:len(reversed):len(reversed)], ch.ch)
+			if err := walk(ch.pos, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(c.RootPosition, nil); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return reverseString([]byte(entries[i].Name))+"\x00" < reverseString([]byte(entries[j].Name))+"\x00"
+	})
+	return entries, nil
+}
+
+// Lookup looks up a domain in the compiled list, the way Chromium does.
+//
+// This differs from IndexedEntries.Get in one way: the most specific entry
+// for a domain or its ancestors decides. If that entry is for an ancestor
+// and does not include subdomains, the domain is not preloaded even if a
+// more distant ancestor includes subdomains. In that case, Lookup returns
+// EntryNotFound along with the entry that applied.
+func (c CompiledList) Lookup(domain string) (Entry, HstsPreloadEntryFound, error) {
+	search := lookupKey(domain)
+	r := c.reader()
+	pos := c.RootPosition
+	// remaining is the number of characters of `search` that have not been
+	// matched yet, from the end.
+	remaining := len(search)
+
+	var entry Entry
+	found := EntryNotFound
+	for {
+		if err := r.seek(pos); err != nil {
+			return Entry{}, EntryNotFound, err
+		}
+		size, err := r.readSize()
+		if err != nil {
+			return Entry{}, EntryNotFound, err
+		}
+		for i := 0; i < size; i++ {
+			ch, err := decodeHuffman(c.HuffmanTree, r)
+			if err != nil {
+				return Entry{}, EntryNotFound, err
+			}
+			if remaining == 0 || search[remaining-1] != ch {
+				return entry, found, nil
+			}
+			remaining--
+		}
+
+		next := -1
+		last := -1
+		for next == -1 {
+			ch, err := decodeHuffman(c.HuffmanTree, r)
+			if err != nil {
+				return Entry{}, EntryNotFound, err
+			}
+			switch ch {
+			case trieEndOfTableValue:
+				return entry, found, nil
+			case trieTerminalValue:
+				e, err := c.readEntry(r, search[remaining:])
+				if err != nil {
+					return Entry{}, EntryNotFound, err
+				}
+				switch {
+				case remaining == 0:
+					return e, ExactEntryFound, nil
+				case search[remaining-1] == '.':
+					entry, found = e, EntryNotFound
+					if e.IncludeSubDomains {
+						found = AncestorEntryFound
+					}
+				}
+				continue
+			}
+
+			last, err = r.readPosition(pos, last == -1, last)
+			if err != nil {
+				return Entry{}, EntryNotFound, err
+			}
+			if remaining > 0 && search[remaining-1] == ch {
+				next = last
+				remaining--
+			}
+		}
+		pos = next
+	}
+}
+
+var (
+	headerArrayPattern    = regexp.MustCompile(`(?s)\b(kHSTSHuffmanTree|kPreloadedHSTSData)\s*\[\s*\]\s*=\s*\{(.*?)\}`)
+	headerConstantPattern = regexp.MustCompile(`\b(kPreloadedHSTSBits|kHSTSRootPosition)\s*=\s*(\d+)`)
+)
+
+// ParseCompiledHeader reads the HSTS trie from a Chromium
+// transport_security_state_static.h file, such as one written by
+// CompiledList.WriteHeader or generated during a Chromium build.
+func ParseCompiledHeader(r io.Reader) (CompiledList, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return CompiledList{}, err
+	}
+
+	var c CompiledList
+	seen := make(map[string]bool)
+	for _, m := range headerArrayPattern.FindAllStringSubmatch(string(b), -1) {
+		var data []byte
+		for _, field := range strings.FieldsFunc(m[2], func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\t' || r == '\r' }) {
+			v, err := strconv.ParseUint(field, 0, 8)
+			if err != nil {
+				return CompiledList{}, fmt.Errorf("%s: invalid byte %q", m[1], field)
+			}
+			data = append(data, byte(v))
+		}
+		if m[1] == "kHSTSHuffmanTree" {
+			c.HuffmanTree = data
+		} else {
+			c.Trie = data
+		}
+		seen[m[1]] = true
+	}
+	for _, m := range headerConstantPattern.FindAllStringSubmatch(string(b), -1) {
+		v, err := strconv.Atoi(m[2])
+		if err != nil {
+			return CompiledList{}, fmt.Errorf("%s: %w", m[1], err)
+		}
+		if m[1] == "kPreloadedHSTSBits" {
+			c.TrieBits = v
+		} else {
+			c.RootPosition = v
+		}
+		seen[m[1]] = true
+	}
+
+	for _, name := range []string{"kHSTSHuffmanTree", "kPreloadedHSTSData", "kPreloadedHSTSBits", "kHSTSRootPosition"} {
+		if !seen[name] {
+			return CompiledList{}, fmt.Errorf("missing %s", name)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,265 @@
+package preloadlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A ParseError describes a problem at a specific line of a preload list.
+type ParseError struct {
+	// Line is the 1-based line number in the original input, counting
+	// comment lines.
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// commentFilter is a reader that replaces comment lines (as defined by
+// removeComments) with empty lines, so that the remaining text can be
+// decoded as JSON without reading the whole input into memory.
+//
+// It remembers the output offset at which each line starts, so that
+// decoding errors can be mapped back to line numbers.
+type commentFilter struct {
+	r   *bufio.Reader
+	buf []byte
+	// The output offset at the end of buf.
+	offset int64
+	// Output offsets of the starts of lines from firstLine onwards.
+	lineStarts []int64
+	firstLine  int
+	err        error
+}
+
+func newCommentFilter(r io.Reader) *commentFilter {
+	return &commentFilter{
+		r:          bufio.NewReader(r),
+		lineStarts: []int64{0},
+		firstLine:  1,
+	}
+}
+
+func (f *commentFilter) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+
+		line, err := f.r.ReadString('\n')
+		f.err = err
+		endsLine := strings.HasSuffix(line, "\n")
+		if isCommentLine(line) {
+			// Keep the line structure intact.
+			line = ""
+			if endsLine {
+				line = "\n"
+			}
+		}
+
+		f.offset += int64(len(line))
+		if endsLine {
+			f.lineStarts = append(f.lineStarts, f.offset)
+		}
+		f.buf = []byte(line)
+	}
+
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// line returns the line number of the given output offset.
+func (f *commentFilter) line(offset int64) int {
+	i := sort.Search(len(f.lineStarts), func(i int) bool {
+		return f.lineStarts[i] > offset
+	})
+	return f.firstLine + i - 1
+}
+
+// forget discards line information for offsets before `offset`, since
+// errors will not be reported before that point.
+func (f *commentFilter) forget(offset int64) {
+	i := sort.Search(len(f.lineStarts), func(i int) bool {
+		return f.lineStarts[i] > offset
+	}) - 1
+	if i > 0 {
+		f.lineStarts = append(f.lineStarts[:0], f.lineStarts[i:]...)
+		f.firstLine += i
+	}
+}
+
+// A Decoder reads the entries of a preload list one at a time, without
+// holding the whole list in memory.
+//
+// Comments are skipped the same way as by Parse. Top-level keys other than
+// "entries" are skipped.
+type Decoder struct {
+	filter    *commentFilter
+	dec       *json.Decoder
+	started   bool
+	inEntries bool
+	err       error
+}
+
+// NewDecoder returns a Decoder that reads a preload list from `r`.
+func NewDecoder(r io.Reader) *Decoder {
+	filter := newCommentFilter(r)
+	return &Decoder{
+		filter: filter,
+		dec:    json.NewDecoder(filter),
+	}
+}
+
+// wrapError adds line information to `err`.
+func (d *Decoder) wrapError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	offset := d.dec.InputOffset()
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	}
+
+	return &ParseError{Line: d.filter.line(offset), Err: err}
+}
+
+func (d *Decoder) expectDelim(want json.Delim) error {
+	t, err := d.dec.Token()
+	if err != nil {
+		return d.wrapError(err)
+	}
+	if t != want {
+		return d.wrapError(fmt.Errorf("expected %q, found %v", want, t))
+	}
+	return nil
+}
+
+// skipValue skips over the next JSON value.
+func (d *Decoder) skipValue() error {
+	depth := 0
+	for {
+		t, err := d.dec.Token()
+		if err != nil {
+			return d.wrapError(err)
+		}
+
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// findEntries advances the decoder to the first entry in the "entries"
+// array. It returns false if the list has no more entries.
+func (d *Decoder) findEntries() (bool, error) {
+	if !d.started {
+		d.started = true
+		if err := d.expectDelim('{'); err != nil {
+			return false, err
+		}
+	}
+
+	for d.dec.More() {
+		t, err := d.dec.Token()
+		if err != nil {
+			return false, d.wrapError(err)
+		}
+
+		if key, ok := t.(string); ok && key == "entries" {
+			if err := d.expectDelim('['); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+
+		if err := d.skipValue(); err != nil {
+			return false, err
+		}
+	}
+
+	return false, d.expectDelim('}')
+}
+
+// Next returns the next entry in the list. It returns io.EOF when there are
+// no more entries. Errors other than io.EOF are of type *ParseError.
+func (d *Decoder) Next() (Entry, error) {
+	if d.err != nil {
+		return Entry{}, d.err
+	}
+
+	entry, err := d.next()
+	if err != nil {
+		d.err = err
+	}
+	return entry, err
+}
+
+func (d *Decoder) next() (entry Entry, err error) {
+	for {
+		if !d.inEntries {
+			found, err := d.findEntries()
+			if err != nil {
+				return entry, err
+			}
+			if !found {
+				return entry, io.EOF
+			}
+			d.inEntries = true
+		}
+
+		if !d.dec.More() {
+			d.inEntries = false
+			if err := d.expectDelim(']'); err != nil {
+				return entry, err
+			}
+			continue
+		}
+
+		if err := d.dec.Decode(&entry); err != nil {
+			return entry, d.wrapError(err)
+		}
+		d.filter.forget(d.dec.InputOffset())
+		return entry, nil
+	}
+}
+
+// ForEachEntry calls `fn` for every entry of the preload list read from
+// `r`, in order. It stops at the first error returned by `fn`.
+func ForEachEntry(r io.Reader, fn func(Entry) error) error {
+	d := NewDecoder(r)
+	for {
+		entry, err := d.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
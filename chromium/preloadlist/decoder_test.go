@@ -0,0 +1,130 @@
+package preloadlist
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	d := NewDecoder(strings.NewReader(testJSON))
+
+	var entries []Entry
+	for {
+		entry, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if !reflect.DeepEqual(entries, testParsed.Entries) {
+		t.Errorf("Decoded entries do not match expected. %#v", entries)
+	}
+
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last entry, got %v", err)
+	}
+}
+
+func TestDecoderSkipsOtherKeys(t *testing.T) {
+	list := `{
+  // Pinsets come first in the real list.
+  "pinsets": [
+    {"name": "test", "static_spki_hashes": ["TestSPKI"], "report_uri": "https://example.com/"}
+  ],
+  "entries": [
+    {"name": "example.com", "policy": "custom", "mode": "force-https"}
+  ],
+  "other": {"nested": [1, 2, {"x": []}]}
+}`
+
+	var entries []Entry
+	err := ForEachEntry(strings.NewReader(list), func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Entry{{Name: "example.com", Mode: ForceHTTPS, Policy: Custom}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Decoded entries do not match expected. %#v", entries)
+	}
+}
+
+func TestDecoderSyntaxErrorLine(t *testing.T) {
+	tests := []struct {
+		description  string
+		list         string
+		expectedLine int
+	}{
+		{
+			"missing comma",
+			`{
+  "entries": [
+    // A comment.
+    // Another comment.
+    {"name": "example.com"},
+    {"name": "example.net"}
+    {"name": "example.org"}
+  ]
+}`,
+			7,
+		},
+		{
+			"wrong type",
+			`{
+  "entries": [
+    {"name": "example.com", "include_subdomains": "yes"}
+  ]
+}`,
+			3,
+		},
+		{
+			"truncated",
+			`{
+  "entries": [
+    {"name": "example.com"},`,
+			3,
+		},
+	}
+
+	for _, tt := range tests {
+		err := ForEachEntry(strings.NewReader(tt.list), func(Entry) error { return nil })
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("[%s] Expected a ParseError, got %v", tt.description, err)
+			continue
+		}
+		if parseErr.Line != tt.expectedLine {
+			t.Errorf("[%s] Wrong line: %d (%s)", tt.description, parseErr.Line, parseErr)
+		}
+	}
+}
+
+func TestForEachEntryStops(t *testing.T) {
+	stop := errors.New("stop")
+
+	count := 0
+	err := ForEachEntry(strings.NewReader(testJSON), func(Entry) error {
+		count++
+		if count == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Expected the callback error, got %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Callback called %d times", count)
+	}
+}
@@ -0,0 +1,62 @@
+package preloadlist
+
+// Diff compares two versions of a preload list, matching entries by
+// (case-insensitive) name. Unicode names match their A-label form.
+//
+// Changes to existing entries are listed first, in the order of `before`,
+// followed by added entries in the order of `after`. An entry with several
+// changed fields produces one Change per field.
+func Diff(before, after PreloadList) []Change {
+	beforeIdx := before.Index()
+	afterIdx := after.Index()
+
+	var changes []Change
+	seen := make(map[string]bool)
+	for _, o := range before.Entries {
+		name := lookupKey(o.Name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		o, _ := beforeIdx.lookup(name)
+
+		n, ok := afterIdx.lookup(name)
+		if !ok {
+			changes = append(changes, Change{Type: EntryRemoved, Name: o.Name, Old: &o})
+			continue
+		}
+		if o.Equal(n) {
+			continue
+		}
+
+		fieldChanged := false
+		for _, c := range []struct {
+			changed    bool
+			changeType ChangeType
+		}{
+			{o.Policy != n.Policy, PolicyChanged},
+			{o.IncludeSubDomains != n.IncludeSubDomains, IncludeSubDomainsChanged},
+			{o.Mode != n.Mode, ModeChanged},
+		} {
+			if c.changed {
+				changes = append(changes, Change{Type: c.changeType, Name: n.Name, Old: &o, New: &n})
+				fieldChanged = true
+			}
+		}
+		if !fieldChanged {
+			changes = append(changes, Change{Type: EntryChanged, Name: n.Name, Old: &o, New: &n})
+		}
+	}
+
+	for _, n := range after.Entries {
+		name := lookupKey(n.Name)
+		if _, ok := beforeIdx.lookup(name); ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		n, _ := afterIdx.lookup(name)
+		changes = append(changes, Change{Type: EntryAdded, Name: n.Name, New: &n})
+	}
+
+	return changes
+}
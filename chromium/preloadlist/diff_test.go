@@ -0,0 +1,50 @@
+package preloadlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	before := PreloadList{Entries: []Entry{
+		{Name: "removed.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "same.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "policy.example", Policy: Bulk18Weeks, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "subdomains.example", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "mode.example", Policy: Custom, Mode: ForceHTTPS},
+		{Name: "pins.example", Policy: Custom, Pins: "test"},
+		{Name: "both.example", Policy: Bulk18Weeks, Mode: ForceHTTPS},
+	}}
+	after := PreloadList{Entries: []Entry{
+		{Name: "added.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "same.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "policy.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "subdomains.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "mode.example", Policy: Custom},
+		{Name: "pins.example", Policy: Custom, Pins: "google"},
+		{Name: "BOTH.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+	}}
+
+	var summary []string
+	for _, c := range Diff(before, after) {
+		summary = append(summary, c.String())
+	}
+
+	expected := []string{
+		`removed removed.example (policy: "bulk-1-year", mode: "force-https", include_subdomains: true)`,
+		`policy.example: policy changed from "bulk-18-weeks" to "bulk-1-year"`,
+		`subdomains.example: include_subdomains changed from false to true`,
+		`mode.example: mode changed from "force-https" to ""`,
+		`pins.example: changed from { "name": "pins.example", "policy": "custom", "pins": "test" } to { "name": "pins.example", "policy": "custom", "pins": "google" }`,
+		`BOTH.example: policy changed from "bulk-18-weeks" to "bulk-1-year"`,
+		`BOTH.example: include_subdomains changed from false to true`,
+		`added added.example (policy: "bulk-1-year", mode: "force-https", include_subdomains: true)`,
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("Unexpected diff: %#v", summary)
+	}
+
+	if changes := Diff(before, before); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+}
@@ -0,0 +1,281 @@
+package preloadlist
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrDuplicateEntry is returned when adding an entry whose name is
+	// already on the list.
+	ErrDuplicateEntry = errors.New("entry already exists")
+	// ErrEntryNotFound is returned when editing an entry that is not on
+	// the list.
+	ErrEntryNotFound = errors.New("entry not found")
+	// ErrNoChange is returned when an edit would not change the list.
+	ErrNoChange = errors.New("entry is already in the requested state")
+)
+
+// ChangeType identifies the kind of a Change.
+type ChangeType string
+
+// Possible ChangeType values.
+const (
+	EntryAdded               ChangeType = "added"
+	EntryRemoved             ChangeType = "removed"
+	PolicyChanged            ChangeType = "policy_changed"
+	IncludeSubDomainsChanged ChangeType = "include_subdomains_changed"
+	ModeChanged              ChangeType = "mode_changed"
+	// EntryChanged indicates a change to fields other than the policy,
+	// mode, or include_subdomains (e.g. pins).
+	EntryChanged ChangeType = "changed"
+)
+
+// A Change describes a change to a single entry of a PreloadList.
+//
+// Old is nil for added entries, and New is nil for removed entries.
+type Change struct {
+	Type ChangeType `json:"type"`
+	Name string     `json:"name"`
+	Old  *Entry     `json:"old,omitempty"`
+	New  *Entry     `json:"new,omitempty"`
+}
+
+func describeEntry(e Entry) string {
+	return fmt.Sprintf("policy: %q, mode: %q, include_subdomains: %t", e.Policy, e.Mode, e.IncludeSubDomains)
+}
+
+// String describes the change in a single line.
+func (c Change) String() string {
+	switch c.Type {
+	case EntryAdded:
+		return fmt.Sprintf("added %s (%s)", c.Name, describeEntry(*c.New))
+	case EntryRemoved:
+		return fmt.Sprintf("removed %s (%s)", c.Name, describeEntry(*c.Old))
+	case PolicyChanged:
+		return fmt.Sprintf("%s: policy changed from %q to %q", c.Name, c.Old.Policy, c.New.Policy)
+	case IncludeSubDomainsChanged:
+		return fmt.Sprintf("%s: include_subdomains changed from %t to %t", c.Name, c.Old.IncludeSubDomains, c.New.IncludeSubDomains)
+	case ModeChanged:
+		return fmt.Sprintf("%s: mode changed from %q to %q", c.Name, c.Old.Mode, c.New.Mode)
+	case EntryChanged:
+		return fmt.Sprintf("%s: changed from %s to %s", c.Name, formatEntry(*c.Old), formatEntry(*c.New))
+	}
+	return fmt.Sprintf("%s: %s", c.Name, c.Type)
+}
+
+// NormalizeName returns the normalized form of a domain name, as used in
+// the Chromium preload list: lowercase A-labels, without surrounding
+// whitespace or a trailing dot. Names that are not valid IDNs are only
+// lowercased.
+func NormalizeName(name string) string {
+	name = strings.TrimSuffix(strings.TrimSpace(name), ".")
+	return lookupKey(name)
+}
+
+// checkNormalized returns an error unless `name` is a valid, normalized
+// domain name.
+func checkNormalized(name string) error {
+	if issue := checkName(name); issue != nil {
+		return errors.New(issue.Message)
+	}
+	return nil
+}
+
+// find returns the index of the entry with the given name, or -1. The
+// names of the entries are ASCII, so comparing them with the lookup key of
+// `name` does not need any IDNA conversions.
+func (p *PreloadList) find(name string) int {
+	key := lookupKey(name)
+	for i, e := range p.Entries {
+		if lookupKey(e.Name) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add adds an entry to the list, after the last entry with the same
+// policy (or at the end of the list if there is no such entry). When the
+// list is written with a Document, this places the entry in the section of
+// the file for its policy.
+//
+// The name of the entry must be normalized, and must not already be on
+// the list.
+//
+// Each edit looks up the name and copies the entries, which takes O(n)
+// time. To make many edits, use Apply.
+func (p *PreloadList) Add(entry Entry) (Change, error) {
+	if err := checkNormalized(entry.Name); err != nil {
+		return Change{}, err
+	}
+	if p.find(entry.Name) != -1 {
+		return Change{}, fmt.Errorf("%s: %w", entry.Name, ErrDuplicateEntry)
+	}
+
+	pos := len(p.Entries)
+	for i, e := range p.Entries {
+		if e.Policy == entry.Policy {
+			pos = i + 1
+		}
+	}
+	// Edits build a new slice, so that they do not affect copies of the
+	// list that share its backing array.
+	entries := make([]Entry, 0, len(p.Entries)+1)
+	entries = append(entries, p.Entries[:pos]...)
+	entries = append(entries, entry)
+	p.Entries = append(entries, p.Entries[pos:]...)
+
+	return Change{Type: EntryAdded, Name: entry.Name, New: &entry}, nil
+}
+
+// Remove removes the entry with the given name from the list. Like Add, it
+// takes O(n) time.
+func (p *PreloadList) Remove(name string) (Change, error) {
+	if err := checkNormalized(name); err != nil {
+		return Change{}, err
+	}
+	i := p.find(name)
+	if i == -1 {
+		return Change{}, fmt.Errorf("%s: %w", name, ErrEntryNotFound)
+	}
+
+	old := p.Entries[i]
+	entries := make([]Entry, 0, len(p.Entries)-1)
+	entries = append(entries, p.Entries[:i]...)
+	p.Entries = append(entries, p.Entries[i+1:]...)
+
+	return Change{Type: EntryRemoved, Name: old.Name, Old: &old}, nil
+}
+
+// SetIncludeSubDomains sets the "include_subdomains" field of the entry
+// with the given name. Like Add, it takes O(n) time.
+func (p *PreloadList) SetIncludeSubDomains(name string, includeSubDomains bool) (Change, error) {
+	if err := checkNormalized(name); err != nil {
+		return Change{}, err
+	}
+	i := p.find(name)
+	if i == -1 {
+		return Change{}, fmt.Errorf("%s: %w", name, ErrEntryNotFound)
+	}
+
+	old := p.Entries[i]
+	if old.IncludeSubDomains == includeSubDomains {
+		return Change{}, fmt.Errorf("%s: %w", name, ErrNoChange)
+	}
+	updated := old
+	updated.IncludeSubDomains = includeSubDomains
+	p.Entries = append([]Entry(nil), p.Entries...)
+	p.Entries[i] = updated
+
+	return Change{Type: IncludeSubDomainsChanged, Name: old.Name, Old: &old, New: &updated}, nil
+}
+
+// Apply makes several edits at once, indexing the names and copying the
+// entries only once. Changes are applied in order: EntryAdded changes add
+// Change.New like Add, EntryRemoved changes remove the entry named
+// Change.Name like Remove, and other changes replace that entry with
+// Change.New. Added entries are placed after the other edits are made, so
+// they follow the last entry with the same policy in the edited list.
+//
+// Changes returned by Add, Remove, SetIncludeSubDomains and Diff can be
+// applied. If a change cannot be applied, Apply returns an error and the
+// list is not modified.
+func (p *PreloadList) Apply(changes []Change) error {
+	index := make(map[string]int, len(p.Entries))
+	for i := len(p.Entries) - 1; i >= 0; i-- {
+		index[lookupKey(p.Entries[i].Name)] = i
+	}
+
+	removed := make(map[int]bool)
+	updated := make(map[int]Entry)
+	var added []Entry
+	addedIndex := make(map[string]int)
+	dropped := make(map[int]bool)
+
+	for _, c := range changes {
+		name := c.Name
+		if c.Type == EntryAdded && c.New != nil {
+			name = c.New.Name
+		}
+		if err := checkNormalized(name); err != nil {
+			return err
+		}
+		key := lookupKey(name)
+		i, exists := index[key]
+		exists = exists && !removed[i]
+		a, isAdded := addedIndex[key]
+
+		switch {
+		case c.Type != EntryRemoved && (c.New == nil || lookupKey(c.New.Name) != key):
+			return fmt.Errorf("%s: invalid %s change", name, c.Type)
+		case c.Type == EntryAdded:
+			if exists || isAdded {
+				return fmt.Errorf("%s: %w", name, ErrDuplicateEntry)
+			}
+			addedIndex[key] = len(added)
+			added = append(added, *c.New)
+		case !exists && !isAdded:
+			return fmt.Errorf("%s: %w", name, ErrEntryNotFound)
+		case c.Type == EntryRemoved && isAdded:
+			dropped[a] = true
+			delete(addedIndex, key)
+		case c.Type == EntryRemoved:
+			removed[i] = true
+			delete(updated, i)
+		case isAdded:
+			added[a] = *c.New
+		default:
+			updated[i] = *c.New
+		}
+	}
+
+	// Added entries go after the last remaining entry with the same policy,
+	// or at the end of the list. Entries with the same policy are kept
+	// together, in the order in which they were added.
+	last := make(map[PolicyType]int)
+	for i, e := range p.Entries {
+		if u, ok := updated[i]; ok {
+			e = u
+		}
+		if !removed[i] {
+			last[e.Policy] = i
+		}
+	}
+	after := make(map[int][]Entry)
+	var endPolicies []PolicyType
+	atEnd := make(map[PolicyType][]Entry)
+	for a, e := range added {
+		if dropped[a] {
+			continue
+		}
+		if i, ok := last[e.Policy]; ok {
+			after[i] = append(after[i], e)
+			continue
+		}
+		if _, ok := atEnd[e.Policy]; !ok {
+			endPolicies = append(endPolicies, e.Policy)
+		}
+		atEnd[e.Policy] = append(atEnd[e.Policy], e)
+	}
+
+	// As with the other edits, build a new slice so that copies of the list
+	// are not affected.
+	entries := make([]Entry, 0, len(p.Entries)-len(removed)+len(added)-len(dropped))
+	for i, e := range p.Entries {
+		if removed[i] {
+			continue
+		}
+		if u, ok := updated[i]; ok {
+			e = u
+		}
+		entries = append(entries, e)
+		entries = append(entries, after[i]...)
+	}
+	for _, policy := range endPolicies {
+		entries = append(entries, atEnd[policy]...)
+	}
+	p.Entries = entries
+	return nil
+}
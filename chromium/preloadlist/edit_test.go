@@ -0,0 +1,234 @@
+package preloadlist
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEdit(t *testing.T) {
+	doc, err := ParseDocument(strings.NewReader(testChromiumJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := &doc.List
+
+	change, err := list.Add(Entry{Name: "c.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.String() != `added c.example (policy: "bulk-1-year", mode: "force-https", include_subdomains: true)` {
+		t.Errorf("Unexpected change description: %s", change)
+	}
+	if list.Entries[6].Name != "c.example" {
+		t.Errorf("Entry was not added after the last entry with the same policy: %#v", list.Entries)
+	}
+
+	change, err = list.SetIncludeSubDomains("older.example", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.String() != "older.example: include_subdomains changed from false to true" {
+		t.Errorf("Unexpected change description: %s", change)
+	}
+
+	change, err = list.Remove("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Type != EntryRemoved || change.Old.Policy != PublicSuffix || change.New != nil {
+		t.Errorf("Unexpected change: %#v", change)
+	}
+
+	expected := strings.Replace(testChromiumJSON,
+		`{ "name": "older.example", "policy": "bulk-legacy", "mode": "force-https" },`,
+		`{ "name": "older.example", "policy": "bulk-legacy", "mode": "force-https", "include_subdomains": true },`, 1)
+	expected = strings.Replace(expected,
+		`    { "name": "b.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+`,
+		`    { "name": "b.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+    { "name": "c.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true }
+`, 1)
+	expected = strings.Replace(expected,
+		`
+    { "name": "dev", "policy": "public-suffix", "mode": "force-https", "include_subdomains": true }`, ``, 1)
+
+	if output := writeToString(t, doc); output != expected {
+		t.Errorf("Output does not match expected.\n## Actual\n%s\n## Expected\n%s", output, expected)
+	}
+}
+
+func TestEditErrors(t *testing.T) {
+	doc, err := ParseDocument(strings.NewReader(testChromiumJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := &doc.List
+
+	if _, err := list.Add(Entry{Name: "A.example", Policy: Bulk1Year}); err == nil {
+		t.Errorf("Expected an error for a name that is not normalized.")
+	}
+	if _, err := list.Add(Entry{Name: "a..example", Policy: Bulk1Year}); err == nil {
+		t.Errorf("Expected an error for a name with an empty label.")
+	}
+	if _, err := list.Add(Entry{Name: "a_b.example", Policy: Bulk1Year}); err == nil {
+		t.Errorf("Expected an error for a name with invalid characters.")
+	}
+	if _, err := list.Add(Entry{Name: "a.example", Policy: Bulk1Year}); !errors.Is(err, ErrDuplicateEntry) {
+		t.Errorf("Expected ErrDuplicateEntry, got %v", err)
+	}
+	if _, err := list.Remove("missing.example"); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected ErrEntryNotFound, got %v", err)
+	}
+	if _, err := list.SetIncludeSubDomains("a.example", true); !errors.Is(err, ErrNoChange) {
+		t.Errorf("Expected ErrNoChange, got %v", err)
+	}
+
+	if output := writeToString(t, doc); output != testChromiumJSON {
+		t.Errorf("Failed edits should not change the list.")
+	}
+}
+
+func TestEditDoesNotAffectCopies(t *testing.T) {
+	list, err := Parse(strings.NewReader(testChromiumJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave spare capacity, so that an in-place edit would be visible.
+	list.Entries = append(make([]Entry, 0, len(list.Entries)+10), list.Entries...)
+	original := list
+	before := append([]Entry(nil), list.Entries...)
+
+	if _, err := list.Remove("google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list.Add(Entry{Name: "new.example", Policy: PublicSuffix, Mode: ForceHTTPS}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list.SetIncludeSubDomains("older.example", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(original.Entries, before) {
+		t.Errorf("Edits changed a copy of the list:\n%v\nexpected\n%v", original.Entries, before)
+	}
+}
+
+func TestFindNormalizesName(t *testing.T) {
+	list := PreloadList{Entries: []Entry{
+		{Name: "a.example"},
+		{Name: "xn--bcher-kva.example"},
+	}}
+
+	tests := []struct {
+		name     string
+		expected int
+	}{
+		{"a.example", 0},
+		{"A.Example", 0},
+		{"xn--bcher-kva.example", 1},
+		{"bücher.example", 1},
+		{"BÜCHER.example", 1},
+		{"b.example", -1},
+	}
+	for _, tt := range tests {
+		if i := list.find(tt.name); i != tt.expected {
+			t.Errorf("find(%q) = %d, expected %d", tt.name, i, tt.expected)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	base, err := Parse(strings.NewReader(testChromiumJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apply matches the same edits made one at a time.
+	sequential := base
+	var changes []Change
+	for _, edit := range []func(*PreloadList) (Change, error){
+		func(p *PreloadList) (Change, error) {
+			return p.Add(Entry{Name: "c.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true})
+		},
+		func(p *PreloadList) (Change, error) { return p.SetIncludeSubDomains("older.example", true) },
+		func(p *PreloadList) (Change, error) { return p.Remove("dev") },
+		func(p *PreloadList) (Change, error) {
+			return p.Add(Entry{Name: "new.test", Policy: Test, Mode: ForceHTTPS})
+		},
+		func(p *PreloadList) (Change, error) {
+			return p.Add(Entry{Name: "d.example", Policy: Bulk1Year, Mode: ForceHTTPS})
+		},
+		func(p *PreloadList) (Change, error) { return p.Add(Entry{Name: "new.example", Policy: Custom}) },
+	} {
+		change, err := edit(&sequential)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, change)
+	}
+
+	list := base
+	if err := list.Apply(changes); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Entries, sequential.Entries) {
+		t.Errorf("Apply() = %v, expected %v", list.Entries, sequential.Entries)
+	}
+
+	// Entries added and removed in the same batch are not added.
+	list = base
+	err = list.Apply([]Change{
+		{Type: EntryAdded, Name: "e.example", New: &Entry{Name: "e.example", Policy: Bulk1Year}},
+		{Type: EntryRemoved, Name: "e.example"},
+		{Type: EntryRemoved, Name: "a.example"},
+		{Type: EntryAdded, Name: "a.example", New: &Entry{Name: "a.example", Policy: Bulk18Weeks, Mode: ForceHTTPS}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != len(base.Entries) || list.Entries[len(list.Entries)-1].Policy != Bulk18Weeks {
+		t.Errorf("Unexpected entries: %v", list.Entries)
+	}
+
+	// Applying a diff produces the other list.
+	list = base
+	if err := list.Apply(Diff(base, sequential)); err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(list, sequential); len(changes) != 0 {
+		t.Errorf("Unexpected differences after applying a diff: %v", changes)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	base, err := Parse(strings.NewReader(testChromiumJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := Change{Type: EntryRemoved, Name: "dev"}
+
+	tests := []struct {
+		description string
+		change      Change
+		expected    error
+	}{
+		{"duplicate", Change{Type: EntryAdded, Name: "a.example", New: &Entry{Name: "a.example"}}, ErrDuplicateEntry},
+		{"missing", Change{Type: EntryRemoved, Name: "missing.example"}, ErrEntryNotFound},
+		{"removed twice", valid, ErrEntryNotFound},
+		{"not normalized", Change{Type: EntryRemoved, Name: "A.example"}, nil},
+		{"no new entry", Change{Type: IncludeSubDomainsChanged, Name: "a.example"}, nil},
+		{"other name", Change{Type: PolicyChanged, Name: "a.example", New: &Entry{Name: "b.example"}}, nil},
+	}
+	for _, tt := range tests {
+		list := base
+		err := list.Apply([]Change{valid, tt.change})
+		if err == nil || (tt.expected != nil && !errors.Is(err, tt.expected)) {
+			t.Errorf("[%s] Unexpected error: %v", tt.description, err)
+		}
+		if !reflect.DeepEqual(list.Entries, base.Entries) {
+			t.Errorf("[%s] A failed Apply should not change the list.", tt.description)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package preloadlist
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FetchSource indicates where Fetcher.Fetch got a list from.
+type FetchSource string
+
+// Possible FetchSource values.
+const (
+	// FetchedFromServer indicates that the list was downloaded.
+	FetchedFromServer FetchSource = "server"
+	// FetchedFromCache indicates that the cached copy was used, because it
+	// was younger than the max-age or the server reported that it had not
+	// changed.
+	FetchedFromCache FetchSource = "cache"
+	// FetchedFromStaleCache indicates that the server could not be reached
+	// (or returned an error), and the last good copy was used instead.
+	FetchedFromStaleCache FetchSource = "stale_cache"
+)
+
+// A Fetcher retrieves preload lists from URLs that return them in base 64
+// (like Chromium's gitiles server), optionally caching them on disk.
+//
+// The zero value downloads the list on every call, like
+// NewFromChromiumURL.
+type Fetcher struct {
+	// Client is used for requests. If nil, a client with a 10 second
+	// timeout is used.
+	Client *http.Client
+	// CacheDir is the directory for cached lists. If empty, lists are not
+	// cached.
+	CacheDir string
+	// MaxAge is how long a cached list is used without revalidating it
+	// with the server. Older copies are revalidated using the ETag and
+	// Last-Modified headers of the cached response.
+	MaxAge time.Duration
+
+	// now returns the current time. If nil, time.Now is used.
+	now func() time.Time
+}
+
+// cacheMetadata describes a cached response.
+type cacheMetadata struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// cachedList is a list read from the cache, along with its metadata.
+type cachedList struct {
+	meta cacheMetadata
+	body []byte
+	list PreloadList
+}
+
+func (f *Fetcher) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (f *Fetcher) currentTime() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+// cachePath returns the path prefix of the cache files for `u`.
+func (f *Fetcher) cachePath(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(f.CacheDir, hex.EncodeToString(sum[:8]))
+}
+
+// parseBase64 parses a base 64 encoded list.
+func parseBase64(body []byte) (PreloadList, error) {
+	return Parse(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(body)))
+}
+
+// readCache returns the cached list for `u`, or nil if there is no usable
+// copy.
+func (f *Fetcher) readCache(u string) *cachedList {
+	if f.CacheDir == "" {
+		return nil
+	}
+	path := f.cachePath(u)
+
+	var c cachedList
+	metaBytes, err := os.ReadFile(path + ".json")
+	if err != nil || json.Unmarshal(metaBytes, &c.meta) != nil || c.meta.URL != u {
+		return nil
+	}
+	if c.body, err = os.ReadFile(path + ".body"); err != nil {
+		return nil
+	}
+	if c.list, err = parseBase64(c.body); err != nil {
+		return nil
+	}
+	return &c
+}
+
+// writeFileAtomic writes a file by renaming a temporary file, so that
+// readers never see a partial file.
+func writeFileAtomic(fileName string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
+
+// writeCache stores a response in the cache. The body is written before
+// the metadata, which refers to it.
+func (f *Fetcher) writeCache(meta cacheMetadata, body []byte) error {
+	if err := os.MkdirAll(f.CacheDir, 0o755); err != nil {
+		return err
+	}
+	path := f.cachePath(meta.URL)
+	if body != nil {
+		if err := writeFileAtomic(path+".body", body); err != nil {
+			return err
+		}
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path+".json", metaBytes)
+}
+
+// Fetch retrieves the list at `u`, using the cache if possible.
+//
+// If the server cannot be reached, or returns an error, the last good
+// cached copy is returned (however old it is) with the
+// FetchedFromStaleCache source. An error is only returned if there is no
+// cached copy.
+func (f *Fetcher) Fetch(u string) (PreloadList, FetchSource, error) {
+	cached := f.readCache(u)
+	now := f.currentTime()
+	if cached != nil && now.Sub(cached.meta.Fetched) < f.MaxAge {
+		return cached.list, FetchedFromCache, nil
+	}
+
+	list, source, err := f.download(u, cached, now)
+	if err != nil && cached != nil {
+		return cached.list, FetchedFromStaleCache, nil
+	}
+	return list, source, err
+}
+
+// download requests the list from the server, revalidating the cached
+// copy if there is one.
+func (f *Fetcher) download(u string, cached *cachedList, now time.Time) (PreloadList, FetchSource, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return PreloadList{}, "", err
+	}
+	if cached != nil {
+		if cached.meta.ETag != "" {
+			req.Header.Set("If-None-Match", cached.meta.ETag)
+		}
+		if cached.meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.meta.LastModified)
+		}
+	}
+
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return PreloadList{}, "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		cached.meta.Fetched = now
+		// Failing to update the cache only means that the next call
+		// revalidates again.
+		_ = f.writeCache(cached.meta, nil)
+		return cached.list, FetchedFromCache, nil
+	case resp.StatusCode != http.StatusOK:
+		return PreloadList{}, "", fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return PreloadList{}, "", err
+	}
+	list, err := parseBase64(body)
+	if err != nil {
+		return PreloadList{}, "", err
+	}
+
+	if f.CacheDir != "" {
+		meta := cacheMetadata{
+			URL:          u,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Fetched:      now,
+		}
+		// The downloaded list is usable even if it cannot be cached.
+		_ = f.writeCache(meta, body)
+	}
+	return list, FetchedFromServer, nil
+}
@@ -0,0 +1,133 @@
+package preloadlist
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGitiles serves a preload list in base 64, like gitiles, and supports
+// conditional requests.
+type fakeGitiles struct {
+	mu           sync.Mutex
+	body         string
+	etag         string
+	lastModified time.Time
+	down         bool
+	requests     int
+	conditional  int
+}
+
+func (g *fakeGitiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.requests++
+	if g.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		g.conditional++
+	}
+
+	w.Header().Set("ETag", g.etag)
+	http.ServeContent(w, r, "", g.lastModified, strings.NewReader(base64.StdEncoding.EncodeToString([]byte(g.body))))
+}
+
+func (g *fakeGitiles) counts() (requests int, conditional int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.requests, g.conditional
+}
+
+func (g *fakeGitiles) set(f func(g *fakeGitiles)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f(g)
+}
+
+func TestFetcher(t *testing.T) {
+	gitiles := &fakeGitiles{
+		body:         testJSON,
+		etag:         `"v1"`,
+		lastModified: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	server := httptest.NewServer(gitiles)
+	defer server.Close()
+
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	f := &Fetcher{
+		Client:   server.Client(),
+		CacheDir: t.TempDir(),
+		MaxAge:   time.Hour,
+		now:      func() time.Time { return now },
+	}
+
+	fetch := func(expectedSource FetchSource) PreloadList {
+		t.Helper()
+		list, source, err := f.Fetch(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if source != expectedSource {
+			t.Errorf("Unexpected source %q, expected %q", source, expectedSource)
+		}
+		return list
+	}
+
+	list := fetch(FetchedFromServer)
+	if !reflect.DeepEqual(list.Entries, testParsed.Entries) {
+		t.Errorf("Unexpected entries: %#v", list.Entries)
+	}
+
+	// Within the max-age, the server is not contacted.
+	now = now.Add(time.Minute)
+	fetch(FetchedFromCache)
+	if requests, _ := gitiles.counts(); requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+
+	// After the max-age, the cached copy is revalidated.
+	now = now.Add(2 * time.Hour)
+	fetch(FetchedFromCache)
+	if requests, conditional := gitiles.counts(); requests != 2 || conditional != 1 {
+		t.Errorf("Expected a conditional request, got %d requests (%d conditional)", requests, conditional)
+	}
+
+	// Revalidation resets the age of the cached copy.
+	now = now.Add(time.Minute)
+	fetch(FetchedFromCache)
+	if requests, _ := gitiles.counts(); requests != 2 {
+		t.Errorf("Expected no new request, got %d requests", requests)
+	}
+
+	// A changed list is downloaded again.
+	gitiles.set(func(g *fakeGitiles) {
+		g.body = `{"entries": []}`
+		g.etag = `"v2"`
+		g.lastModified = g.lastModified.Add(24 * time.Hour)
+	})
+	now = now.Add(2 * time.Hour)
+	if list := fetch(FetchedFromServer); len(list.Entries) != 0 {
+		t.Errorf("Expected the updated list, got %#v", list.Entries)
+	}
+
+	// If the server is down, the last good copy is used.
+	gitiles.set(func(g *fakeGitiles) { g.down = true })
+	now = now.Add(2 * time.Hour)
+	if list := fetch(FetchedFromStaleCache); len(list.Entries) != 0 {
+		t.Errorf("Expected the cached list, got %#v", list.Entries)
+	}
+
+	// Without a cached copy, errors are returned.
+	uncached := &Fetcher{Client: server.Client()}
+	if _, _, err := uncached.Fetch(server.URL); err == nil {
+		t.Errorf("Expected an error from an unavailable server")
+	}
+}
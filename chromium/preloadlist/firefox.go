@@ -0,0 +1,194 @@
+package preloadlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// firefoxHeader is the start of Firefox's nsSTSPreloadList.inc, up to the
+// expiration time.
+const firefoxHeader = `/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+/*****************************************************************************/
+/* This is an automatically generated file. If you're not                    */
+/* nsSiteSecurityService.cpp, you shouldn't be #including it.                */
+/*****************************************************************************/
+
+#include <stdint.h>
+`
+
+// firefoxSeparator delimits the entries of nsSTSPreloadList.inc.
+const firefoxSeparator = "%%"
+
+// ParseFirefox reads Firefox's HSTS preload list in the format of
+// nsSTSPreloadList.inc, which contains lines of the form
+// "example.com, 1" (where 1 means that subdomains are included) between two
+// "%%" lines.
+//
+// Every entry has the ForceHTTPS mode, and no policy.
+func ParseFirefox(r io.Reader) (PreloadList, error) {
+	var list PreloadList
+
+	sc := bufio.NewScanner(r)
+	line := 0
+	inEntries, done := false, false
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == firefoxSeparator {
+			if inEntries {
+				done = true
+				break
+			}
+			inEntries = true
+			continue
+		}
+		if !inEntries || text == "" {
+			continue
+		}
+
+		fields := strings.Split(text, ",")
+		if len(fields) != 2 {
+			return list, &ParseError{Line: line, Err: fmt.Errorf("expected \"name, 0|1\", got %q", text)}
+		}
+		e := Entry{Name: strings.TrimSpace(fields[0]), Mode: ForceHTTPS}
+		switch strings.TrimSpace(fields[1]) {
+		case "0":
+		case "1":
+			e.IncludeSubDomains = true
+		default:
+			return list, &ParseError{Line: line, Err: fmt.Errorf("invalid includeSubdomains value %q", fields[1])}
+		}
+		list.Entries = append(list.Entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return list, err
+	}
+	if !done {
+		return list, fmt.Errorf("missing %q separator", firefoxSeparator)
+	}
+	return list, nil
+}
+
+// NewFromFirefoxFile reads Firefox's HSTS preload list from a
+// nsSTSPreloadList.inc file.
+func NewFromFirefoxFile(fileName string) (PreloadList, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return PreloadList{}, err
+	}
+	defer f.Close()
+	return ParseFirefox(f)
+}
+
+// WriteFirefox writes the HSTS entries of the list (those with the
+// ForceHTTPS mode) in the format of Firefox's nsSTSPreloadList.inc, sorted
+// by name. `expiration` is the time after which Firefox stops using the
+// list.
+func (p PreloadList) WriteFirefox(w io.Writer, expiration time.Time) error {
+	var names []string
+	includeSubDomains := make(map[string]bool)
+	for _, e := range p.Entries {
+		if e.Mode != ForceHTTPS {
+			continue
+		}
+		name := lookupKey(e.Name)
+		if _, ok := includeSubDomains[name]; !ok {
+			names = append(names, name)
+		}
+		includeSubDomains[name] = e.IncludeSubDomains
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%sconst PRTime gPreloadListExpirationTime = INT64_C(%d);\n\n%s\n",
+		firefoxHeader, expiration.UnixNano()/int64(time.Microsecond), firefoxSeparator)
+	for _, name := range names {
+		value := 0
+		if includeSubDomains[name] {
+			value = 1
+		}
+		fmt.Fprintf(bw, "%s, %d\n", name, value)
+	}
+	fmt.Fprintf(bw, "%s\n", firefoxSeparator)
+	return bw.Flush()
+}
+
+// An IncludeSubDomainsMismatch describes a domain that is on both
+// browsers' lists, with different "include_subdomains" values.
+type IncludeSubDomainsMismatch struct {
+	Name     string `json:"name"`
+	Chromium Entry  `json:"chromium"`
+	Firefox  Entry  `json:"firefox"`
+}
+
+func (m IncludeSubDomainsMismatch) String() string {
+	return fmt.Sprintf("%s: include_subdomains is %t for Chromium, %t for Firefox",
+		m.Name, m.Chromium.IncludeSubDomains, m.Firefox.IncludeSubDomains)
+}
+
+// A BrowserComparison lists the differences between the HSTS entries of
+// the Chromium and Firefox preload lists.
+type BrowserComparison struct {
+	OnlyChromium              []Entry                     `json:"only_chromium"`
+	OnlyFirefox               []Entry                     `json:"only_firefox"`
+	IncludeSubDomainsMismatch []IncludeSubDomainsMismatch `json:"include_subdomains_mismatch"`
+}
+
+// CompareWithFirefox compares the HSTS entries (those with the ForceHTTPS
+// mode) of a Chromium preload list with a Firefox preload list, matching
+// entries by name. The results are in the order of the respective lists.
+func CompareWithFirefox(chromium, firefox PreloadList) BrowserComparison {
+	hsts := func(list PreloadList) PreloadList {
+		var filtered PreloadList
+		for _, e := range list.Entries {
+			if e.Mode == ForceHTTPS {
+				filtered.Entries = append(filtered.Entries, e)
+			}
+		}
+		return filtered
+	}
+	chromium, firefox = hsts(chromium), hsts(firefox)
+	chromiumIdx, firefoxIdx := chromium.Index(), firefox.Index()
+
+	// Empty differences are encoded as [] rather than null in JSON.
+	c := BrowserComparison{
+		OnlyChromium:              []Entry{},
+		OnlyFirefox:               []Entry{},
+		IncludeSubDomainsMismatch: []IncludeSubDomainsMismatch{},
+	}
+	seen := make(map[string]bool)
+	for _, e := range chromium.Entries {
+		name := lookupKey(e.Name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		e, _ = chromiumIdx.lookup(name)
+
+		f, ok := firefoxIdx.lookup(name)
+		switch {
+		case !ok:
+			c.OnlyChromium = append(c.OnlyChromium, e)
+		case e.IncludeSubDomains != f.IncludeSubDomains:
+			c.IncludeSubDomainsMismatch = append(c.IncludeSubDomainsMismatch, IncludeSubDomainsMismatch{Name: e.Name, Chromium: e, Firefox: f})
+		}
+	}
+	for _, f := range firefox.Entries {
+		name := lookupKey(f.Name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		f, _ = firefoxIdx.lookup(name)
+		c.OnlyFirefox = append(c.OnlyFirefox, f)
+	}
+	return c
+}
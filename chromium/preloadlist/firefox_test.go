@@ -0,0 +1,106 @@
+package preloadlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFirefoxInc = `/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+/*****************************************************************************/
+/* This is an automatically generated file. If you're not                    */
+/* nsSiteSecurityService.cpp, you shouldn't be #including it.                */
+/*****************************************************************************/
+
+#include <stdint.h>
+const PRTime gPreloadListExpirationTime = INT64_C(1577836800000000);
+
+%%
+example.com, 1
+example.org, 0
+xn--bcher-kva.example, 1
+%%
+`
+
+func TestParseFirefox(t *testing.T) {
+	list, err := ParseFirefox(strings.NewReader(testFirefoxInc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Entry{
+		{Name: "example.com", Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "example.org", Mode: ForceHTTPS},
+		{Name: "xn--bcher-kva.example", Mode: ForceHTTPS, IncludeSubDomains: true},
+	}
+	if !reflect.DeepEqual(list.Entries, expected) {
+		t.Errorf("Unexpected entries: %#v", list.Entries)
+	}
+
+	var parseErr *ParseError
+	_, err = ParseFirefox(strings.NewReader("%%\nexample.com, 2\n%%\n"))
+	if !errors.As(err, &parseErr) || parseErr.Line != 2 {
+		t.Errorf("Expected a parse error on line 2, got %v", err)
+	}
+	if _, err := ParseFirefox(strings.NewReader("%%\nexample.com, 1\n")); err == nil {
+		t.Errorf("Expected an error for a missing separator")
+	}
+}
+
+func TestWriteFirefox(t *testing.T) {
+	list := PreloadList{Entries: []Entry{
+		{Name: "xn--bcher-kva.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "example.org", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "pinned.example", Policy: Custom, Pins: "test"},
+		{Name: "example.com", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+	}}
+
+	var buf bytes.Buffer
+	if err := list.WriteFirefox(&buf, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != testFirefoxInc {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestCompareWithFirefox(t *testing.T) {
+	chromium := PreloadList{Entries: []Entry{
+		{Name: "both.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "chromium.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "mismatch.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "pinned.example", Policy: Custom, Pins: "test"},
+	}}
+	firefox := PreloadList{Entries: []Entry{
+		{Name: "both.example", Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "firefox.example", Mode: ForceHTTPS},
+		{Name: "mismatch.example", Mode: ForceHTTPS},
+	}}
+
+	c := CompareWithFirefox(chromium, firefox)
+	if names := entryNames(c.OnlyChromium); !reflect.DeepEqual(names, []string{"chromium.example"}) {
+		t.Errorf("Unexpected entries only in Chromium: %v", names)
+	}
+	if names := entryNames(c.OnlyFirefox); !reflect.DeepEqual(names, []string{"firefox.example"}) {
+		t.Errorf("Unexpected entries only in Firefox: %v", names)
+	}
+	if len(c.IncludeSubDomainsMismatch) != 1 ||
+		c.IncludeSubDomainsMismatch[0].String() != "mismatch.example: include_subdomains is true for Chromium, false for Firefox" {
+		t.Errorf("Unexpected mismatches: %v", c.IncludeSubDomainsMismatch)
+	}
+
+	b, err := json.Marshal(CompareWithFirefox(chromium, chromium))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"only_chromium":[],"only_firefox":[],"include_subdomains_mismatch":[]}`; string(b) != expected {
+		t.Errorf("JSON for identical lists = %s, expected %s", b, expected)
+	}
+}
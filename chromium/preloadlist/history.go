@@ -0,0 +1,437 @@
+package preloadlist
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChromiumListPath is the path of the preload list in a Chromium checkout.
+const ChromiumListPath = "net/http/transport_security_state_static.json"
+
+// A HistoryCommit is a commit that changed the preload list.
+type HistoryCommit struct {
+	Hash    string    `json:"hash"`
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+}
+
+// A HistoryEvent is a change to an entry, made by a commit.
+type HistoryEvent struct {
+	Commit HistoryCommit `json:"commit"`
+	Change Change        `json:"change"`
+}
+
+// A History gives access to past versions of the preload list in a local
+// git repository, such as a Chromium checkout or a mirror of it.
+//
+// History uses the git command, which must be installed.
+type History struct {
+	// CacheDir is the directory in which the index of the changes to all
+	// entries is kept (see BuildIndex). If empty, the index is only kept
+	// in memory.
+	CacheDir string
+
+	repo    string
+	path    string
+	commits []HistoryCommit
+
+	mu    sync.Mutex
+	index *historyIndex
+	// skipped holds the indices of the commits whose version of the list
+	// could not be parsed, if there is no index.
+	skipped map[int]bool
+}
+
+// historyIndex records the changes to each entry, up to a commit. It is
+// persisted as gzipped JSON.
+type historyIndex struct {
+	// Commits is the number of commits (of History.commits) that were
+	// read, and Head is the hash of the last one.
+	Commits int    `json:"commits"`
+	Head    string `json:"head"`
+	// Parsed is the index of the last commit whose version of the list was
+	// parsed, or -1.
+	Parsed  int                     `json:"parsed"`
+	Events  map[string][]indexEvent `json:"events"`
+	Skipped []int                   `json:"skipped"`
+}
+
+// indexEvent is a HistoryEvent, with the index of its commit.
+type indexEvent struct {
+	Commit int    `json:"commit"`
+	Change Change `json:"change"`
+}
+
+// git runs a git command in the repository and returns its output.
+func (h *History) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", h.repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// NewHistory reads the commits that changed the preload list at `path` in
+// the git repository at `repo`, following the first parent of each merge.
+// If `path` is empty, ChromiumListPath is used.
+func NewHistory(repo string, path string) (*History, error) {
+	if path == "" {
+		path = ChromiumListPath
+	}
+	h := &History{repo: repo, path: path, skipped: make(map[int]bool)}
+
+	out, err := h.git("log", "--first-parent", "--reverse", "--format=%H%x00%ct%x00%s", "HEAD", "--", path)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), "\x00", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git log output %q", sc.Text())
+		}
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected commit time %q", fields[1])
+		}
+		h.commits = append(h.commits, HistoryCommit{
+			Hash:    fields[0],
+			Time:    time.Unix(seconds, 0).UTC(),
+			Subject: fields[2],
+		})
+	}
+	if len(h.commits) == 0 {
+		return nil, fmt.Errorf("no commits change %s", path)
+	}
+	return h, nil
+}
+
+// Commits returns the commits that changed the list, oldest first.
+func (h *History) Commits() []HistoryCommit {
+	return append([]HistoryCommit(nil), h.commits...)
+}
+
+// ListAt returns the list as of a git revision (e.g. a commit hash, tag
+// or branch name).
+func (h *History) ListAt(rev string) (PreloadList, error) {
+	// Resolve the revision on its own, so that it cannot be taken as an
+	// option of git show.
+	if strings.HasPrefix(rev, "-") {
+		return PreloadList{}, fmt.Errorf("invalid revision %q", rev)
+	}
+	out, err := h.git("rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return PreloadList{}, fmt.Errorf("%s: unknown revision", rev)
+	}
+	hash := strings.TrimSpace(string(out))
+
+	out, err = h.git("show", hash+":"+h.path)
+	if err != nil {
+		return PreloadList{}, err
+	}
+	list, err := Parse(bytes.NewReader(out))
+	if err != nil {
+		return PreloadList{}, fmt.Errorf("%s: %w", rev, err)
+	}
+	return list, nil
+}
+
+// ListAsOf returns the list as of a point in time, along with the last
+// commit that changed it before then.
+func (h *History) ListAsOf(t time.Time) (PreloadList, HistoryCommit, error) {
+	i := sort.Search(len(h.commits), func(i int) bool {
+		return h.commits[i].Time.After(t)
+	})
+	if i == 0 {
+		return PreloadList{}, HistoryCommit{}, fmt.Errorf("the list did not exist on %s", t.Format(time.RFC3339))
+	}
+	commit := h.commits[i-1]
+	list, err := h.ListAt(commit.Hash)
+	return list, commit, err
+}
+
+// version returns the list as of the commit with index `i`, which is empty
+// if the commit deleted the file. It returns false if the list could not be
+// parsed.
+func (h *History) version(i int) (PreloadList, bool) {
+	out, err := h.git("show", h.commits[i].Hash+":"+h.path)
+	if err != nil {
+		return PreloadList{}, true
+	}
+	list, err := Parse(bytes.NewReader(out))
+	return list, err == nil
+}
+
+// update reads the commits after the ones already in the index, comparing
+// consecutive versions of the list and recording the changes to each entry.
+// Versions that cannot be parsed are skipped, so their changes are
+// attributed to the next version that can be parsed. A commit that deletes
+// the file removes every entry.
+func (h *History) update(idx *historyIndex) {
+	var previous PreloadList
+	if idx.Parsed >= 0 {
+		previous, _ = h.version(idx.Parsed)
+	}
+	for i := idx.Commits; i < len(h.commits); i++ {
+		list, ok := h.version(i)
+		if !ok {
+			idx.Skipped = append(idx.Skipped, i)
+			continue
+		}
+		for _, change := range Diff(previous, list) {
+			name := lookupKey(change.Name)
+			idx.Events[name] = append(idx.Events[name], indexEvent{Commit: i, Change: change})
+		}
+		previous = list
+		idx.Parsed = i
+	}
+	idx.Commits = len(h.commits)
+	idx.Head = h.commits[len(h.commits)-1].Hash
+}
+
+// indexPath returns the path of the persisted index.
+func (h *History) indexPath() string {
+	repo, err := filepath.Abs(h.repo)
+	if err != nil {
+		repo = h.repo
+	}
+	sum := sha256.Sum256([]byte(repo + "\x00" + h.path))
+	return filepath.Join(h.CacheDir, "history-"+hex.EncodeToString(sum[:8])+".json.gz")
+}
+
+// loadIndex reads the persisted index, or returns nil if there is none for
+// the current history (e.g. because it was rewritten).
+func (h *History) loadIndex() *historyIndex {
+	if h.CacheDir == "" {
+		return nil
+	}
+	f, err := os.Open(h.indexPath())
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil
+	}
+	var idx historyIndex
+	if err := json.NewDecoder(zr).Decode(&idx); err != nil {
+		return nil
+	}
+	if idx.Commits < 1 || idx.Commits > len(h.commits) || h.commits[idx.Commits-1].Hash != idx.Head ||
+		idx.Parsed >= idx.Commits || idx.Events == nil {
+		return nil
+	}
+	return &idx
+}
+
+// saveIndex persists the index, if there is a cache directory.
+func (h *History) saveIndex(idx *historyIndex) error {
+	if h.CacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(h.CacheDir, 0o755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(idx); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return writeFileAtomic(h.indexPath(), buf.Bytes())
+}
+
+// useIndex loads the persisted index, and brings it up to date with the
+// history, if it was not loaded yet.
+func (h *History) useIndex() error {
+	if h.index != nil {
+		return nil
+	}
+	idx := h.loadIndex()
+	if idx == nil {
+		return nil
+	}
+	if idx.Commits < len(h.commits) {
+		h.update(idx)
+		if err := h.saveIndex(idx); err != nil {
+			return err
+		}
+	}
+	h.index = idx
+	return nil
+}
+
+// BuildIndex reads every version of the list to index the changes to all
+// entries, which can take a while for a full Chromium checkout. If
+// h.CacheDir is set, the index is kept there for later Histories of the
+// same repository, which only read the versions that were added since.
+func (h *History) BuildIndex() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.useIndex(); err != nil {
+		return err
+	}
+	if h.index == nil {
+		idx := &historyIndex{Parsed: -1, Events: make(map[string][]indexEvent)}
+		h.update(idx)
+		h.index = idx
+	}
+	return h.saveIndex(h.index)
+}
+
+// gitPattern returns a regular expression that matches `s` for git, using
+// bracket expressions for special characters (which are the same in basic
+// and extended regular expressions).
+func gitPattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c > 127:
+			b.WriteRune(c)
+		case c == '^':
+			b.WriteString(`[\^]`)
+		case c == ']':
+			b.WriteString("[]]")
+		default:
+			b.WriteString("[" + string(c) + "]")
+		}
+	}
+	return b.String()
+}
+
+// narrowTimeline returns the timeline of the entry with key `name` without
+// an index. Only the versions of the list around the commits whose diff
+// mentions the name are read.
+func (h *History) narrowTimeline(name string) ([]HistoryEvent, error) {
+	out, err := h.git("log", "--first-parent", "--format=%H", "-G", gitPattern(`"`+name+`"`), "HEAD", "--", h.path)
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[string]int)
+	for i, c := range h.commits {
+		positions[c.Hash] = i
+	}
+	var candidates []int
+	for _, hash := range strings.Fields(string(out)) {
+		i, ok := positions[hash]
+		if !ok {
+			return nil, fmt.Errorf("unexpected commit %s", hash)
+		}
+		candidates = append(candidates, i)
+	}
+	sort.Ints(candidates)
+
+	type version struct {
+		list PreloadList
+		ok   bool
+	}
+	versions := make(map[int]version)
+	versionAt := func(i int) version {
+		v, found := versions[i]
+		if !found {
+			v.list, v.ok = h.version(i)
+			versions[i] = v
+			if !v.ok {
+				h.skipped[i] = true
+			}
+		}
+		return v
+	}
+
+	var events []HistoryEvent
+	done := -1
+	for _, i := range candidates {
+		// The changes of a version that cannot be parsed are attributed to
+		// the next one that can.
+		j := i
+		for j < len(h.commits) && !versionAt(j).ok {
+			j++
+		}
+		if j == len(h.commits) {
+			break
+		}
+		if j <= done {
+			continue
+		}
+		done = j
+
+		var previous PreloadList
+		for p := i - 1; p >= 0; p-- {
+			if v := versionAt(p); v.ok {
+				previous = v.list
+				break
+			}
+		}
+		for _, change := range Diff(previous, versionAt(j).list) {
+			if lookupKey(change.Name) == name {
+				events = append(events, HistoryEvent{Commit: h.commits[j], Change: change})
+			}
+		}
+	}
+	return events, nil
+}
+
+// Timeline returns the changes to the entry for `domain`, oldest first.
+//
+// If an index was built (see BuildIndex), it is used. Otherwise, the
+// versions of the list that may have changed the entry are read.
+func (h *History) Timeline(domain string) ([]HistoryEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.useIndex(); err != nil {
+		return nil, err
+	}
+	name := lookupKey(domain)
+	if h.index == nil {
+		return h.narrowTimeline(name)
+	}
+	var events []HistoryEvent
+	for _, e := range h.index.Events[name] {
+		events = append(events, HistoryEvent{Commit: h.commits[e.Commit], Change: e.Change})
+	}
+	return events, nil
+}
+
+// Skipped returns the commits whose version of the list could not be
+// parsed, and whose changes were attributed to the next version that could.
+// Without an index, only the versions read by Timeline are included.
+func (h *History) Skipped() []HistoryCommit {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var indices []int
+	if h.index != nil {
+		indices = h.index.Skipped
+	} else {
+		for i := range h.skipped {
+			indices = append(indices, i)
+		}
+		sort.Ints(indices)
+	}
+	var skipped []HistoryCommit
+	for _, i := range indices {
+		skipped = append(skipped, h.commits[i])
+	}
+	return skipped
+}
@@ -0,0 +1,248 @@
+package preloadlist
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testHistoryRepo is a git repository with one commit for each version of
+// the list, one day apart from 2020-01-01.
+type testHistoryRepo struct {
+	t       *testing.T
+	dir     string
+	commits int
+}
+
+func (r *testHistoryRepo) git(date time.Time, args ...string) {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_AUTHOR_DATE="+date.Format(time.RFC3339), "GIT_COMMITTER_DATE="+date.Format(time.RFC3339),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		r.t.Fatalf("git %v: %s\n%s", args, err, out)
+	}
+}
+
+// commit commits the next version of the list.
+func (r *testHistoryRepo) commit(version string) {
+	r.t.Helper()
+	date := time.Date(2020, 1, 1+r.commits, 12, 0, 0, 0, time.UTC)
+	listPath := filepath.Join(r.dir, filepath.FromSlash(ChromiumListPath))
+	if err := os.MkdirAll(filepath.Dir(listPath), 0o755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.WriteFile(listPath, []byte(version), 0o644); err != nil {
+		r.t.Fatal(err)
+	}
+	r.commits++
+	r.git(date, "add", "-A")
+	r.git(date, "commit", "-q", "-m", fmt.Sprintf("Version %d", r.commits))
+}
+
+func newTestHistoryRepo(t *testing.T, versions ...string) *testHistoryRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	r := &testHistoryRepo{t: t, dir: t.TempDir()}
+	r.git(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), "init", "-q")
+	for _, version := range versions {
+		r.commit(version)
+	}
+	return r
+}
+
+// timeline returns the timeline of `domain` as strings.
+func timeline(t *testing.T, h *History, domain string) []string {
+	t.Helper()
+	events, err := h.Timeline(domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, e := range events {
+		lines = append(lines, e.Commit.Subject+": "+e.Change.String())
+	}
+	return lines
+}
+
+var testHistoryVersions = []string{
+	`{"entries": [
+  { "name": "example.com", "policy": "bulk-18-weeks", "mode": "force-https", "include_subdomains": true }
+]}`,
+	`{"entries": [
+  { "name": "example.com", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+  { "name": "example.org", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true }
+]}`,
+	`not json`,
+	`{"entries": [
+  { "name": "example.org", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true }
+]}`,
+}
+
+var testHistoryTimeline = []string{
+	`Version 1: added example.com (policy: "bulk-18-weeks", mode: "force-https", include_subdomains: true)`,
+	`Version 2: example.com: policy changed from "bulk-18-weeks" to "bulk-1-year"`,
+	`Version 4: removed example.com (policy: "bulk-1-year", mode: "force-https", include_subdomains: true)`,
+}
+
+func TestHistory(t *testing.T) {
+	repo := newTestHistoryRepo(t, testHistoryVersions...)
+
+	h, err := NewHistory(repo.dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits := h.Commits()
+	if len(commits) != 4 || commits[0].Subject != "Version 1" || !commits[3].Time.Equal(time.Date(2020, 1, 4, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected commits: %#v", commits)
+	}
+
+	list, commit, err := h.ListAsOf(time.Date(2020, 1, 2, 18, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Hash != commits[1].Hash || len(list.Entries) != 2 {
+		t.Errorf("Unexpected list as of 2020-01-02: %s %#v", commit.Subject, list.Entries)
+	}
+	if _, _, err := h.ListAsOf(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("Expected an error before the first commit")
+	}
+
+	list, err = h.ListAt(commits[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 1 || list.Entries[0].Policy != Bulk18Weeks {
+		t.Errorf("Unexpected first list: %#v", list.Entries)
+	}
+	if list, err = h.ListAt("HEAD~3"); err != nil || len(list.Entries) != 1 {
+		t.Errorf("Unexpected list at HEAD~3: %#v (%v)", list.Entries, err)
+	}
+
+	// Revisions cannot be used to pass options to git.
+	output := filepath.Join(t.TempDir(), "output")
+	for _, rev := range []string{"--output=" + output, "-p", "missing", commits[0].Hash + ":" + ChromiumListPath} {
+		if _, err := h.ListAt(rev); err == nil {
+			t.Errorf("Expected an error for revision %q", rev)
+		}
+	}
+	if _, err := os.Stat(output); err == nil {
+		t.Errorf("git wrote to %s", output)
+	}
+
+	// Without an index, only the versions around the commits that mention
+	// the name are read.
+	if tl := timeline(t, h, "EXAMPLE.com"); !reflect.DeepEqual(tl, testHistoryTimeline) {
+		t.Errorf("Unexpected timeline: %#v", tl)
+	}
+	if events := timeline(t, h, "example.net"); len(events) != 0 {
+		t.Errorf("Expected no events, got %#v", events)
+	}
+	if skipped := h.Skipped(); len(skipped) != 1 || skipped[0].Subject != "Version 3" {
+		t.Errorf("Unexpected skipped commits: %#v", skipped)
+	}
+
+	if err := h.BuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if tl := timeline(t, h, "EXAMPLE.com"); !reflect.DeepEqual(tl, testHistoryTimeline) {
+		t.Errorf("Unexpected timeline with an index: %#v", tl)
+	}
+	if skipped := h.Skipped(); len(skipped) != 1 || skipped[0].Subject != "Version 3" {
+		t.Errorf("Unexpected skipped commits: %#v", skipped)
+	}
+	if _, err := NewHistory(repo.dir, "missing.json"); err == nil {
+		t.Errorf("Expected an error for a file without history")
+	}
+}
+
+func TestHistoryNarrowTimeline(t *testing.T) {
+	repo := newTestHistoryRepo(t,
+		`{"entries": [
+  { "name": "a.example", "policy": "custom", "mode": "force-https" }
+]}`,
+		// A change to the entry's mode, without adding or removing the name.
+		`{"entries": [
+  { "name": "a.example", "policy": "custom", "mode": "" },
+  { "name": "b.example", "policy": "custom", "mode": "force-https" }
+]}`,
+		// The list cannot be parsed when the name is added back, so the
+		// change is attributed to the next version.
+		`{"entries": [
+  { "name": "A.example", "policy": "custom", "mode": "force-https" },`,
+		`{"entries": [
+  { "name": "a.example", "policy": "custom", "mode": "force-https" }
+]}`,
+	)
+	h, err := NewHistory(repo.dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`Version 1: added a.example (policy: "custom", mode: "force-https", include_subdomains: false)`,
+		`Version 2: a.example: mode changed from "force-https" to ""`,
+		`Version 4: a.example: mode changed from "" to "force-https"`,
+	}
+	if tl := timeline(t, h, "a.example"); !reflect.DeepEqual(tl, expected) {
+		t.Errorf("Unexpected timeline: %#v", tl)
+	}
+	if tl := timeline(t, h, "b.example"); len(tl) != 2 {
+		t.Errorf("Unexpected timeline: %#v", tl)
+	}
+}
+
+func TestHistoryPersistedIndex(t *testing.T) {
+	repo := newTestHistoryRepo(t, testHistoryVersions[:2]...)
+	cacheDir := t.TempDir()
+
+	newHistory := func() *History {
+		t.Helper()
+		h, err := NewHistory(repo.dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.CacheDir = cacheDir
+		return h
+	}
+	if err := newHistory().BuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later History uses the index, after reading the new versions.
+	for _, version := range testHistoryVersions[2:] {
+		repo.commit(version)
+	}
+	h := newHistory()
+	if tl := timeline(t, h, "example.com"); !reflect.DeepEqual(tl, testHistoryTimeline) {
+		t.Errorf("Unexpected timeline: %#v", tl)
+	}
+	if h.index == nil || h.index.Commits != 4 {
+		t.Fatalf("The index was not used: %#v", h.index)
+	}
+	if skipped := h.Skipped(); len(skipped) != 1 || skipped[0].Subject != "Version 3" {
+		t.Errorf("Unexpected skipped commits: %#v", skipped)
+	}
+
+	// The updated index was persisted.
+	idx := newHistory().loadIndex()
+	if idx == nil || idx.Commits != 4 || idx.Head != h.Commits()[3].Hash {
+		t.Errorf("Unexpected persisted index: %#v", idx)
+	}
+
+	// An index for a rewritten history is not used.
+	repo.git(time.Now(), "commit", "-q", "--amend", "-m", "Rewritten")
+	if idx := newHistory().loadIndex(); idx != nil {
+		t.Errorf("Expected no index for a rewritten history, got %#v", idx)
+	}
+}
@@ -0,0 +1,144 @@
+package preloadlist
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// huffmanCode is the Huffman representation of a character: the low
+// `n` bits of `bits`, most significant bit first.
+type huffmanCode struct {
+	bits uint32
+	n    uint8
+}
+
+// huffmanTable maps characters (0-127) to their Huffman representation.
+type huffmanTable map[byte]huffmanCode
+
+type huffmanNode struct {
+	value       byte
+	count       int
+	left, right *huffmanNode
+}
+
+func (n *huffmanNode) isLeaf() bool {
+	return n.left == nil && n.right == nil
+}
+
+// huffmanBuilder records how often characters are used, and builds a
+// Huffman code for them. It matches the tree construction of Chromium's
+// net/tools/huffman_trie/huffman/huffman_builder.cc, so that the same
+// usage counts produce the same tree.
+type huffmanBuilder struct {
+	counts map[byte]int
+}
+
+func newHuffmanBuilder() *huffmanBuilder {
+	return &huffmanBuilder{counts: make(map[byte]int)}
+}
+
+func (b *huffmanBuilder) recordUsage(c byte) {
+	b.counts[c&127]++
+}
+
+func (b *huffmanBuilder) buildTree() *huffmanNode {
+	values := make([]int, 0, len(b.counts))
+	for c := range b.counts {
+		values = append(values, int(c))
+	}
+	sort.Ints(values)
+
+	nodes := make([]*huffmanNode, 0, len(values)+2)
+	for _, c := range values {
+		nodes = append(nodes, &huffmanNode{value: byte(c), count: b.counts[byte(c)]})
+	}
+	// At least two leaves are needed to form a tree.
+	for i := 0; len(nodes) < 2 && i < 2; i++ {
+		if _, ok := b.counts[byte(i)]; !ok {
+			nodes = append(nodes, &huffmanNode{value: byte(i)})
+		}
+	}
+
+	byCount := func(i, j int) bool { return nodes[i].count < nodes[j].count }
+	sort.SliceStable(nodes, byCount)
+	for len(nodes) > 1 {
+		a, b := nodes[0], nodes[1]
+		nodes = nodes[1:]
+		nodes[0] = &huffmanNode{count: a.count + b.count, left: a, right: b}
+		sort.SliceStable(nodes, byCount)
+	}
+	return nodes[0]
+}
+
+// table returns the Huffman code for the recorded usage.
+func (b *huffmanBuilder) table() huffmanTable {
+	t := make(huffmanTable)
+	var walk func(n *huffmanNode, bits uint32, depth uint8)
+	walk = func(n *huffmanNode, bits uint32, depth uint8) {
+		if n.isLeaf() {
+			t[n.value] = huffmanCode{bits: bits, n: depth}
+			return
+		}
+		walk(n.left, bits<<1, depth+1)
+		walk(n.right, bits<<1|1, depth+1)
+	}
+	walk(b.buildTree(), 0, 0)
+	return t
+}
+
+// tree returns the serialized form of the Huffman tree, as used by
+// Chromium's decoder. Each internal node is two bytes (left, right); a
+// byte with the high bit set is a leaf holding the character in the low
+// seven bits, and any other byte is the index of a child node divided by
+// two. The root is the last node.
+func (b *huffmanBuilder) tree() ([]byte, error) {
+	var out []byte
+	var write func(n *huffmanNode) (int, error)
+	write = func(n *huffmanNode) (int, error) {
+		var children [2]byte
+		for i, child := range []*huffmanNode{n.left, n.right} {
+			if child.isLeaf() {
+				children[i] = 128 | child.value
+				continue
+			}
+			pos, err := write(child)
+			if err != nil {
+				return 0, err
+			}
+			if pos >= 512 {
+				return 0, errors.New("huffman tree too large")
+			}
+			children[i] = byte(pos / 2)
+		}
+		pos := len(out)
+		out = append(out, children[0], children[1])
+		return pos, nil
+	}
+	if _, err := write(b.buildTree()); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeHuffman reads a character from `r` using a serialized Huffman tree.
+func decodeHuffman(tree []byte, r *bitReader) (byte, error) {
+	if len(tree) < 2 || len(tree)%2 != 0 {
+		return 0, errors.New("invalid huffman tree")
+	}
+	node := len(tree) - 2
+	for {
+		bit, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		b := tree[node+int(bit)]
+		if b&0x80 != 0 {
+			return b & 0x7f, nil
+		}
+		node = int(b) * 2
+		if node >= len(tree) {
+			return 0, fmt.Errorf("invalid huffman tree offset %d", node)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package preloadlist
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	// ErrInvalidPunycode is returned by ToASCII for an "xn--" label that is
+	// not valid Punycode.
+	ErrInvalidPunycode = errors.New("invalid punycode")
+	// ErrDisallowedCharacter is returned by ToASCII for a name containing a
+	// character that IDNA2008 does not allow in domain names.
+	ErrDisallowedCharacter = errors.New("character not allowed in IDN")
+	// ErrInvalidIDN is returned by ToASCII for other IDNA violations, e.g.
+	// of the bidi or hyphen rules.
+	ErrInvalidIDN = errors.New("invalid IDN")
+)
+
+// isASCII reports whether `s` contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// hasALabel reports whether an ASCII name has a label with the "xn--"
+// prefix.
+func hasALabel(name string) bool {
+	return strings.HasPrefix(name, "xn--") || strings.Contains(name, ".xn--")
+}
+
+// ToASCII converts a domain name to lowercase A-labels, using the UTS #46
+// (IDNA2008) lookup rules that browsers use. Unicode labels are converted
+// to Punycode, and existing "xn--" labels are checked for validity.
+//
+// ASCII names without "xn--" labels are only lowercased, so that callers
+// can report problems such as invalid characters in their own terms.
+func ToASCII(name string) (string, error) {
+	lower := strings.ToLower(name)
+	if isASCII(lower) {
+		if !hasALabel(lower) {
+			return lower, nil
+		}
+		if _, err := idna.Punycode.ToUnicode(lower); err != nil {
+			return "", fmt.Errorf("%s: %w", name, ErrInvalidPunycode)
+		}
+		if _, err := idna.Lookup.ToUnicode(lower); err != nil {
+			return "", fmt.Errorf("%s: %w (%v)", name, ErrInvalidIDN, err)
+		}
+		return lower, nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		// The idna package does not export its error codes, but disallowed
+		// characters are the most common problem, and the one worth
+		// explaining to users.
+		if strings.Contains(err.Error(), "disallowed rune") {
+			return "", fmt.Errorf("%s: %w (%v)", name, ErrDisallowedCharacter, err)
+		}
+		return "", fmt.Errorf("%s: %w (%v)", name, ErrInvalidIDN, err)
+	}
+	return ascii, nil
+}
+
+// ToUnicode converts the A-labels of a domain name to Unicode, for
+// display. If the name is not a valid IDN, it is returned unchanged.
+func ToUnicode(name string) string {
+	if isASCII(name) && !hasALabel(strings.ToLower(name)) {
+		return name
+	}
+	unicode, err := idna.Display.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return unicode
+}
+
+// DisplayName returns a form of a domain name for showing to users. For
+// internationalized names, this includes both the Unicode and A-label
+// forms, e.g. "bücher.example (xn--bcher-kva.example)". Other names are
+// returned unchanged.
+func DisplayName(name string) string {
+	ascii, err := ToASCII(name)
+	if err != nil {
+		return name
+	}
+	unicode := ToUnicode(ascii)
+	if unicode == ascii {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", unicode, ascii)
+}
+
+// lookupKey returns the form of `domain` used as the key in the index: its
+// A-labels if it is a valid IDN, or else the lowercased name.
+func lookupKey(domain string) string {
+	// ToASCII only lowercases ASCII names (or rejects them), so the IDNA
+	// checks can be skipped.
+	if isASCII(domain) {
+		return strings.ToLower(domain)
+	}
+	if ascii, err := ToASCII(domain); err == nil {
+		return ascii
+	}
+	return strings.ToLower(domain)
+}
@@ -0,0 +1,72 @@
+package preloadlist
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		err      error
+	}{
+		{"example.com", "example.com", nil},
+		{"EXAMPLE.com", "example.com", nil},
+		{"example_org.com", "example_org.com", nil},
+		{"bücher.example", "xn--bcher-kva.example", nil},
+		{"BÜCHER.example", "xn--bcher-kva.example", nil},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example", nil},
+		{"XN--BCHER-KVA.example", "xn--bcher-kva.example", nil},
+		{"xn--bcher-kva!.example", "", ErrInvalidPunycode},
+		{"bü☃\u0000cher.example", "", ErrDisallowedCharacter},
+		{"-bücher.example", "", ErrInvalidIDN},
+	}
+	for _, tt := range tests {
+		ascii, err := ToASCII(tt.name)
+		if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+			t.Errorf("ToASCII(%q): unexpected error %v, expected %v", tt.name, err, tt.err)
+			continue
+		}
+		if ascii != tt.expected {
+			t.Errorf("ToASCII(%q) = %q, expected %q", tt.name, ascii, tt.expected)
+		}
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"example.com", "example.com"},
+		{"bücher.example", "bücher.example (xn--bcher-kva.example)"},
+		{"xn--bcher-kva.example", "bücher.example (xn--bcher-kva.example)"},
+		{"xn--bcher-kva!.example", "xn--bcher-kva!.example"},
+	}
+	for _, tt := range tests {
+		if display := DisplayName(tt.name); display != tt.expected {
+			t.Errorf("DisplayName(%q) = %q, expected %q", tt.name, display, tt.expected)
+		}
+	}
+}
+
+func TestIndexIDN(t *testing.T) {
+	list := PreloadList{Entries: []Entry{
+		{Name: "xn--bcher-kva.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+	}}
+	idx := list.Index()
+
+	for _, domain := range []string{"bücher.example", "BÜCHER.example", "xn--bcher-kva.example"} {
+		if _, found := idx.Get(domain); found != ExactEntryFound {
+			t.Errorf("Get(%q): expected exact entry", domain)
+		}
+	}
+	if entry, found := idx.Get("www.bücher.example"); found != AncestorEntryFound || entry.Name != "xn--bcher-kva.example" {
+		t.Errorf("Get(%q): expected ancestor entry, got %v (%v)", "www.bücher.example", entry, found)
+	}
+
+	if NormalizeName(" Bücher.Example. ") != "xn--bcher-kva.example" {
+		t.Errorf("Unexpected normalized name: %q", NormalizeName(" Bücher.Example. "))
+	}
+}
@@ -3,15 +3,12 @@ package preloadlist
 import (
 	"bufio"
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"strings"
-	"time"
 	"unicode"
 )
 
@@ -40,11 +37,47 @@ const (
 )
 
 // PreloadList contains a parsed form of the Chromium Preload list.
-//
-// The full list contains information about more than just HSTS, but only
-// HSTS-related contents are currently exposed in this struct.
 type PreloadList struct {
-	Entries []Entry `json:"entries"`
+	Pinsets []Pinset `json:"pinsets,omitempty"`
+	Entries []Entry  `json:"entries"`
+}
+
+// A Pinset contains the data from a pinset in the Chromium Preload list.
+//
+// - Name: The name that entries use to refer to the pinset.
+//
+// - StaticSPKIHashes: Names of the SPKI hashes that are accepted for
+//   domains using this pinset. The hashes themselves are defined in
+//   Chromium's transport_security_state_static.pins.
+//
+// - BadStaticSPKIHashes: Names of SPKI hashes that must not appear in the
+//   certificate chain.
+//
+// - ReportURI: Where to send pin validation failure reports, if anywhere.
+type Pinset struct {
+	Name                string   `json:"name"`
+	StaticSPKIHashes    []string `json:"static_spki_hashes,omitempty"`
+	BadStaticSPKIHashes []string `json:"bad_static_spki_hashes,omitempty"`
+	ReportURI           string   `json:"report_uri,omitempty"`
+}
+
+// Pinset returns the pinset with the given name.
+func (p PreloadList) Pinset(name string) (Pinset, bool) {
+	for _, pinset := range p.Pinsets {
+		if pinset.Name == name {
+			return pinset, true
+		}
+	}
+	return Pinset{}, false
+}
+
+// EntryPinset returns the pinset used by the given entry. It returns false
+// if the entry is not pinned, or if the pinset is not in the list.
+func (p PreloadList) EntryPinset(e Entry) (Pinset, bool) {
+	if e.Pins == "" {
+		return Pinset{}, false
+	}
+	return p.Pinset(e.Pins)
 }
 
 // PolicyType represents the policy under which the domain was added to the preload list.
@@ -85,36 +118,75 @@ const (
 //   all subdomains.
 // 
 // - Policy: The policy that was enforced when the the domain was added to the preload list.
-//   Will be used to filter lists for automated removal from preload list as domains under
-//   different policies may adhere to different dynamic hsts requirements.
+//   Used to filter lists for automated removal from preload list (see
+//   batch.RemovalPipeline), as domains under different policies may adhere to
+//   different dynamic hsts requirements.
+//
+// - Pins: The name of the Pinset used by the domain, if any.
+//
+// - IncludeSubDomainsForPinning: Applies the pins to all subdomains,
+//   without forcing HSTS for them.
+//
+// - ExpectCT: Whether Expect-CT reporting is enabled for the domain.
+//
+// - ExpectCTReportURI: Where to send Expect-CT reports.
 type Entry struct {
-	Name              string     `json:"name"`
-	Mode              string     `json:"mode"`
-	IncludeSubDomains bool       `json:"include_subdomains"`
-	Policy            PolicyType `json:"policy"`
+	Name                        string     `json:"name"`
+	Mode                        string     `json:"mode"`
+	IncludeSubDomains           bool       `json:"include_subdomains"`
+	Policy                      PolicyType `json:"policy"`
+	Pins                        string     `json:"pins,omitempty"`
+	IncludeSubDomainsForPinning bool       `json:"include_subdomains_for_pinning,omitempty"`
+	ExpectCT                    bool       `json:"expect_ct,omitempty"`
+	ExpectCTReportURI           string     `json:"expect_ct_report_uri,omitempty"`
 }
 
 // Equal checks if Entry e is equal to Entry e2 using == to compare all fields.
 func (e Entry) Equal(e2 Entry) bool {
-	return e.Name == e2.Name && e.Mode == e2.Mode && e.IncludeSubDomains == e2.IncludeSubDomains && e.Policy == e2.Policy
+	return e == e2
 }
 
 // IndexedEntries is case-insensitive index of
-// the entries from the given PreloadList.
+// the entries from the given PreloadList. Internationalized names are
+// indexed and looked up by their A-labels (see ToASCII), so that
+// "bücher.example" finds the entry for "xn--bcher-kva.example".
+//
+// Entries are stored in a trie keyed by reversed labels, so that all
+// entries under a suffix, or all entries covering a domain, can be found
+// efficiently.
 type IndexedEntries struct {
-	index map[string]Entry
+	root     *trieNode
+	size     int
+	policies map[PolicyType]int
+	pinsets  map[string]Pinset
 }
 
 // Index creates an index out of the given list.
+//
+// If a name appears more than once, the last entry for it is used.
 func (p PreloadList) Index() (idx IndexedEntries) {
-	m := make(map[string]Entry)
-	for _, entry := range p.Entries {
-		d := strings.ToLower(string(entry.Name))
-		m[d] = entry
+	idx = IndexedEntries{
+		root:     &trieNode{},
+		policies: make(map[PolicyType]int),
+		pinsets:  make(map[string]Pinset),
+	}
+	for i, entry := range p.Entries {
+		n := idx.root.insert(lookupKey(entry.Name))
+		if n.hasEntry {
+			idx.policies[n.entry.Policy]--
+			if idx.policies[n.entry.Policy] == 0 {
+				delete(idx.policies, n.entry.Policy)
+			}
+		} else {
+			idx.size++
+		}
+		n.entry, n.hasEntry, n.pos = entry, true, i
+		idx.policies[entry.Policy]++
 	}
-	return IndexedEntries{
-		index: m,
+	for _, pinset := range p.Pinsets {
+		idx.pinsets[pinset.Name] = pinset
 	}
+	return idx
 }
 
 // Get returns an entry from the index preload list along with a status
@@ -123,20 +195,46 @@ func (p PreloadList) Index() (idx IndexedEntries) {
 // set to true is on the list, the closest such ancestor entry is returned.
 // Failing all that, a zero-value entry is returned.
 func (idx IndexedEntries) Get(domain string) (Entry, HstsPreloadEntryFound) {
-	// Check if the domain itself is on the list.
-	domain = strings.ToLower(domain)
-	entry, ok := idx.index[domain]
-	if ok {
-		return entry, ExactEntryFound
+	if idx.root == nil {
+		return Entry{}, EntryNotFound
+	}
+	// Walk down from the TLD, remembering the closest ancestor domain which
+	// includes subdomains.
+	entry, found := Entry{}, EntryNotFound
+	idx.root.walk(lookupKey(domain), func(n *trieNode, exact bool) {
+		switch {
+		case !n.hasEntry:
+		case exact:
+			entry, found = n.entry, ExactEntryFound
+		case n.entry.IncludeSubDomains:
+			entry, found = n.entry, AncestorEntryFound
+		}
+	})
+	return entry, found
+}
+
+// GetPinset returns the pinset that applies to a domain, along with the
+// entry that refers to it and a status indicating how the entry is found.
+// An ancestor entry applies if it has "include_subdomains" or
+// "include_subdomains_for_pinning" set to true.
+func (idx IndexedEntries) GetPinset(domain string) (Pinset, Entry, HstsPreloadEntryFound) {
+	if idx.root == nil {
+		return Pinset{}, Entry{}, EntryNotFound
 	}
-	// Walk up the chain until we find an ancestor domain which includes subdomains.
-	for domain, ok = parentDomain(domain); ok; domain, ok = parentDomain(domain) {
-		entry, ok = idx.index[domain]
-		if ok && entry.IncludeSubDomains {
-			return entry, AncestorEntryFound
+	entry, found := Entry{}, EntryNotFound
+	idx.root.walk(lookupKey(domain), func(n *trieNode, exact bool) {
+		switch {
+		case !n.hasEntry || n.entry.Pins == "":
+		case exact:
+			entry, found = n.entry, ExactEntryFound
+		case n.entry.IncludeSubDomains || n.entry.IncludeSubDomainsForPinning:
+			entry, found = n.entry, AncestorEntryFound
 		}
+	})
+	if found == EntryNotFound {
+		return Pinset{}, Entry{}, EntryNotFound
 	}
-	return Entry{"", "", false, ""}, EntryNotFound
+	return idx.pinsets[entry.Pins], entry, found
 }
 
 // parentDomain finds the parent (immediate ancestor) domain of the input domain.
@@ -178,7 +276,7 @@ func removeComments(r io.Reader) ([]byte, error) {
 	sc := bufio.NewScanner(r)
 	for sc.Scan() {
 		line := sc.Text()
-		if isCommentLine(line) {
+		if !isCommentLine(line) {
 			fmt.Fprintln(&buf, line)
 		}
 	}
@@ -191,30 +289,16 @@ func removeComments(r io.Reader) ([]byte, error) {
 
 func isCommentLine(line string) bool {
 	trimmed := strings.TrimLeftFunc(line, unicode.IsSpace)
-	return !strings.HasPrefix(trimmed, "//")
+	return strings.HasPrefix(trimmed, "//")
 }
 
 // NewFromChromiumURL retrieves the PreloadList from a URL that returns the list
 // in base 64.
+//
+// The list is downloaded on every call. To cache it, use a Fetcher.
 func NewFromChromiumURL(u string) (PreloadList, error) {
-	var list PreloadList
-
-	client := http.Client{
-		Timeout: time.Second * 10,
-	}
-
-	resp, err := client.Get(u)
-	if err != nil {
-		return list, err
-	}
-
-	if resp.StatusCode != 200 {
-		return list, fmt.Errorf("status code %d", resp.StatusCode)
-	}
-
-	body := base64.NewDecoder(base64.StdEncoding, resp.Body)
-
-	return Parse(body)
+	list, _, err := (&Fetcher{}).Fetch(u)
+	return list, err
 }
 
 // NewFromLatest retrieves the latest PreloadList from the Chromium source at
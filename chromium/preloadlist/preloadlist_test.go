@@ -3,6 +3,7 @@ package preloadlist
 import (
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -29,7 +30,7 @@ func TestIndexing(t *testing.T) {
 
 	idx := list.Index()
 
-	if len(idx.index) != 3 {
+	if idx.Len() != 3 {
 		t.Errorf("Map has the wrong number of entries.")
 	}
 
@@ -129,11 +130,11 @@ var (
   ]
 }`
 	testParsed = PreloadList{Entries: []Entry{
-		{"garron.net", "force-https", true, ""},
-		{"example.com", "force-https", false, ""},
-		{"gmail.com", "force-https", false, ""},
-		{"google.com", "", false, ""},
-		{"pinned.badssl.com", "", false, ""}},
+		{Name: "garron.net", Mode: "force-https", IncludeSubDomains: true},
+		{Name: "example.com", Mode: "force-https", IncludeSubDomains: false},
+		{Name: "gmail.com", Mode: "force-https", IncludeSubDomains: false},
+		{Name: "google.com", Mode: "", IncludeSubDomains: false},
+		{Name: "pinned.badssl.com", Mode: "", IncludeSubDomains: false, Pins: "pinnymcpinnedkey"}},
 	}
 )
 
@@ -157,3 +158,94 @@ func TestNewFromFile(t *testing.T) {
 		t.Errorf("Parsed list does not match expected. %#v", list)
 	}
 }
+
+const testPinnedJSON = `{
+  "pinsets": [
+    {
+      "name": "test",
+      "static_spki_hashes": ["TestSPKI"],
+      "bad_static_spki_hashes": ["BadTestSPKI"],
+      "report_uri": "http://report-example.test/test"
+    },
+    {"name": "google", "static_spki_hashes": ["GoogleBackup2048", "GTSCAR1"]}
+  ],
+  "entries": [
+    // Pinned entries.
+    {"name": "pinningtest.appspot.com", "policy": "test", "pins": "test"},
+    {"name": "google.com", "policy": "google", "mode": "force-https", "include_subdomains_for_pinning": true, "pins": "google"},
+    {"name": "expect-ct.example", "policy": "custom", "expect_ct": true, "expect_ct_report_uri": "https://report.example/ct"},
+    {"name": "accounts.google.com", "policy": "google", "mode": "force-https", "include_subdomains": true}
+  ]
+}`
+
+func TestParsePinsets(t *testing.T) {
+	list, err := Parse(strings.NewReader(testPinnedJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedPinsets := []Pinset{
+		{
+			Name:                "test",
+			StaticSPKIHashes:    []string{"TestSPKI"},
+			BadStaticSPKIHashes: []string{"BadTestSPKI"},
+			ReportURI:           "http://report-example.test/test",
+		},
+		{Name: "google", StaticSPKIHashes: []string{"GoogleBackup2048", "GTSCAR1"}},
+	}
+	if !reflect.DeepEqual(list.Pinsets, expectedPinsets) {
+		t.Errorf("Parsed pinsets do not match expected. %#v", list.Pinsets)
+	}
+
+	expectedEntries := []Entry{
+		{Name: "pinningtest.appspot.com", Policy: Test, Pins: "test"},
+		{Name: "google.com", Policy: Google, Mode: ForceHTTPS, IncludeSubDomainsForPinning: true, Pins: "google"},
+		{Name: "expect-ct.example", Policy: Custom, ExpectCT: true, ExpectCTReportURI: "https://report.example/ct"},
+		{Name: "accounts.google.com", Policy: Google, Mode: ForceHTTPS, IncludeSubDomains: true},
+	}
+	if !reflect.DeepEqual(list.Entries, expectedEntries) {
+		t.Errorf("Parsed entries do not match expected. %#v", list.Entries)
+	}
+
+	pinset, ok := list.EntryPinset(list.Entries[0])
+	if !ok || pinset.ReportURI != "http://report-example.test/test" {
+		t.Errorf("Wrong pinset for entry: %#v", pinset)
+	}
+	if _, ok := list.EntryPinset(list.Entries[2]); ok {
+		t.Errorf("Entry without pins should not have a pinset.")
+	}
+	if _, ok := list.Pinset("missing"); ok {
+		t.Errorf("Pinset should not be present.")
+	}
+}
+
+func TestGetPinset(t *testing.T) {
+	list, err := Parse(strings.NewReader(testPinnedJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := list.Index()
+
+	tests := []struct {
+		domain         string
+		expectedPinset string
+		expectedEntry  string
+		expectedStatus HstsPreloadEntryFound
+	}{
+		{"pinningtest.appspot.com", "test", "pinningtest.appspot.com", ExactEntryFound},
+		{"sub.pinningtest.appspot.com", "", "", EntryNotFound},
+		{"GOOGLE.com", "google", "google.com", ExactEntryFound},
+		// accounts.google.com is not pinned itself, but google.com includes
+		// subdomains for pinning.
+		{"accounts.google.com", "google", "google.com", AncestorEntryFound},
+		{"mail.google.com", "google", "google.com", AncestorEntryFound},
+		{"expect-ct.example", "", "", EntryNotFound},
+	}
+
+	for _, tt := range tests {
+		pinset, entry, status := idx.GetPinset(tt.domain)
+		if pinset.Name != tt.expectedPinset || entry.Name != tt.expectedEntry || status != tt.expectedStatus {
+			t.Errorf("[%s] Unexpected result: %q %q %d", tt.domain, pinset.Name, entry.Name, status)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+static const uint8_t kHSTSHuffmanTree[] = {
+    0xe4, 0xf6, 0xf2, 0x00, 0x01, 0xef, 0xe3, 0xf7, 0x03, 0xe1, 0x02, 0x04,
+    0xe7, 0xec, 0xf0, 0x06, 0x07, 0xff, 0x05, 0x08, 0xb9, 0xe8, 0xb0, 0xb8,
+    0x0a, 0x0b, 0xf9, 0xfa, 0xeb, 0xf1, 0x0d, 0x0e, 0x0c, 0x0f, 0xf4, 0x10,
+    0xe9, 0xf3, 0x11, 0x12, 0xf5, 0xe2, 0xe6, 0x14, 0xf8, 0x15, 0x16, 0xad,
+    0x13, 0x17, 0xee, 0xe5, 0xae, 0xed, 0x80, 0x1a, 0x19, 0x1b, 0x18, 0x1c,
+    0x09, 0x1d,
+};
+
+static const uint8_t kPreloadedHSTSData[] = {
+    0x7f, 0xe5, 0xa7, 0xce, 0x9b, 0xe3, 0x0e, 0x3a, 0xc1, 0xb1, 0x24, 0xaf,
+    0x77, 0x94, 0xeb, 0x7d, 0xf0, 0xae, 0xa3, 0x25, 0xde, 0x53, 0x89, 0xfb,
+    0x73, 0x24, 0x9c, 0x60, 0x74, 0xa5, 0xeb, 0x0d, 0x10, 0x01, 0x20, 0x94,
+    0xa9, 0xbe, 0x00, 0xfb, 0x56, 0x9f, 0x3a, 0x6f, 0x17, 0x8d, 0x8b, 0xd2,
+    0x3f, 0xf3, 0x8b, 0xfa, 0x19, 0x58, 0x48, 0x43, 0xeb, 0x16, 0x7a, 0x13,
+    0x20, 0x78, 0x45, 0x5c, 0x63, 0xf1, 0xa3, 0x76, 0xb4, 0xf8, 0x9f, 0xf4,
+    0xf6, 0x16, 0xac, 0x96, 0x64, 0x93, 0x94, 0x99, 0x5c, 0xf8, 0xa0, 0x34,
+    0xdb, 0xe7, 0x4d, 0xef, 0x8d, 0x2b, 0x8b, 0x52, 0x39, 0x52, 0x16, 0xed,
+    0xff, 0x0a, 0x4d, 0x10, 0xfd, 0x09, 0xec, 0x15, 0x92, 0xcc, 0x92, 0x70,
+    0x91, 0xe1, 0x27, 0xb5, 0x13, 0x30, 0x2e, 0x75, 0x0f, 0xaf, 0xfd, 0x17,
+    0x38, 0xf9, 0x55, 0x77, 0x94, 0xf1, 0x4a, 0x5e, 0xb3, 0x89, 0x9d, 0x67,
+    0x5f, 0xff, 0xff, 0xdb, 0x49, 0x55, 0xa0, 0x4b, 0xc1, 0xff, 0xc4, 0x96,
+    0xac, 0x15, 0xd8, 0x06, 0xab, 0xdf, 0x9e, 0x5a, 0xb0, 0x57, 0x71, 0x80,
+    0xd0, 0xec, 0xcb, 0x4a, 0xe1, 0x4e, 0xdc, 0x47, 0x5f, 0xf6, 0xe7, 0xb5,
+    0x69, 0xf3, 0xa6, 0xfa, 0x29, 0x32, 0x19, 0x90, 0xc6, 0xc3, 0xa5, 0xdd,
+    0x0b, 0xac, 0xd5, 0xda, 0xe5, 0x46, 0x53, 0xf9, 0x48, 0x08, 0x65, 0x31,
+    0x38, 0x46, 0xee, 0x1b, 0xa3,
+};
+
+static const unsigned kPreloadedHSTSBits = 1576;
+
+static const unsigned kHSTSRootPosition = 1454;
@@ -0,0 +1,176 @@
+package preloadlist
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A trieNode is a node of a label-reversed trie: the children of the root
+// are keyed by TLD, their children by the next label, and so on. A node
+// holds an entry if its name is on the list.
+type trieNode struct {
+	children map[string]*trieNode
+	entry    Entry
+	hasEntry bool
+	// pos is the position of the entry in the list, used to return
+	// entries in list order.
+	pos int
+}
+
+// nextLabel splits the last label off `domain`. `rest` is the remaining
+// prefix, and `more` is false when `label` was the first label.
+func nextLabel(domain string) (label string, rest string, more bool) {
+	dot := strings.LastIndexByte(domain, '.')
+	if dot == -1 {
+		return domain, "", false
+	}
+	return domain[dot+1:], domain[:dot], true
+}
+
+// insert adds a node for the normalized domain, returning it.
+func (n *trieNode) insert(domain string) *trieNode {
+	for more := true; more; {
+		var label string
+		label, domain, more = nextLabel(domain)
+		child, ok := n.children[label]
+		if !ok {
+			child = &trieNode{}
+			if n.children == nil {
+				n.children = make(map[string]*trieNode)
+			}
+			n.children[label] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// walk calls fn on each node along the path to the normalized domain,
+// starting with the TLD. `exact` is true for the node of `domain` itself.
+// The walk ends early at the first label that is not in the trie.
+func (n *trieNode) walk(domain string, fn func(n *trieNode, exact bool)) {
+	for more := true; more; {
+		var label string
+		label, domain, more = nextLabel(domain)
+		child, ok := n.children[label]
+		if !ok {
+			return
+		}
+		n = child
+		fn(n, !more)
+	}
+}
+
+// find returns the node of the normalized domain, or nil.
+func (n *trieNode) find(domain string) *trieNode {
+	var found *trieNode
+	n.walk(domain, func(n *trieNode, exact bool) {
+		if exact {
+			found = n
+		}
+	})
+	return found
+}
+
+// collect appends the nodes with entries in the subtree of n.
+func (n *trieNode) collect(nodes []*trieNode) []*trieNode {
+	if n.hasEntry {
+		nodes = append(nodes, n)
+	}
+	for _, child := range n.children {
+		nodes = child.collect(nodes)
+	}
+	return nodes
+}
+
+// lookup returns the entry for exactly `domain`, if there is one.
+func (idx IndexedEntries) lookup(domain string) (Entry, bool) {
+	if idx.root == nil {
+		return Entry{}, false
+	}
+	n := idx.root.find(lookupKey(domain))
+	if n == nil || !n.hasEntry {
+		return Entry{}, false
+	}
+	return n.entry, true
+}
+
+// Len returns the number of distinct names in the index.
+func (idx IndexedEntries) Len() int {
+	return idx.size
+}
+
+// CountByPolicy returns the number of distinct names in the index for each
+// policy.
+func (idx IndexedEntries) CountByPolicy() map[PolicyType]int {
+	counts := make(map[PolicyType]int, len(idx.policies))
+	for policy, count := range idx.policies {
+		counts[policy] = count
+	}
+	return counts
+}
+
+// Under returns the entries for `suffix` and all of its subdomains, in the
+// order of the list. An empty suffix returns every entry.
+func (idx IndexedEntries) Under(suffix string) []Entry {
+	if idx.root == nil {
+		return nil
+	}
+	n := idx.root
+	if suffix != "" {
+		n = idx.root.find(lookupKey(suffix))
+		if n == nil {
+			return nil
+		}
+	}
+
+	nodes := n.collect(nil)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].pos < nodes[j].pos })
+	entries := make([]Entry, len(nodes))
+	for i, node := range nodes {
+		entries[i] = node.entry
+	}
+	return entries
+}
+
+// Covering returns every entry that applies to `domain`: its own entry (if
+// any), followed by each ancestor entry with "include_subdomains" set to
+// true, closest first. The first entry is the one that Get returns.
+func (idx IndexedEntries) Covering(domain string) []Entry {
+	if idx.root == nil {
+		return nil
+	}
+	var entries []Entry
+	idx.root.walk(lookupKey(domain), func(n *trieNode, exact bool) {
+		if n.hasEntry && (exact || n.entry.IncludeSubDomains) {
+			entries = append(entries, n.entry)
+		}
+	})
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// GetEach looks up each domain in `r`, which contains one domain per line,
+// and calls fn with the result of Get. Blank lines are skipped, and
+// surrounding whitespace is ignored. GetEach stops at the first error
+// returned by fn.
+//
+// The input is streamed, so it may contain any number of domains.
+func (idx IndexedEntries) GetEach(r io.Reader, fn func(domain string, entry Entry, found HstsPreloadEntryFound) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		domain := strings.TrimSpace(scanner.Text())
+		if domain == "" {
+			continue
+		}
+		entry, found := idx.Get(domain)
+		if err := fn(domain, entry, found); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
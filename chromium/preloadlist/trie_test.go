@@ -0,0 +1,120 @@
+package preloadlist
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var trieTestList = PreloadList{Entries: []Entry{
+	{Name: "app", Policy: PublicSuffix, Mode: ForceHTTPS, IncludeSubDomains: true},
+	{Name: "example.app", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+	{Name: "www.example.app", Policy: Custom, Mode: ForceHTTPS},
+	{Name: "Other.app", Policy: Bulk1Year, Mode: ForceHTTPS},
+	{Name: "example.com", Policy: Bulk18Weeks, Mode: ForceHTTPS},
+	{Name: "example.com", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+}}
+
+func entryNames(entries []Entry) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestIndexUnder(t *testing.T) {
+	idx := trieTestList.Index()
+
+	tests := []struct {
+		suffix   string
+		expected []string
+	}{
+		{"app", []string{"app", "example.app", "www.example.app", "Other.app"}},
+		{"EXAMPLE.app", []string{"example.app", "www.example.app"}},
+		{"www.example.app", []string{"www.example.app"}},
+		{"missing.app", nil},
+		{"", []string{"app", "example.app", "www.example.app", "Other.app", "example.com"}},
+	}
+	for _, tt := range tests {
+		if names := entryNames(idx.Under(tt.suffix)); !reflect.DeepEqual(names, tt.expected) {
+			t.Errorf("Under(%q) = %v, expected %v", tt.suffix, names, tt.expected)
+		}
+	}
+}
+
+func TestIndexCovering(t *testing.T) {
+	idx := trieTestList.Index()
+
+	tests := []struct {
+		domain   string
+		expected []string
+	}{
+		{"www.example.app", []string{"www.example.app", "example.app", "app"}},
+		{"foo.www.example.app", []string{"example.app", "app"}},
+		{"foo.other.app", []string{"app"}},
+		{"example.app", []string{"example.app", "app"}},
+		{"example.org", nil},
+	}
+	for _, tt := range tests {
+		if names := entryNames(idx.Covering(tt.domain)); !reflect.DeepEqual(names, tt.expected) {
+			t.Errorf("Covering(%q) = %v, expected %v", tt.domain, names, tt.expected)
+		}
+	}
+}
+
+func TestIndexCounts(t *testing.T) {
+	idx := trieTestList.Index()
+
+	if idx.Len() != 5 {
+		t.Errorf("Len() = %d, expected 5", idx.Len())
+	}
+
+	expected := map[PolicyType]int{PublicSuffix: 1, Bulk1Year: 3, Custom: 1}
+	if counts := idx.CountByPolicy(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("CountByPolicy() = %v, expected %v", counts, expected)
+	}
+
+	var empty IndexedEntries
+	if _, found := empty.Get("example.com"); found != EntryNotFound {
+		t.Errorf("Zero index should not contain entries.")
+	}
+}
+
+func TestIndexGetEach(t *testing.T) {
+	idx := trieTestList.Index()
+
+	input := "www.example.app\n\n  foo.other.app  \nexample.org\n"
+	type result struct {
+		domain, entry string
+		found         HstsPreloadEntryFound
+	}
+	var results []result
+	err := idx.GetEach(strings.NewReader(input), func(domain string, entry Entry, found HstsPreloadEntryFound) error {
+		results = append(results, result{domain, entry.Name, found})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []result{
+		{"www.example.app", "www.example.app", ExactEntryFound},
+		{"foo.other.app", "app", AncestorEntryFound},
+		{"example.org", "", EntryNotFound},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Unexpected results: %#v", results)
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = idx.GetEach(strings.NewReader(input), func(string, Entry, HstsPreloadEntryFound) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("Expected GetEach to stop at the first error, got %v after %d calls", err, calls)
+	}
+}
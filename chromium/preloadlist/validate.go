@@ -0,0 +1,164 @@
+package preloadlist
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// A ValidationIssue describes a problem with an entry of a PreloadList.
+//
+// Codes use the same dotted style as hstspreload.IssueCode, e.g.
+// "list.duplicate_entry".
+type ValidationIssue struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("%s [%s]: %s", i.Name, i.Code, i.Message)
+}
+
+var knownModes = map[string]bool{
+	"":         true,
+	ForceHTTPS: true,
+}
+
+var knownPolicies = map[PolicyType]bool{
+	UnspecifiedPolicyType: true,
+	Test:                  true,
+	Google:                true,
+	Custom:                true,
+	BulkLegacy:            true,
+	Bulk18Weeks:           true,
+	Bulk1Year:             true,
+	PublicSuffix:          true,
+	PublicSuffixRequested: true,
+}
+
+// checkName checks that `name` is a well-formed, normalized domain name,
+// using the same rules as the hstspreload domain checks (except that
+// public suffixes are allowed). It returns nil if there are no problems.
+func checkName(name string) *ValidationIssue {
+	issue := func(code string, format string, args ...interface{}) *ValidationIssue {
+		return &ValidationIssue{Code: code, Name: name, Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch {
+	case name == "":
+		return issue("list.name.empty", "The entry does not have a name.")
+	case strings.HasPrefix(name, "."):
+		return issue("list.name.begins_with_dot", "The name %q begins with `.`", name)
+	case strings.HasSuffix(name, "."):
+		return issue("list.name.ends_with_dot", "The name %q ends with `.`", name)
+	case strings.Contains(name, ".."):
+		return issue("list.name.contains_double_dot", "The name %q contains `..`", name)
+	}
+
+	if _, err := ToASCII(name); err != nil {
+		return issue("list.name.invalid_idn", "The name %q is not a valid internationalized domain name (%s).", name, errors.Unwrap(err))
+	}
+	if NormalizeName(name) != name {
+		return issue("list.name.not_normalized", "The name %q is not normalized (expected %q).", name, NormalizeName(name))
+	}
+
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			continue
+		}
+		return issue("list.name.invalid_characters", "The name %q contains invalid characters.", name)
+	}
+
+	if net.ParseIP(name) != nil {
+		return issue("list.name.is_ip_address", "The name %q is an IP address.", name)
+	}
+
+	return nil
+}
+
+// isPublicSuffix reports whether `name` is in the ICANN section of the
+// public suffix list. Suffixes in the private section (e.g. github.io) are
+// registered by their owners, and are preloaded like other sites. Unlisted
+// TLDs are not considered public suffixes.
+func isPublicSuffix(name string) bool {
+	ps, icann := publicsuffix.PublicSuffix(name)
+	return ps == name && icann
+}
+
+// Validate checks the list for problems that the parser accepts:
+//
+// - Duplicate names, and names that are not well-formed and normalized.
+//
+// - Entries that are redundant, because an ancestor entry has the same
+// mode and includes subdomains (and the entry has no pins or Expect-CT).
+//
+// - Unknown mode and policy values.
+//
+// - ICANN public suffixes that do not have the PublicSuffix policy.
+//
+// The issues are returned in the order of the entries.
+func (p PreloadList) Validate() []ValidationIssue {
+	var issues []ValidationIssue
+	idx := p.Index()
+	seen := make(map[string]bool)
+
+	for _, e := range p.Entries {
+		if issue := checkName(e.Name); issue != nil {
+			issues = append(issues, *issue)
+		}
+
+		name := lookupKey(e.Name)
+		if seen[name] {
+			issues = append(issues, ValidationIssue{
+				Code:    "list.duplicate_entry",
+				Name:    e.Name,
+				Message: fmt.Sprintf("The name %q appears more than once.", e.Name),
+			})
+		}
+		seen[name] = true
+
+		if e.Pins == "" && !e.ExpectCT {
+			for ancestor, ok := parentDomain(name); ok; ancestor, ok = parentDomain(ancestor) {
+				a, found := idx.lookup(ancestor)
+				if found && a.IncludeSubDomains && a.Mode == e.Mode {
+					issues = append(issues, ValidationIssue{
+						Code:    "list.redundant_entry",
+						Name:    e.Name,
+						Message: fmt.Sprintf("The entry is redundant, because %q has the same mode and includes subdomains.", a.Name),
+					})
+					break
+				}
+			}
+		}
+
+		if !knownModes[e.Mode] {
+			issues = append(issues, ValidationIssue{
+				Code:    "list.unknown_mode",
+				Name:    e.Name,
+				Message: fmt.Sprintf("The mode %q is not known.", e.Mode),
+			})
+		}
+
+		if !knownPolicies[e.Policy] {
+			issues = append(issues, ValidationIssue{
+				Code:    "list.unknown_policy",
+				Name:    e.Name,
+				Message: fmt.Sprintf("The policy %q is not known.", e.Policy),
+			})
+		}
+
+		if e.Policy != PublicSuffix && isPublicSuffix(name) {
+			issues = append(issues, ValidationIssue{
+				Code:    "list.public_suffix.wrong_policy",
+				Name:    e.Name,
+				Message: fmt.Sprintf("The name is a public suffix, but has the policy %q instead of %q.", e.Policy, PublicSuffix),
+			})
+		}
+	}
+
+	return issues
+}
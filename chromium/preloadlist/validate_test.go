@@ -0,0 +1,67 @@
+package preloadlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	list := PreloadList{Entries: []Entry{
+		{Name: "example.com", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "www.example.com", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "pinned.example.com", Policy: Custom, Mode: ForceHTTPS, Pins: "test"},
+		{Name: "example.net", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "Example.NET", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "sub.example.net", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: ".example.org", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "example_org.com", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "1.1.1.1", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "mode.example", Policy: Custom, Mode: "force-http"},
+		{Name: "policy.example", Policy: "bulk-2-years", Mode: ForceHTTPS},
+		{Name: "dev", Policy: Google, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "app", Policy: PublicSuffix, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "foo.app", Policy: PublicSuffixRequested, Mode: ForceHTTPS},
+		{Name: "unlisted", Policy: Test, Mode: ForceHTTPS},
+		{Name: "co.uk", Policy: Custom, Mode: ForceHTTPS, IncludeSubDomains: true},
+		// Private public suffixes are preloaded like other sites.
+		{Name: "github.io", Policy: Custom, Mode: ForceHTTPS, IncludeSubDomains: true},
+		{Name: "blogspot.com", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "bücher.example", Policy: Bulk1Year, Mode: ForceHTTPS},
+		{Name: "xn--bcher-kva!.example", Policy: Bulk1Year, Mode: ForceHTTPS},
+	}}
+
+	var codes []string
+	for _, issue := range list.Validate() {
+		codes = append(codes, issue.Name+" "+issue.Code)
+	}
+
+	expected := []string{
+		"www.example.com list.redundant_entry",
+		"Example.NET list.name.not_normalized",
+		"Example.NET list.duplicate_entry",
+		".example.org list.name.begins_with_dot",
+		"example_org.com list.name.invalid_characters",
+		"1.1.1.1 list.name.is_ip_address",
+		"mode.example list.unknown_mode",
+		"policy.example list.unknown_policy",
+		"dev list.public_suffix.wrong_policy",
+		"foo.app list.redundant_entry",
+		"co.uk list.public_suffix.wrong_policy",
+		"bücher.example list.name.not_normalized",
+		"xn--bcher-kva!.example list.name.invalid_idn",
+	}
+	if !reflect.DeepEqual(codes, expected) {
+		t.Errorf("Unexpected issues: %#v", codes)
+	}
+
+	issues := list.Validate()
+	if issues[0].Message != `The entry is redundant, because "example.com" has the same mode and includes subdomains.` {
+		t.Errorf("Unexpected message: %s", issues[0].Message)
+	}
+}
+
+func TestValidateClean(t *testing.T) {
+	if issues := testParsed.Validate(); len(issues) != 0 {
+		t.Errorf("Expected no issues, got %v", issues)
+	}
+}
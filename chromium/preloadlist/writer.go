@@ -0,0 +1,361 @@
+package preloadlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultEntryIndent is the indentation of entries in the
+	// "entries" array of the Chromium preload list.
+	defaultEntryIndent = "    "
+)
+
+var entriesStart = regexp.MustCompile(`^"entries"\s*:\s*\[$`)
+
+// layout records the formatting of a parsed preload list file, so that the
+// list can be written back out with its comments and ordering intact.
+type layout struct {
+	lines []layoutLine
+	// The pinsets as parsed. Edited pinsets cannot be written back into the
+	// original formatting.
+	pinsets []Pinset
+}
+
+// A layoutLine is a single line of the original file.
+type layoutLine struct {
+	text string
+	// Whether the line contains a single entry of the "entries" array.
+	isEntry bool
+	// The entry on the line, as parsed.
+	entry Entry
+	// Whether the line closes the "entries" array.
+	isEntriesEnd bool
+}
+
+// newLayout records the lines of `raw`, which was parsed into `list`. It
+// returns nil if the entries are not formatted one per line, in which case
+// the list can only be written in the canonical format.
+func newLayout(raw []byte, list PreloadList) *layout {
+	l := &layout{pinsets: list.Pinsets}
+
+	inEntries, foundEnd := false, false
+	n := 0
+	for _, text := range strings.Split(string(raw), "\n") {
+		line := layoutLine{text: text}
+		trimmed := strings.TrimSpace(text)
+
+		switch {
+		case !inEntries && entriesStart.MatchString(trimmed):
+			inEntries = true
+		case inEntries && (trimmed == "]" || trimmed == "],"):
+			inEntries = false
+			foundEnd = true
+			line.isEntriesEnd = true
+		case inEntries && trimmed != "" && !isCommentLine(text):
+			var entry Entry
+			err := json.Unmarshal([]byte(strings.TrimSuffix(trimmed, ",")), &entry)
+			if err != nil || n >= len(list.Entries) || !entry.Equal(list.Entries[n]) {
+				return nil
+			}
+			line.isEntry = true
+			line.entry = entry
+			n++
+		}
+
+		l.lines = append(l.lines, line)
+	}
+
+	if !foundEnd || n != len(list.Entries) {
+		return nil
+	}
+	return l
+}
+
+// An outputLine is a line of the file being written.
+type outputLine struct {
+	text    string
+	isEntry bool
+}
+
+// format writes `entries` using the layout.
+//
+// Entries from the original file are written in their original position,
+// and keep their original text if they are unchanged. Entries that are no
+// longer present are dropped. New entries are written after the last entry
+// with the same policy, or at the end of the list if there is no such entry.
+func (l *layout) format(entries []Entry) []byte {
+	pending := make(map[string][]int)
+	for i, e := range entries {
+		name := strings.ToLower(e.Name)
+		pending[name] = append(pending[name], i)
+	}
+	written := make([]bool, len(entries))
+
+	var out []outputLine
+	// New entries to write after the output line at the given index.
+	insertAfter := make(map[int][]Entry)
+	lastOfPolicy := make(map[PolicyType]int)
+	indent := make(map[int]string)
+	entriesEnd := -1
+	defaultIndent := defaultEntryIndent
+
+	for _, line := range l.lines {
+		if line.isEntriesEnd {
+			entriesEnd = len(out)
+		}
+		if !line.isEntry {
+			out = append(out, outputLine{text: line.text})
+			continue
+		}
+
+		name := strings.ToLower(line.entry.Name)
+		if len(pending[name]) == 0 {
+			// The entry was removed.
+			continue
+		}
+		i := pending[name][0]
+		pending[name] = pending[name][1:]
+		written[i] = true
+
+		e := entries[i]
+		text := line.text
+		lineIndent := leadingWhitespace(line.text)
+		if !e.Equal(line.entry) {
+			text = lineIndent + formatEntry(e)
+		}
+		lastOfPolicy[e.Policy] = len(out)
+		indent[len(out)] = lineIndent
+		defaultIndent = lineIndent
+		out = append(out, outputLine{text: text, isEntry: true})
+	}
+
+	var appended []Entry
+	for i, e := range entries {
+		if written[i] {
+			continue
+		}
+		if pos, ok := lastOfPolicy[e.Policy]; ok {
+			insertAfter[pos] = append(insertAfter[pos], e)
+		} else {
+			appended = append(appended, e)
+		}
+	}
+
+	var lines []outputLine
+	for pos, line := range out {
+		if pos == entriesEnd {
+			for _, e := range appended {
+				lines = append(lines, outputLine{text: defaultIndent + formatEntry(e), isEntry: true})
+			}
+		}
+		lines = append(lines, line)
+		for _, e := range insertAfter[pos] {
+			lines = append(lines, outputLine{text: indent[pos] + formatEntry(e), isEntry: true})
+		}
+	}
+
+	return joinLines(lines)
+}
+
+// joinLines joins the output, making sure that every entry except for the
+// last one is followed by a comma.
+func joinLines(lines []outputLine) []byte {
+	last := -1
+	for i, line := range lines {
+		if line.isEntry {
+			last = i
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		text := line.text
+		if line.isEntry {
+			text = setTrailingComma(text, i != last)
+		}
+		buf.WriteString(text)
+	}
+	return buf.Bytes()
+}
+
+func leadingWhitespace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+func setTrailingComma(text string, comma bool) string {
+	trimmed := strings.TrimRight(text, " \t\r")
+	suffix := text[len(trimmed):]
+	hasComma := strings.HasSuffix(trimmed, ",")
+
+	switch {
+	case comma && !hasComma:
+		return trimmed + "," + suffix
+	case !comma && hasComma:
+		return strings.TrimSuffix(trimmed, ",") + suffix
+	}
+	return text
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// formatEntry formats an entry on a single line, the same way as the
+// Chromium preload list. Fields with zero values are omitted (except for
+// the name).
+func formatEntry(e Entry) string {
+	fields := []string{`"name": ` + quote(e.Name)}
+	if e.Policy != "" {
+		fields = append(fields, `"policy": `+quote(string(e.Policy)))
+	}
+	if e.Mode != "" {
+		fields = append(fields, `"mode": `+quote(e.Mode))
+	}
+	if e.IncludeSubDomains {
+		fields = append(fields, `"include_subdomains": true`)
+	}
+	if e.IncludeSubDomainsForPinning {
+		fields = append(fields, `"include_subdomains_for_pinning": true`)
+	}
+	if e.Pins != "" {
+		fields = append(fields, `"pins": `+quote(e.Pins))
+	}
+	if e.ExpectCT {
+		fields = append(fields, `"expect_ct": true`)
+	}
+	if e.ExpectCTReportURI != "" {
+		fields = append(fields, `"expect_ct_report_uri": `+quote(e.ExpectCTReportURI))
+	}
+	return "{ " + strings.Join(fields, ", ") + " }"
+}
+
+// formatCanonical formats the list from scratch, without comments.
+func formatCanonical(p PreloadList) []byte {
+	var lines []outputLine
+	lines = append(lines, outputLine{text: "{"})
+
+	if len(p.Pinsets) > 0 {
+		lines = append(lines, outputLine{text: `  "pinsets": [`})
+		for i, pinset := range p.Pinsets {
+			b, _ := json.MarshalIndent(pinset, "    ", "  ")
+			text := "    " + string(b)
+			if i != len(p.Pinsets)-1 {
+				text += ","
+			}
+			lines = append(lines, outputLine{text: text})
+		}
+		lines = append(lines, outputLine{text: "  ],"})
+	}
+
+	lines = append(lines, outputLine{text: `  "entries": [`})
+	for _, e := range p.Entries {
+		lines = append(lines, outputLine{text: defaultEntryIndent + formatEntry(e), isEntry: true})
+	}
+	lines = append(lines, outputLine{text: "  ]"}, outputLine{text: "}"}, outputLine{})
+
+	return joinLines(lines)
+}
+
+// WriteTo writes the list in the JSON format used by the Chromium source,
+// with one entry per line. To keep the comments and ordering of a file that
+// the list was read from, use a Document instead.
+func (p PreloadList) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(formatCanonical(p))
+	return int64(n), err
+}
+
+// WriteFile writes the list to a file using WriteTo.
+func (p PreloadList) WriteFile(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// A Document is a preload list file, together with its formatting, so that
+// the list can be edited and written back out with its comments, blank
+// lines and order of entries intact.
+type Document struct {
+	// List is the parsed list, which can be edited before writing the
+	// document.
+	List PreloadList
+
+	// The formatting of the file that the list was parsed from, if any.
+	layout *layout
+}
+
+// ParseDocument reads a preload list like Parse, and remembers its
+// formatting.
+func ParseDocument(r io.Reader) (*Document, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.New("could not decode body")
+	}
+
+	list, err := Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{List: list, layout: newLayout(raw, list)}, nil
+}
+
+// NewDocumentFromFile reads a Document from a JSON file.
+func NewDocumentFromFile(fileName string) (*Document, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseDocument(f)
+}
+
+// WriteTo writes d.List in the JSON format used by the Chromium source,
+// with one entry per line.
+//
+// If the document was parsed from a file, comments, blank lines and the
+// order of entries are preserved, and unchanged entries are written exactly
+// as they were read. Writing an unmodified document reproduces the original
+// file. Entries that were added to the list are written after the last entry
+// with the same policy. If the pinsets were changed, or the document was not
+// parsed, the list is written from scratch like PreloadList.WriteTo.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if d.layout == nil || !reflect.DeepEqual(d.List.Pinsets, d.layout.pinsets) {
+		return d.List.WriteTo(w)
+	}
+
+	n, err := w.Write(d.layout.format(d.List.Entries))
+	return int64(n), err
+}
+
+// WriteFile writes the document to a file using WriteTo.
+func (d *Document) WriteFile(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
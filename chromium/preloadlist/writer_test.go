@@ -0,0 +1,199 @@
+package preloadlist
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testChromiumJSON follows the layout of transport_security_state_static.json.
+const testChromiumJSON = `// Copyright 2012 The Chromium Authors
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// This file contains the HSTS preloaded list in a machine readable format.
+
+{
+  // Pinsets are named sets of acceptable public keys.
+  "pinsets": [
+    {
+      "name": "test",
+      "static_spki_hashes": [
+        "TestSPKI"
+      ],
+      "report_uri": "http://report-example.test/test"
+    }
+  ],
+
+  "entries": [
+    // Dummy entry to test certificate pinning.
+    { "name": "pinningtest.appspot.com", "policy": "test", "pins": "test" },
+
+    // Google domains.
+    { "name": "google.com", "policy": "google", "mode": "force-https", "include_subdomains": true },
+
+    // START OF BULK ENTRIES
+    { "name": "old.example", "policy": "bulk-legacy", "mode": "force-https", "include_subdomains": true },
+    { "name": "older.example", "policy": "bulk-legacy", "mode": "force-https" },
+    // END OF BULK ENTRIES
+
+    // START OF 1-YEAR BULK HSTS ENTRIES
+    { "name": "a.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+    { "name": "b.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+    // END OF 1-YEAR BULK HSTS ENTRIES
+
+    // Public suffixes.
+    { "name": "dev", "policy": "public-suffix", "mode": "force-https", "include_subdomains": true }
+  ]
+}
+`
+
+func writeToString(t *testing.T, w io.WriterTo) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	for _, input := range []string{testJSON, testPinnedJSON, testChromiumJSON} {
+		doc, err := ParseDocument(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if output := writeToString(t, doc); output != input {
+			t.Errorf("Output does not match input.\n## Actual\n%s\n## Expected\n%s", output, input)
+		}
+	}
+}
+
+func TestWriteModified(t *testing.T) {
+	doc, err := ParseDocument(strings.NewReader(testChromiumJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change one entry, remove the last entry, and add two new entries.
+	list := &doc.List
+	list.Entries[3].IncludeSubDomains = true
+	list.Entries = list.Entries[:len(list.Entries)-1]
+	list.Entries = append(list.Entries,
+		Entry{Name: "c.example", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+		Entry{Name: "new.test", Policy: Test, Mode: ForceHTTPS},
+	)
+
+	expected := strings.Replace(testChromiumJSON,
+		`{ "name": "older.example", "policy": "bulk-legacy", "mode": "force-https" },`,
+		`{ "name": "older.example", "policy": "bulk-legacy", "mode": "force-https", "include_subdomains": true },`, 1)
+	expected = strings.Replace(expected,
+		`    { "name": "b.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+`,
+		`    { "name": "b.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+    { "name": "c.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+`, 1)
+	expected = strings.Replace(expected,
+		`{ "name": "pinningtest.appspot.com", "policy": "test", "pins": "test" },
+`,
+		`{ "name": "pinningtest.appspot.com", "policy": "test", "pins": "test" },
+    { "name": "new.test", "policy": "test", "mode": "force-https" },
+`, 1)
+	expected = strings.Replace(expected,
+		`{ "name": "b.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+    { "name": "c.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },`,
+		`{ "name": "b.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+    { "name": "c.example", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true }`, 1)
+	expected = strings.Replace(expected,
+		`
+    { "name": "dev", "policy": "public-suffix", "mode": "force-https", "include_subdomains": true }`, ``, 1)
+
+	if output := writeToString(t, doc); output != expected {
+		t.Errorf("Output does not match expected.\n## Actual\n%s\n## Expected\n%s", output, expected)
+	}
+}
+
+func TestWriteCanonical(t *testing.T) {
+	list := PreloadList{
+		Pinsets: []Pinset{{Name: "test", StaticSPKIHashes: []string{"TestSPKI"}}},
+		Entries: []Entry{
+			{Name: "example.com", Policy: Bulk1Year, Mode: ForceHTTPS, IncludeSubDomains: true},
+			{Name: "pinned.example", Policy: Custom, Pins: "test", IncludeSubDomainsForPinning: true},
+		},
+	}
+
+	expected := `{
+  "pinsets": [
+    {
+      "name": "test",
+      "static_spki_hashes": [
+        "TestSPKI"
+      ]
+    }
+  ],
+  "entries": [
+    { "name": "example.com", "policy": "bulk-1-year", "mode": "force-https", "include_subdomains": true },
+    { "name": "pinned.example", "policy": "custom", "include_subdomains_for_pinning": true, "pins": "test" }
+  ]
+}
+`
+	output := writeToString(t, list)
+	if output != expected {
+		t.Errorf("Output does not match expected.\n## Actual\n%s\n## Expected\n%s", output, expected)
+	}
+
+	reparsed, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reparsed, list) {
+		t.Errorf("Reparsed list does not match. %#v", reparsed)
+	}
+
+	// Without a parsed layout, a Document is written the same way.
+	if output := writeToString(t, &Document{List: list}); output != expected {
+		t.Errorf("Output does not match expected.\n## Actual\n%s\n## Expected\n%s", output, expected)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	if err := os.WriteFile(input, []byte(testChromiumJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := NewDocumentFromFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(dir, "transport_security_state_static.json")
+	if err := doc.WriteFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != testChromiumJSON {
+		t.Errorf("File does not match input.")
+	}
+
+	// The list alone is written in the canonical format.
+	if err := doc.List.WriteFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = os.ReadFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != writeToString(t, doc.List) {
+		t.Errorf("File does not match the canonical format.")
+	}
+}
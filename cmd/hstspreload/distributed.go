@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chromium/hstspreload/batch"
+)
+
+// Coordinate serves the domains read from stdin to workers, and prints
+// their results.
+func Coordinate(args []string) error {
+	fs := newFlagSet("coordinator")
+	listen := fs.String("listen", "localhost:8080", "address to listen on for workers")
+	shardSize := fs.Int("shard-size", batch.DefaultShardSize, "number of domains in each shard")
+	lease := fs.Duration("lease", batch.DefaultLeaseTimeout, "time after which a shard that has no results is handed out again")
+	linger := fs.Duration("linger", 10*time.Second, "time to keep serving after the scan is complete, so that idle workers exit")
+	store := fs.String("store", "", "also record results in this database file (see the store command)")
+	usage := "hstspreload coordinator [-listen addr] [-shard-size n] [-lease duration] [-linger duration] [-store file] < domains.txt"
+	if err := parseFlags(fs, args, 0, usage); err != nil {
+		return err
+	}
+
+	var domains []string
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		if d := strings.TrimSpace(sc.Text()); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	var db *batch.BoltStore
+	if *store != "" {
+		var err error
+		if db, err = batch.OpenBoltStore(*store); err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	c := batch.NewCoordinator(domains, *shardSize, *lease)
+	server := &http.Server{Handler: c}
+	go server.Serve(l)
+	_, total := c.Progress()
+	fmt.Fprintf(os.Stderr, "Serving %d domains in %d shards on http://%s\n", len(domains), total, l.Addr())
+
+	start := time.Now()
+	enc := json.NewEncoder(os.Stdout)
+	for r := range c.Results() {
+		if err == nil {
+			err = enc.Encode(r)
+		}
+		if err == nil && db != nil {
+			err = db.Put(start, r)
+		}
+	}
+
+	time.Sleep(*linger)
+	server.Shutdown(context.Background())
+	return err
+}
+
+// Work scans shards handed out by a coordinator until the scan is
+// complete.
+func Work(args []string) error {
+	fs := newFlagSet("worker")
+	scanner := scannerFlags(fs)
+	poll := fs.Duration("poll", 5*time.Second, "time to wait before asking again when all shards are leased")
+	usage := "hstspreload worker [-poll duration] " + scannerUsage + " http://coordinator:port"
+	if err := parseFlags(fs, args, 1, usage); err != nil {
+		return err
+	}
+
+	w := &batch.Worker{
+		Coordinator:  fs.Arg(0),
+		Scanner:      scanner,
+		PollInterval: *poll,
+	}
+	return w.Run(context.Background())
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/chromium/hstspreload/chromium/preloadlist"
+)
+
+// errUsage indicates invalid commandline arguments.
+var errUsage = errors.New("invalid arguments")
+
+// runListCommand runs a command that works with preload lists (such as
+// list-diff) and exits with the appropriate return code.
+func runListCommand(command func(args []string) error, args []string) {
+	err := command(args)
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(3)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// newFlagSet creates a FlagSet for a subcommand that reports usage errors
+// as errUsage.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string, nArgs int, usage string) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s\nUsage: %s", errUsage, err, usage)
+	}
+	if fs.NArg() != nArgs {
+		return fmt.Errorf("%w\nUsage: %s", errUsage, usage)
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// ListDiff prints the changes between two preload list files.
+func ListDiff(args []string) error {
+	fs := newFlagSet("list-diff")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	if err := parseFlags(fs, args, 2, "hstspreload list-diff [-json] old.json new.json"); err != nil {
+		return err
+	}
+
+	before, err := preloadlist.NewFromFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	after, err := preloadlist.NewFromFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	changes := preloadlist.Diff(before, after)
+	if *jsonOutput {
+		if changes == nil {
+			changes = []preloadlist.Change{}
+		}
+		return printJSON(changes)
+	}
+
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	return nil
+}
+
+// errLintIssues indicates that list-lint found problems.
+var errLintIssues = errors.New("the list has issues")
+
+// ListLint checks a preload list file for consistency problems.
+func ListLint(args []string) error {
+	fs := newFlagSet("list-lint")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	if err := parseFlags(fs, args, 1, "hstspreload list-lint [-json] list.json"); err != nil {
+		return err
+	}
+
+	list, err := preloadlist.NewFromFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	issues := list.Validate()
+	if *jsonOutput {
+		if issues == nil {
+			issues = []preloadlist.ValidationIssue{}
+		}
+		if err := printJSON(issues); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("%w (%d found)", errLintIssues, len(issues))
+	}
+	return nil
+}
+
+// lookupResult is the JSON output of list-lookup for a single domain.
+type lookupResult struct {
+	Domain string             `json:"domain"`
+	Status string             `json:"status"`
+	Entry  *preloadlist.Entry `json:"entry,omitempty"`
+}
+
+func describeFound(found preloadlist.HstsPreloadEntryFound) string {
+	switch found {
+	case preloadlist.ExactEntryFound:
+		return "exact"
+	case preloadlist.AncestorEntryFound:
+		return "ancestor"
+	}
+	return "not_found"
+}
+
+// ListLookup looks up each domain in a file (one per line, or "-" for
+// stdin) in a preload list file, and prints how it is preloaded.
+func ListLookup(args []string) error {
+	fs := newFlagSet("list-lookup")
+	jsonOutput := fs.Bool("json", false, "output one JSON object per line")
+	if err := parseFlags(fs, args, 2, "hstspreload list-lookup [-json] list.json domains.txt"); err != nil {
+		return err
+	}
+
+	list, err := preloadlist.NewFromFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	idx := list.Index()
+
+	in, err := openInput(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(out)
+	err = idx.GetEach(in, func(domain string, entry preloadlist.Entry, found preloadlist.HstsPreloadEntryFound) error {
+		if *jsonOutput {
+			r := lookupResult{Domain: domain, Status: describeFound(found)}
+			if found != preloadlist.EntryNotFound {
+				r.Entry = &entry
+			}
+			return enc.Encode(r)
+		}
+		_, err := fmt.Fprintf(out, "%s\t%s\t%s\n", domain, describeFound(found), entry.Name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// ListCompile compiles a preload list file into the HSTS trie that Chromium
+// ships, and prints it in the form of transport_security_state_static.h.
+func ListCompile(args []string) error {
+	fs := newFlagSet("list-compile")
+	if err := parseFlags(fs, args, 1, "hstspreload list-compile list.json"); err != nil {
+		return err
+	}
+
+	list, err := preloadlist.NewFromFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	compiled, err := list.Compile()
+	if err != nil {
+		return err
+	}
+	return compiled.WriteHeader(os.Stdout)
+}
+
+// ListFirefoxCompare compares a Chromium preload list file with Firefox's
+// nsSTSPreloadList.inc.
+func ListFirefoxCompare(args []string) error {
+	fs := newFlagSet("list-firefox-compare")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	if err := parseFlags(fs, args, 2, "hstspreload list-firefox-compare [-json] chromium.json nsSTSPreloadList.inc"); err != nil {
+		return err
+	}
+
+	chromium, err := preloadlist.NewFromFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	firefox, err := preloadlist.NewFromFirefoxFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	c := preloadlist.CompareWithFirefox(chromium, firefox)
+	if *jsonOutput {
+		return printJSON(c)
+	}
+
+	fmt.Printf("Only in Chromium (%d):\n", len(c.OnlyChromium))
+	for _, e := range c.OnlyChromium {
+		fmt.Printf("  %s (include_subdomains: %t)\n", e.Name, e.IncludeSubDomains)
+	}
+	fmt.Printf("\nOnly in Firefox (%d):\n", len(c.OnlyFirefox))
+	for _, e := range c.OnlyFirefox {
+		fmt.Printf("  %s (include_subdomains: %t)\n", e.Name, e.IncludeSubDomains)
+	}
+	fmt.Printf("\nDifferent include_subdomains (%d):\n", len(c.IncludeSubDomainsMismatch))
+	for _, m := range c.IncludeSubDomainsMismatch {
+		fmt.Printf("  %s\n", m)
+	}
+	return nil
+}
+
+// History prints the changes to the preload list entry for a domain, using
+// the history of a local Chromium git checkout.
+func History(args []string) error {
+	fs := newFlagSet("history")
+	repo := fs.String("repo", ".", "path to a Chromium git checkout (or mirror)")
+	path := fs.String("path", preloadlist.ChromiumListPath, "path of the preload list in the repository")
+	index := fs.Bool("index", false, "index the changes to all entries (kept in the user's cache directory) to speed up later lookups, which takes a while")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	if err := parseFlags(fs, args, 1, "hstspreload history [-repo path] [-path file] [-index] [-json] domain"); err != nil {
+		return err
+	}
+
+	h, err := preloadlist.NewHistory(*repo, *path)
+	if err != nil {
+		return err
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		h.CacheDir = filepath.Join(dir, "hstspreload")
+	}
+	if *index {
+		if err := h.BuildIndex(); err != nil {
+			return err
+		}
+	}
+
+	events, err := h.Timeline(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		if events == nil {
+			events = []preloadlist.HistoryEvent{}
+		}
+		return printJSON(events)
+	}
+
+	if len(events) == 0 {
+		fmt.Printf("%s has never been on the list.\n", preloadlist.DisplayName(fs.Arg(0)))
+	}
+	for _, e := range events {
+		fmt.Printf("%s  %.12s  %s\n", e.Commit.Time.Format("2006-01-02"), e.Commit.Hash, e.Change)
+	}
+	if skipped := h.Skipped(); len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d versions of the list that could not be parsed.\n", len(skipped))
+	}
+	return nil
+}
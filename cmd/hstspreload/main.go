@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/chromium/hstspreload"
-	"github.com/chromium/hstspreload/batch"
 	"github.com/chromium/hstspreload/chromium/preloadlist"
 )
 
@@ -28,9 +28,70 @@ The commands are:
   removableheader   (-h) Check an HSTS header for removal requirements
   batch                  Check a batch of domains for preload requirements.
                            Reads one domain per line from stdin, and outputs
-                           JSON in non-deterministic domain order.
-  status                 Check the preload status of a domain
+                           JSON in non-deterministic domain order (pass
+                           -ordered to keep the input order). Pass
+                           -ndjson to stream domains from stdin and output
+                           one JSON object per line as results arrive. Pass
+                           -workers, -requests-per-second, -max-per-host (with
+                           -per-subnet) and -timeout to limit the load on
+                           servers, and
+                           -attempts, -backoff and -max-backoff to retry
+                           transient network failures. The scan-* commands
+                           accept the same flags.
+                           Pass -checkpoint file to record results as they
+                           arrive, and -resume to skip the domains already in
+                           that file after an interruption.
+  batch-summary          Summarize the output of batch or scan-* (a file, or
+                           "-" for stdin): totals, issue codes, certificate
+                           issuers and max-age values. Pass -list or -latest to
+                           break down the totals by policy, and -json to output
+                           JSON.
+  batch-compare          Compare two outputs of batch or scan-*, and show the
+                           domains that started failing or have new issues.
+                           Pass -json to output JSON.
+  batch-streaks          Show the domains whose header had max-age=0, no
+                           preload directive, or met the removal requirements
+                           in each of the last -n (default: 3) of the given
+                           scans (oldest first). Pass -json to output JSON.
+  removal-candidates     Scan the entries with the given -policy (default:
+                           bulk-18-weeks and bulk-legacy) against the
+                           requirements of their policy, and track failures
+                           across runs in the -state file. Entries that failed
+                           -min-scans (default: 3) scans in a row over -grace
+                           (default: 2 weeks) are proposed for removal;
+                           unreachable domains are not. Pass -write to write
+                           the edited list, and -json to output JSON.
+  coordinator            Split the domains read from stdin into shards, serve
+                           them to workers over HTTP (-listen, default:
+                           localhost:8080), and output their results as one
+                           JSON object per line. Shards without results after
+                           -lease are handed out again.
+  worker                 Scan the shards served by a coordinator at the given
+                           URL until the scan is complete. Accepts the same
+                           limits as batch.
+  store                  Import the output of batch or scan-* into a result
+                           database, or query the history of results in it
+                           (run "hstspreload store" for the queries). Scans
+                           can also record results directly with -store.
+  status                 Check the preload status of a domain. The latest list
+                           is cached for an hour.
   scan-pending           Scan pending domains from hstspreload.org
+  list-diff              Compare two preload list files. Pass -json to
+                           output JSON.
+  list-lint              Check a preload list file for consistency problems.
+                           Pass -json to output JSON.
+  list-lookup            Look up each domain in a file (or "-" for stdin) in
+                           a preload list file. Pass -json to output one JSON
+                           object per line.
+  list-compile           Compile a preload list file into Chromium's HSTS
+                           trie, and output it as a C++ header.
+  list-firefox-compare   Compare a preload list file with Firefox's
+                           nsSTSPreloadList.inc. Pass -json to output JSON.
+  history                Show when a domain was added to, changed on, or removed
+                           from the list, using a local Chromium checkout
+                           (-repo, default: the current directory). Pass
+                           -index to index the changes to all entries, which
+                           speeds up later lookups.
 
 Examples:
 
@@ -40,6 +101,10 @@ Examples:
   
   echo -e "wikipedia.org\nexample.com" > domains.txt
   cat domains.txt | hstspreload batch
+  hstspreload list-diff old.json new.json
+  hstspreload list-lint transport_security_state_static.json
+  hstspreload history -repo ~/chromium/src example.com
+  hstspreload list-lookup transport_security_state_static.json domains.txt
 
 Return code:
 
@@ -60,23 +125,52 @@ func main() {
 		printHelp()
 	}
 	if args[0] == "scan-pending" {
-		err := ScanPending()
-		if err != nil {
-			fmt.Printf("%s", err)
-			os.Exit(1)
-		}
-		os.Exit(0)
+		runListCommand(ScanPending, args[1:])
 	}
 	if args[0] == "scan-preloaded" {
-		err := ScanPreloaded()
-		if err != nil {
-			fmt.Printf("%s", err)
-			os.Exit(1)
-		}
-		os.Exit(0)
+		runListCommand(ScanPreloaded, args[1:])
 	}
 	if args[0] == "batch" {
-		handleBatch()
+		runListCommand(Batch, args[1:])
+	}
+	if args[0] == "batch-summary" {
+		runListCommand(BatchSummary, args[1:])
+	}
+	if args[0] == "batch-compare" {
+		runListCommand(BatchCompare, args[1:])
+	}
+	if args[0] == "batch-streaks" {
+		runListCommand(BatchStreaks, args[1:])
+	}
+	if args[0] == "removal-candidates" {
+		runListCommand(RemovalCandidates, args[1:])
+	}
+	if args[0] == "coordinator" {
+		runListCommand(Coordinate, args[1:])
+	}
+	if args[0] == "worker" {
+		runListCommand(Work, args[1:])
+	}
+	if args[0] == "store" {
+		runListCommand(Store, args[1:])
+	}
+	if args[0] == "list-diff" {
+		runListCommand(ListDiff, args[1:])
+	}
+	if args[0] == "list-lint" {
+		runListCommand(ListLint, args[1:])
+	}
+	if args[0] == "list-lookup" {
+		runListCommand(ListLookup, args[1:])
+	}
+	if args[0] == "list-compile" {
+		runListCommand(ListCompile, args[1:])
+	}
+	if args[0] == "list-firefox-compare" {
+		runListCommand(ListFirefoxCompare, args[1:])
+	}
+	if args[0] == "history" {
+		runListCommand(History, args[1:])
 	}
 	if len(args) < 2 {
 		printHelp()
@@ -99,13 +193,13 @@ func main() {
 		header, issues = removableDomain(args[1])
 
 	case "status":
-		l, err := preloadlist.NewFromLatest()
+		l, err := latestList()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
 		}
 		idx := l.Index()
-		domain := args[1]
-		state, status := idx.Get(domain)
+		domain := preloadlist.DisplayName(args[1])
+		state, status := idx.Get(args[1])
 		if status == preloadlist.EntryNotFound {
 			fmt.Printf(`%s%s%s is not preloaded.
 
@@ -120,7 +214,7 @@ includeSubDomains: %s%t%s
 
 `,
 				underline, domain, resetFormat,
-				bold, state.Name, resetFormat,
+				bold, preloadlist.DisplayName(state.Name), resetFormat,
 				bold, state.Mode, resetFormat,
 				bold, state.IncludeSubDomains, resetFormat)
 		}
@@ -158,6 +252,24 @@ includeSubDomains: %s%t%s
 	os.Exit(exitCode)
 }
 
+// latestListMaxAge is how long the status command uses a cached copy of
+// the latest list without checking for a new version.
+const latestListMaxAge = time.Hour
+
+// latestList retrieves the latest preload list, caching it in the user's
+// cache directory (if there is one).
+func latestList() (preloadlist.PreloadList, error) {
+	f := preloadlist.Fetcher{MaxAge: latestListMaxAge}
+	if dir, err := os.UserCacheDir(); err == nil {
+		f.CacheDir = filepath.Join(dir, "hstspreload")
+	}
+	list, source, err := f.Fetch(preloadlist.LatestChromiumURL)
+	if source == preloadlist.FetchedFromStaleCache {
+		fmt.Fprintln(os.Stderr, "Warning: could not retrieve the latest preload list; using a cached copy.")
+	}
+	return list, err
+}
+
 func preloadableHeader(header string) (issues hstspreload.Issues) {
 	warnIfNotHeader(header)
 
@@ -183,7 +295,7 @@ func preloadableDomain(domain string) (header *string, issues hstspreload.Issues
 
 	fmt.Printf(
 		"Checking domain %s%s%s for preload requirements...\n",
-		underline, domain, resetFormat)
+		underline, preloadlist.DisplayName(domain), resetFormat)
 
 	return hstspreload.PreloadableDomain(domain)
 }
@@ -193,7 +305,7 @@ func removableDomain(domain string) (header *string, issues hstspreload.Issues)
 
 	fmt.Printf(
 		"Checking domain %s%s%s for removal requirements...\n",
-		underline, domain, resetFormat)
+		underline, preloadlist.DisplayName(domain), resetFormat)
 
 	return hstspreload.RemovableDomain(domain)
 }
@@ -256,22 +368,3 @@ func printList(list []hstspreload.Issue, title string, fs string) {
 
 	fmt.Println()
 }
-
-func handleBatch() {
-	var domains []string
-	sc := bufio.NewScanner(os.Stdin)
-	for sc.Scan() {
-		domains = append(domains, sc.Text())
-	}
-	if err := sc.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "%s", err)
-		os.Exit(1)
-	}
-
-	err := batch.Print(domains)
-	if err != nil {
-		os.Exit(1)
-	}
-
-	os.Exit(0)
-}
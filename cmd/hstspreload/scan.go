@@ -1,41 +1,202 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/chromium/hstspreload"
 	"github.com/chromium/hstspreload/batch"
 	"github.com/chromium/hstspreload/chromium/preloadlist"
 )
 
-// ScanPending scans all pending submitted domains.
-func ScanPending() error {
-	domains, err := pendingDomains()
+// scannerFlags defines flags for the limits of a batch.Scanner, with
+// batch.DefaultScanner's settings as defaults.
+func scannerFlags(fs *flag.FlagSet) *batch.Scanner {
+	s := *batch.DefaultScanner
+	fs.IntVar(&s.Workers, "workers", s.Workers, "number of domains to check in parallel")
+	fs.Float64Var(&s.RequestsPerSecond, "requests-per-second", s.RequestsPerSecond, "maximum number of network requests per second, across all workers (0 for no limit)")
+	fs.IntVar(&s.MaxPerHost, "max-per-host", s.MaxPerHost, "maximum number of domains on the same IP address to check at once (0 for no limit)")
+	fs.BoolVar(&s.GroupBySubnet, "per-subnet", s.GroupBySubnet, "apply -max-per-host to each /24 (IPv4) or /64 (IPv6) network")
+	fs.DurationVar(&s.Timeout, "timeout", s.Timeout, "maximum time to spend on each domain (0 for no limit)")
+
+	retry := hstspreload.DefaultRetryPolicy
+	if s.Retry != nil {
+		retry = *s.Retry
+	}
+	s.Retry = &retry
+	fs.IntVar(&retry.MaxAttempts, "attempts", retry.MaxAttempts, "maximum number of attempts of each network operation that fails with a transient error")
+	fs.DurationVar(&retry.InitialBackoff, "backoff", retry.InitialBackoff, "delay before the first retry, doubled for each further retry")
+	fs.DurationVar(&retry.MaxBackoff, "max-backoff", retry.MaxBackoff, "maximum delay between retries (0 for no limit)")
+	return &s
+}
+
+// scannerUsage describes the flags defined by scannerFlags.
+const scannerUsage = "[-workers n] [-requests-per-second n] [-max-per-host n [-per-subnet]] [-timeout duration] [-attempts n [-backoff duration] [-max-backoff duration]]"
+
+// scanOptions holds the flags shared by the scanning commands.
+type scanOptions struct {
+	scanner    *batch.Scanner
+	ndjson     bool
+	checkpoint string
+	resume     bool
+	store      string
+}
+
+// scanFlags defines the flags shared by the scanning commands.
+func scanFlags(fs *flag.FlagSet) *scanOptions {
+	o := &scanOptions{scanner: scannerFlags(fs)}
+	fs.BoolVar(&o.scanner.Ordered, "ordered", false, "output results in the same order as the input")
+	fs.BoolVar(&o.ndjson, "ndjson", false, "output one JSON object per line, as results become available")
+	fs.StringVar(&o.checkpoint, "checkpoint", "", "record results in this file, so that the scan can be resumed")
+	fs.BoolVar(&o.resume, "resume", false, "skip the domains that already have results in the -checkpoint file")
+	fs.StringVar(&o.store, "store", "", "also record results in this database file (see the store command)")
+	return o
+}
+
+// scanUsage describes the flags defined by scanFlags.
+const scanUsage = "[-ndjson] [-ordered] [-checkpoint file [-resume]] [-store file] " + scannerUsage
+
+// errorRecordingStore is a batch.Store that remembers the first error
+// returned by Put, since the scanner ignores them.
+type errorRecordingStore struct {
+	batch.Store
+	mu  sync.Mutex
+	err error
+}
+
+func (s *errorRecordingStore) Put(t time.Time, results ...batch.Result) error {
+	err := s.Store.Put(t, results...)
 	if err != nil {
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// run opens the checkpoint and store (if any) and calls `scan` with the
+// configured scanner.
+func (o *scanOptions) run(scan func(s *batch.Scanner) error) (err error) {
+	if o.resume && o.checkpoint == "" {
+		return fmt.Errorf("%w: -resume requires -checkpoint", errUsage)
+	}
+
+	if o.checkpoint != "" {
+		var c *batch.Checkpoint
+		c, err = batch.OpenCheckpoint(o.checkpoint, o.resume)
+		if err != nil {
+			return err
+		}
+		if o.resume {
+			fmt.Fprintf(os.Stderr, "Resuming with %d domains already scanned.\n", c.Len())
+		}
+		o.scanner.Checkpoint = c
+		defer func() {
+			if closeErr := c.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	if o.store != "" {
+		var db *batch.BoltStore
+		db, err = batch.OpenBoltStore(o.store)
+		if err != nil {
+			return err
+		}
+		store := &errorRecordingStore{Store: db}
+		o.scanner.Store = store
+		defer func() {
+			closeErr := db.Close()
+			if err == nil {
+				err = store.err
+			}
+			if err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	return scan(o.scanner)
+}
+
+// print scans the given domains and prints the results.
+func (o *scanOptions) print(domains []string) error {
+	return o.run(func(s *batch.Scanner) error {
+		if o.ndjson {
+			return s.FprintNDJSON(os.Stdout, domains)
+		}
+		return s.Fprint(os.Stdout, domains)
+	})
+}
+
+// Batch scans the domains read from stdin, one per line.
+func Batch(args []string) error {
+	fs := newFlagSet("batch")
+	opts := scanFlags(fs)
+	if err := parseFlags(fs, args, 0, "hstspreload batch "+scanUsage+" < domains.txt"); err != nil {
+		return err
+	}
+
+	if opts.ndjson {
+		return opts.run(func(s *batch.Scanner) error {
+			return s.StreamNDJSON(os.Stdout, os.Stdin)
+		})
+	}
+
+	var domains []string
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		domains = append(domains, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
 		return err
 	}
 
-	err = batch.Print(domains)
+	return opts.print(domains)
+}
+
+// ScanPending scans all pending submitted domains.
+func ScanPending(args []string) error {
+	fs := newFlagSet("scan-pending")
+	opts := scanFlags(fs)
+	if err := parseFlags(fs, args, 0, "hstspreload scan-pending "+scanUsage); err != nil {
+		return err
+	}
+
+	domains, err := pendingDomains()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return opts.print(domains)
 }
 
 // ScanPreloaded scans all preloaded domains.
-func ScanPreloaded() error {
-	domains, err := preloadedDomains()
-	if err != nil {
+func ScanPreloaded(args []string) error {
+	fs := newFlagSet("scan-preloaded")
+	opts := scanFlags(fs)
+	if err := parseFlags(fs, args, 0, "hstspreload scan-preloaded "+scanUsage); err != nil {
 		return err
 	}
 
-	err = batch.Print(domains)
+	domains, err := preloadedDomains()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return opts.print(domains)
 }
 
 // PendingDomains gets the list of pending domains from the submission site.
@@ -73,3 +234,270 @@ func preloadedDomains() ([]string, error) {
 
 	return domains, nil
 }
+
+// openInput opens a file, or returns stdin if `name` is "-".
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// BatchSummary summarizes the results of a batch scan.
+func BatchSummary(args []string) error {
+	fs := newFlagSet("batch-summary")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	listFile := fs.String("list", "", "preload list file, to break down the results by policy")
+	latest := fs.Bool("latest", false, "break down the results by policy using the latest preload list")
+	if err := parseFlags(fs, args, 1, "hstspreload batch-summary [-json] [-list list.json | -latest] results.json"); err != nil {
+		return err
+	}
+
+	var idx *preloadlist.IndexedEntries
+	if *listFile != "" || *latest {
+		var list preloadlist.PreloadList
+		var err error
+		if *latest {
+			list, err = latestList()
+		} else {
+			list, err = preloadlist.NewFromFile(*listFile)
+		}
+		if err != nil {
+			return err
+		}
+		i := list.Index()
+		idx = &i
+	}
+
+	in, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	summary := batch.NewSummary(idx)
+	err = batch.ReadResults(in, func(r batch.Result) error {
+		summary.Add(r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		return printJSON(summary)
+	}
+	summary.Fprint(os.Stdout)
+	return nil
+}
+
+// readResultsFile calls `fn` for each result in a file of batch results
+// (or "-" for stdin).
+func readResultsFile(name string, fn func(batch.Result) error) error {
+	in, err := openInput(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := batch.ReadResults(in, fn); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// batchComparison is the JSON output of BatchCompare.
+type batchComparison struct {
+	Regressions   []batch.Regression            `json:"regressions"`
+	NewIssueCodes map[hstspreload.IssueCode]int `json:"new_issue_codes"`
+}
+
+// BatchCompare prints the domains whose results regressed between two
+// batch scans.
+func BatchCompare(args []string) error {
+	fs := newFlagSet("batch-compare")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	if err := parseFlags(fs, args, 2, "hstspreload batch-compare [-json] old.json new.json"); err != nil {
+		return err
+	}
+
+	baseline := batch.NewBaseline()
+	err := readResultsFile(fs.Arg(0), func(r batch.Result) error {
+		baseline.Add(r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return printComparison(baseline, fs.Arg(1), *jsonOutput)
+}
+
+// printComparison prints the domains of the results file `name` that
+// regressed compared to `baseline`.
+func printComparison(baseline *batch.Baseline, name string, jsonOutput bool) error {
+	c := batchComparison{
+		Regressions:   []batch.Regression{},
+		NewIssueCodes: make(map[hstspreload.IssueCode]int),
+	}
+	err := readResultsFile(name, func(r batch.Result) error {
+		if reg, ok := baseline.Compare(r); ok {
+			c.Regressions = append(c.Regressions, reg)
+			for _, code := range reg.NewIssueCodes {
+				c.NewIssueCodes[code]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(c.Regressions, func(i, j int) bool {
+		return c.Regressions[i].Domain < c.Regressions[j].Domain
+	})
+
+	if jsonOutput {
+		return printJSON(c)
+	}
+	for _, reg := range c.Regressions {
+		var parts []string
+		if reg.NewlyFailing {
+			parts = append(parts, "now failing")
+		}
+		for _, code := range reg.NewIssueCodes {
+			parts = append(parts, string(code))
+		}
+		fmt.Printf("%s: %s\n", reg.Domain, strings.Join(parts, ", "))
+	}
+	if len(c.Regressions) == 0 {
+		fmt.Println("No regressions.")
+	}
+	return nil
+}
+
+// BatchStreaks prints the domains that showed a removal signal in each of
+// the last few scans.
+func BatchStreaks(args []string) error {
+	fs := newFlagSet("batch-streaks")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	n := fs.Int("n", 3, "minimum number of consecutive scans")
+	usage := "hstspreload batch-streaks [-json] [-n scans] scan1.json scan2.json ... (oldest first)"
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s\nUsage: %s", errUsage, err, usage)
+	}
+	if fs.NArg() < 1 || *n < 1 {
+		return fmt.Errorf("%w\nUsage: %s", errUsage, usage)
+	}
+
+	tracker := batch.NewStreakTracker()
+	for _, name := range fs.Args() {
+		err := readResultsFile(name, func(r batch.Result) error {
+			tracker.Add(r)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		tracker.EndScan()
+	}
+
+	return printStreaks(tracker.Streaks(*n), *jsonOutput)
+}
+
+func printStreaks(streaks []batch.Streak, jsonOutput bool) error {
+	if jsonOutput {
+		if streaks == nil {
+			streaks = []batch.Streak{}
+		}
+		return printJSON(streaks)
+	}
+	for _, s := range streaks {
+		fmt.Printf("%s\t%s\t%d scans\n", s.Domain, s.Signal, s.Scans)
+	}
+	return nil
+}
+
+// RemovalCandidates scans the preload list entries with some policies, and
+// proposes to remove the ones that have stopped meeting the requirements
+// of their policy.
+func RemovalCandidates(args []string) error {
+	fs := newFlagSet("removal-candidates")
+	scanner := scannerFlags(fs)
+	listFile := fs.String("list", "", "preload list file (default: the latest list)")
+	policies := fs.String("policy", preloadlist.Bulk18Weeks+","+preloadlist.BulkLegacy, "comma-separated policies of the entries to scan")
+	stateFile := fs.String("state", "", "file that keeps track of failing domains between runs")
+	minScans := fs.Int("min-scans", batch.DefaultRemovalMinScans, "number of consecutive failing scans before proposing a removal")
+	grace := fs.Duration("grace", batch.DefaultRemovalGracePeriod, "minimum time between the first failing scan and proposing a removal (negative for none)")
+	writeFile := fs.String("write", "", "write the list without the proposed entries to this file")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	usage := "hstspreload removal-candidates -state state.json [-min-scans n] [-grace duration] [-list list.json] [-policy p1,p2] [-write out.json] [-json] " + scannerUsage
+	if err := parseFlags(fs, args, 0, usage); err != nil {
+		return err
+	}
+	// Without a state, every run would start over and no entry could fail
+	// for long enough.
+	if *stateFile == "" {
+		return fmt.Errorf("%w: -state is required\nUsage: %s", errUsage, usage)
+	}
+
+	pipeline := batch.RemovalPipeline{
+		Scanner:     scanner,
+		MinScans:    *minScans,
+		GracePeriod: *grace,
+	}
+	for _, p := range strings.Split(*policies, ",") {
+		pipeline.Policies = append(pipeline.Policies, preloadlist.PolicyType(strings.TrimSpace(p)))
+	}
+
+	// A list read from a file is written back with its formatting.
+	doc := &preloadlist.Document{}
+	var err error
+	if *listFile != "" {
+		doc, err = preloadlist.NewDocumentFromFile(*listFile)
+	} else {
+		doc.List, err = latestList()
+	}
+	if err != nil {
+		return err
+	}
+
+	state, err := batch.LoadRemovalState(*stateFile)
+	if err != nil {
+		return err
+	}
+
+	proposal, err := pipeline.Run(doc.List, state)
+	if err != nil {
+		return err
+	}
+	if err := state.Save(*stateFile); err != nil {
+		return err
+	}
+	if *writeFile != "" {
+		if err := proposal.Apply(&doc.List); err != nil {
+			return err
+		}
+		if err := doc.WriteFile(*writeFile); err != nil {
+			return err
+		}
+	}
+
+	if *jsonOutput {
+		return printJSON(proposal)
+	}
+	fmt.Printf("Scanned %d entries, %d failing, %d unreachable, %d proposed for removal.\n",
+		proposal.Scanned, proposal.Failing, proposal.Unreachable, len(proposal.Candidates))
+	for _, c := range proposal.Candidates {
+		var codes []string
+		for _, issue := range c.Result.Issues.Errors {
+			codes = append(codes, string(issue.Code))
+		}
+		fmt.Printf("%s (%s): failing since %s (%d scans): %s\n",
+			preloadlist.DisplayName(c.Entry.Name), c.Entry.Policy,
+			c.FirstFailed.Format("2006-01-02"), c.FailedScans, strings.Join(codes, ", "))
+	}
+	for _, s := range proposal.Skipped {
+		fmt.Printf("%s (%s): skipped, cannot be removed automatically: %s\n",
+			s.Entry.Name, s.Entry.Policy, s.Error)
+	}
+	return nil
+}
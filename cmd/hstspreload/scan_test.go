@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/batch"
+)
+
+func TestScannerFlags(t *testing.T) {
+	fs := newFlagSet("test")
+	s := scannerFlags(fs)
+	err := parseFlags(fs, []string{
+		"-workers", "7",
+		"-requests-per-second", "2.5",
+		"-max-per-host", "3",
+		"-per-subnet",
+		"-timeout", "30s",
+		"-attempts", "4",
+		"-backoff", "1s",
+		"-max-backoff", "8s",
+	}, 0, "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Workers != 7 || s.RequestsPerSecond != 2.5 || s.MaxPerHost != 3 || !s.GroupBySubnet || s.Timeout != 30*time.Second {
+		t.Errorf("Unexpected scanner: %#v", s)
+	}
+	if s.Retry.MaxAttempts != 4 || s.Retry.InitialBackoff != time.Second || s.Retry.MaxBackoff != 8*time.Second {
+		t.Errorf("Unexpected retry policy: %#v", s.Retry)
+	}
+
+	// The defaults are those of batch.DefaultScanner, which is not
+	// modified.
+	fs = newFlagSet("test")
+	s = scannerFlags(fs)
+	if err := parseFlags(fs, nil, 0, "usage"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Workers != batch.DefaultScanner.Workers || s.Retry.MaxAttempts != hstspreload.DefaultRetryPolicy.MaxAttempts {
+		t.Errorf("Unexpected defaults: %#v", s)
+	}
+	if s == batch.DefaultScanner || s.Retry == &hstspreload.DefaultRetryPolicy {
+		t.Errorf("Flags must not modify batch.DefaultScanner or hstspreload.DefaultRetryPolicy")
+	}
+}
+
+func TestScanOptionsResumeRequiresCheckpoint(t *testing.T) {
+	fs := newFlagSet("test")
+	o := scanFlags(fs)
+	if err := parseFlags(fs, []string{"-resume"}, 0, "usage"); err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	err := o.run(func(*batch.Scanner) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, errUsage) || called {
+		t.Errorf("Expected a usage error without scanning, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromium/hstspreload"
+	"github.com/chromium/hstspreload/batch"
+)
+
+// importChunkSize is the number of results written to the store in each
+// transaction when importing a file.
+const importChunkSize = 1000
+
+// describeStoredResult describes a stored result in a single line.
+func describeStoredResult(sr batch.StoredResult) string {
+	outcome := "passed"
+	switch {
+	case len(sr.Result.Issues.Errors) > 0:
+		outcome = "errors"
+	case len(sr.Result.Issues.Warnings) > 0:
+		outcome = "warnings"
+	}
+	var codes []string
+	for _, list := range [][]hstspreload.Issue{sr.Result.Issues.Errors, sr.Result.Issues.Warnings} {
+		for _, issue := range list {
+			codes = append(codes, string(issue.Code))
+		}
+	}
+	return fmt.Sprintf("%s  %-8s  %s", sr.Time.Format(time.RFC3339), outcome, strings.Join(codes, ", "))
+}
+
+// Store imports results into a result store, or queries it.
+func Store(args []string) error {
+	fs := newFlagSet("store")
+	jsonOutput := fs.Bool("json", false, "output JSON")
+	at := fs.String("time", "", "time of the imported scan, or of the compared scan, in RFC 3339 format (default: now)")
+	usage := `hstspreload store [-json] [-time t] store.db command argument
+
+Commands:
+  import results.json       Record the output of batch or scan-*.
+  latest domain             Show the most recent result for a domain.
+  history domain            Show all results for a domain.
+  issue code                List the domains whose most recent result has an issue code.
+  failing-since domain      Show since when a domain has been failing.
+  compare results.json      Show the domains whose results regressed since their most
+                            recent result recorded before -time.
+  streaks n                 Show the domains whose header had max-age=0, no preload
+                            directive, or met the removal requirements in each of
+                            their n most recent results.`
+	if err := parseFlags(fs, args, 3, usage); err != nil {
+		return err
+	}
+
+	store, err := batch.OpenBoltStore(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	scanTime := time.Now()
+	if *at != "" {
+		if scanTime, err = time.Parse(time.RFC3339, *at); err != nil {
+			return fmt.Errorf("%w: -time: %s", errUsage, err)
+		}
+	}
+
+	arg := fs.Arg(2)
+	switch fs.Arg(1) {
+	case "import":
+		var chunk []batch.Result
+		count := 0
+		err := readResultsFile(arg, func(r batch.Result) error {
+			chunk = append(chunk, r)
+			count++
+			if len(chunk) < importChunkSize {
+				return nil
+			}
+			err := store.Put(scanTime, chunk...)
+			chunk = chunk[:0]
+			return err
+		})
+		if err == nil && len(chunk) > 0 {
+			err = store.Put(scanTime, chunk...)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d results.\n", count)
+		return nil
+
+	case "latest":
+		sr, found, err := store.Latest(arg)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no results for %s", arg)
+		}
+		if *jsonOutput {
+			return printJSON(sr)
+		}
+		fmt.Println(describeStoredResult(sr))
+		return nil
+
+	case "history":
+		history, err := store.History(arg)
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			if history == nil {
+				history = []batch.StoredResult{}
+			}
+			return printJSON(history)
+		}
+		for _, sr := range history {
+			fmt.Println(describeStoredResult(sr))
+		}
+		return nil
+
+	case "issue":
+		domains, err := store.WithIssue(hstspreload.IssueCode(arg))
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			if domains == nil {
+				domains = []string{}
+			}
+			return printJSON(domains)
+		}
+		for _, d := range domains {
+			fmt.Println(d)
+		}
+		return nil
+
+	case "failing-since":
+		since, failing, err := store.FirstFailing(arg)
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			var t *time.Time
+			if failing {
+				t = &since
+			}
+			return printJSON(struct {
+				Domain       string     `json:"domain"`
+				FailingSince *time.Time `json:"failing_since"`
+			}{arg, t})
+		}
+		if failing {
+			fmt.Printf("%s has been failing since %s.\n", arg, since.Format(time.RFC3339))
+		} else {
+			fmt.Printf("%s is not failing.\n", arg)
+		}
+		return nil
+
+	case "compare":
+		baseline, err := batch.NewBaselineFromStore(store, scanTime)
+		if err != nil {
+			return err
+		}
+		return printComparison(baseline, arg, *jsonOutput)
+
+	case "streaks":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%w: invalid number of scans %q\nUsage: %s", errUsage, arg, usage)
+		}
+		streaks, err := batch.StoreStreaks(store, n)
+		if err != nil {
+			return err
+		}
+		return printStreaks(streaks, *jsonOutput)
+	}
+
+	return fmt.Errorf("%w: unknown store command %q\nUsage: %s", errUsage, fs.Arg(1), usage)
+}
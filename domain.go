@@ -1,7 +1,10 @@
 package hstspreload
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"net"
 	"net/http"
 	"strings"
@@ -38,9 +41,12 @@ var allowedWWWeTLDs = map[string]bool{
 // - Serving a single HSTS header that passes header requirements.
 //
 // - Using TLS settings that will not cause new problems for
-// Chromium/Chrome users. (Example of a new problem: a missing intermediate certificate
-// will turn an error page from overrideable to non-overridable on
-// some mobile devices.)
+// Chromium/Chrome users. (Once a site is preloaded, certificate errors
+// cannot be bypassed. To check the certificate chain against the roots
+// trusted on specific platforms, use EligibleDomainResponseWithTrustStores.)
+//
+// Internationalized domains may be given in Unicode (e.g. `bücher.example`),
+// and are checked using their A-labels.
 //
 // Iff a single HSTS header was received, `header` contains its value, else
 // `header` is `nil`.
@@ -62,12 +68,61 @@ func EligibleDomain(domain string, policy preloadlist.PolicyType) (header *strin
 // EligibleDomainResponse is like EligibleDomain, but also returns
 // the initial response over HTTPS.
 func EligibleDomainResponse(domain string, policy preloadlist.PolicyType) (header *string, issues Issues, resp *http.Response) {
+	return EligibleDomainResponseWithTrustStores(domain, policy, nil)
+}
+
+// EligibleDomainResponseWithTrustStores is like EligibleDomainResponse,
+// but also verifies the certificate chain served by the domain against each
+// of `stores`. An error is reported for every store that does not trust
+// the chain.
+func EligibleDomainResponseWithTrustStores(domain string, policy preloadlist.PolicyType, stores []TrustStore) (header *string, issues Issues, resp *http.Response) {
+	header, issues, resp, _ = EligibleDomainResponseWithOptions(domain, policy, CheckOptions{TrustStores: stores})
+	return header, issues, resp
+}
+
+// CheckOptions configure EligibleDomainResponseWithOptions.
+type CheckOptions struct {
+	// TrustStores are verified to trust the certificate chain served by
+	// the domain, as with EligibleDomainResponseWithTrustStores.
+	TrustStores []TrustStore
+	// Retry controls how network operations that fail with a transient
+	// error are retried. If nil, DefaultRetryPolicy is used.
+	Retry *RetryPolicy
+	// RequestLimiter, if set, is waited for before each network request
+	// of the check, including each redirect that is followed.
+	RequestLimiter *RequestLimiter
+	// Context, if set, stops the check from making new network requests
+	// once it is done. Requests that fail this way are reported like
+	// other network errors.
+	Context context.Context
+	// FetchAIA allows fetching missing intermediate certificates from the
+	// Authority Information Access URLs of the served certificates, to
+	// diagnose an invalid chain. The URLs are chosen by the site, so
+	// only http and https URLs on public addresses are fetched.
+	FetchAIA bool
+}
+
+// CheckDetails describe how a check went, beyond its issues.
+type CheckDetails struct {
+	// Attempts is the number of network attempts made by each operation of
+	// the check.
+	Attempts Attempts
+	// TLSFeatures describe the initial HTTPS connection, if it could be
+	// made.
+	TLSFeatures TLSFeatures
+}
+
+// EligibleDomainResponseWithOptions is like EligibleDomainResponse, but
+// takes options for the checks. It also returns details of the check.
+func EligibleDomainResponseWithOptions(domain string, policy preloadlist.PolicyType, opts CheckOptions) (header *string, issues Issues, resp *http.Response, details CheckDetails) {
 	// Check domain format issues first, since we can report something
 	// useful even if the other checks fail.
 	issues = combineIssues(issues, checkDomainFormat(domain))
 	if len(issues.Errors) > 0 {
-		return header, issues, nil
+		return header, issues, nil, details
 	}
+	// The format check guarantees that this succeeds.
+	domain, _ = preloadlist.ToASCII(domain)
 
 	// We don't currently allow automatic submissions of subdomains.
 	levelIssues := preloadableDomainLevel(domain)
@@ -75,11 +130,17 @@ func EligibleDomainResponse(domain string, policy preloadlist.PolicyType) (heade
 
 	// Start with an initial probe, and don't do the follow-up checks if
 	// we can't connect.
-	resp, respIssues := getResponse(domain)
+	r := newRetrier(opts, &details.Attempts.HTTPS)
+	resp, respIssues := getResponse(domain, r)
 	issues = combineIssues(issues, respIssues)
 	if len(respIssues.Errors) == 0 {
 		issues = combineIssues(issues, checkChain(*resp.TLS))
+		issues = combineIssues(issues, checkTrustStores(domain, resp.TLS.PeerCertificates, opts.TrustStores, r.chainVerifier()))
 		issues = combineIssues(issues, checkCipherSuite(*resp.TLS))
+		features, ocspErr := tlsFeaturesAt(*resp.TLS, time.Now())
+		details.TLSFeatures = features
+		issues = combineIssues(issues, checkCertificateTransparency(features))
+		issues = combineIssues(issues, checkOCSPStapling(features, ocspErr))
 
 		preloadableResponse := make(chan Issues)
 		httpRedirectsGeneral := make(chan Issues)
@@ -96,14 +157,14 @@ func EligibleDomainResponse(domain string, policy preloadlist.PolicyType) (heade
 
 		// checkHTTPRedirects
 		go func() {
-			general, firstRedirectHSTS := preloadableHTTPRedirects(domain)
+			general, firstRedirectHSTS := preloadableHTTPRedirects(domain, newRetrier(opts, &details.Attempts.HTTPRedirects))
 			httpRedirectsGeneral <- general
 			httpFirstRedirectHSTS <- firstRedirectHSTS
 		}()
 
 		// checkHTTPSRedirects
 		go func() {
-			httpsRedirects <- preloadableHTTPSRedirects(domain)
+			httpsRedirects <- preloadableHTTPSRedirects(domain, newRetrier(opts, &details.Attempts.HTTPSRedirects))
 		}()
 
 		// checkWWW
@@ -115,7 +176,7 @@ func EligibleDomainResponse(domain string, policy preloadlist.PolicyType) (heade
 			if len(levelIssues.Errors) != 0 || allowedWWWeTLDs[eTLD] {
 				www <- Issues{}
 			} else {
-				www <- checkWWW(domain)
+				www <- checkWWW(domain, newRetrier(opts, &details.Attempts.WWW))
 			}
 		}()
 
@@ -134,7 +195,7 @@ func EligibleDomainResponse(domain string, policy preloadlist.PolicyType) (heade
 		issues = combineIssues(issues, <-www)
 	}
 
-	return header, issues, resp
+	return header, issues, resp, details
 }
 
 // RemovableDomain checks whether the domain satisfies the requirements
@@ -149,7 +210,10 @@ func EligibleDomainResponse(domain string, policy preloadlist.PolicyType) (heade
 // To interpret `issues`, see the list of conventions in the
 // documentation for Issues.
 func RemovableDomain(domain string) (header *string, issues Issues) {
-	resp, respIssues := getResponse(domain)
+	if ascii, err := preloadlist.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+	resp, respIssues := getResponse(domain, newRetrier(CheckOptions{}, new(int)))
 	issues = combineIssues(issues, respIssues)
 	if len(respIssues.Errors) == 0 {
 		var removableIssues Issues
@@ -160,33 +224,32 @@ func RemovableDomain(domain string) (header *string, issues Issues) {
 	return header, issues
 }
 
-func getResponse(domain string) (*http.Response, Issues) {
+// getResponse makes the initial HTTPS request to the domain, retrying
+// transient failures with `r`.
+func getResponse(domain string, r *retrier) (*http.Response, Issues) {
 	issues := Issues{}
 
-	// Try #1
-	resp, err := getFirstResponse("https://" + domain)
-	if err == nil {
-		return resp, issues
-	}
-
-	// Try #2
-	resp, err = getFirstResponse("https://" + domain)
+	var resp *http.Response
+	err := r.do(func() (err error) {
+		resp, err = getFirstResponse("https://" + domain)
+		return err
+	})
 	if err == nil {
 		return resp, issues
 	}
 
 	// Check if ignoring cert issues works.
 	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	resp, err = getFirstResponseWithTransport("https://"+domain, transport)
+	err = r.do(func() (err error) {
+		resp, err = getFirstResponseWithTransport("https://"+domain, transport)
+		return err
+	})
 	if err == nil {
-		return resp, issues.addErrorf(
-			IssueCode("domain.tls.invalid_cert_chain"),
-			"Invalid Certificate Chain",
-			"https://%s uses an incomplete or "+
-				"invalid certificate chain. Check out your site at "+
-				"https://www.ssllabs.com/ssltest/",
-			domain,
-		)
+		var certs []*x509.Certificate
+		if resp.TLS != nil {
+			certs = resp.TLS.PeerCertificates
+		}
+		return resp, combineIssues(issues, r.chainVerifier().checkInvalidChain(domain, certs))
 	}
 
 	return resp, issues.addErrorf(
@@ -220,6 +283,29 @@ func checkDomainFormat(domain string) Issues {
 			"Please provide a domain that does not contain `..`")
 	}
 
+	// Check internationalized domains using their A-labels (Punycode).
+	ascii, err := preloadlist.ToASCII(domain)
+	switch {
+	case errors.Is(err, preloadlist.ErrInvalidPunycode):
+		return issues.addErrorf(
+			IssueCode("domain.format.idn.invalid_punycode"),
+			"Invalid internationalized domain name",
+			"The domain contains a label starting with `xn--` that is not valid Punycode.")
+	case errors.Is(err, preloadlist.ErrDisallowedCharacter):
+		return issues.addErrorf(
+			IssueCode("domain.format.idn.disallowed_characters"),
+			"Invalid internationalized domain name",
+			"The domain contains characters that are not allowed in internationalized domain names (%s).",
+			err)
+	case err != nil:
+		return issues.addErrorf(
+			IssueCode("domain.format.idn.invalid"),
+			"Invalid internationalized domain name",
+			"The domain is not a valid internationalized domain name (%s).",
+			err)
+	}
+	domain = ascii
+
 	ps, _ := publicsuffix.PublicSuffix(domain)
 	if ps == domain {
 		return issues.addErrorf(
@@ -233,7 +319,6 @@ func checkDomainFormat(domain string) Issues {
 				"please see https://hstspreload.org/#tld")
 	}
 
-	domain = strings.ToLower(domain)
 	for _, r := range domain {
 		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
 			continue
@@ -277,11 +362,16 @@ func preloadableDomainLevel(domain string) Issues {
 	return issues
 }
 
-func checkWWW(host string) Issues {
+func checkWWW(host string, r *retrier) Issues {
 	issues := Issues{}
 
 	hasWWW := false
-	if conn, err := net.DialTimeout("tcp", "www."+host+":443", dialTimeout); err == nil {
+	var conn net.Conn
+	err := r.do(func() (err error) {
+		conn, err = net.DialTimeout("tcp", "www."+host+":443", dialTimeout)
+		return err
+	})
+	if err == nil {
 		hasWWW = true
 		if err = conn.Close(); err != nil {
 			return issues.addErrorf(
@@ -295,7 +385,11 @@ func checkWWW(host string) Issues {
 	}
 
 	if hasWWW {
-		wwwConn, err := tls.DialWithDialer(&dialer, "tcp", "www."+host+":443", nil)
+		var wwwConn *tls.Conn
+		err := r.do(func() (err error) {
+			wwwConn, err = tls.DialWithDialer(&dialer, "tcp", "www."+host+":443", nil)
+			return err
+		})
 		if err != nil {
 			return issues.addErrorf(
 				IssueCode("domain.www.no_tls"),
@@ -41,6 +41,24 @@ var testCheckDomainFormatTests = []struct {
 	{"1.1.1.1",
 		Issues{Errors: []Issue{{Code: "domain.format.is_ip_address"}}},
 	},
+	{"bücher.de",
+		Issues{},
+	},
+	{"xn--bcher-kva.de",
+		Issues{},
+	},
+	{"xn--bcher-kva!.de",
+		Issues{Errors: []Issue{{Code: "domain.format.idn.invalid_punycode"}}},
+	},
+	{"bü\u0000cher.de",
+		Issues{Errors: []Issue{{Code: "domain.format.idn.disallowed_characters"}}},
+	},
+	{"-bücher.de",
+		Issues{Errors: []Issue{{Code: "domain.format.idn.invalid"}}},
+	},
+	{"公司.cn",
+		Issues{Errors: []Issue{{Code: "domain.format.public_suffix"}}},
+	},
 }
 
 func TestCheckDomainFormat(t *testing.T) {
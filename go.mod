@@ -4,4 +4,13 @@ go 1.23.0
 
 toolchain go1.23.5
 
-require golang.org/x/net v0.39.0
+require (
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.37.0
+	golang.org/x/net v0.39.0
+)
+
+require (
+	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/text v0.24.0 // indirect
+)
@@ -0,0 +1,43 @@
+package hstspreload
+
+import (
+	"sync"
+	"time"
+)
+
+// A RequestLimiter limits the rate of the network requests (and
+// connections) made by domain checks. It can be shared by concurrent
+// checks, so that they make at most a given number of requests per second
+// in total. A nil *RequestLimiter does not limit anything.
+type RequestLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRequestLimiter returns a RequestLimiter that spaces out requests
+// evenly, allowing `perSecond` requests per second. If `perSecond` is not
+// positive, it returns nil.
+func NewRequestLimiter(perSecond float64) *RequestLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RequestLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until the next request is allowed.
+func (l *RequestLimiter) Wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(slot.Sub(now))
+}
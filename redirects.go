@@ -23,12 +23,14 @@ const (
 // It is often extra noise to report issues related to #2, so we return
 // firstRedirectHSTS separately and allow the caller to decide whether
 // to use or ignore those issues.
-func preloadableHTTPRedirects(domain string) (general, firstRedirectHSTS Issues) {
-	return preloadableHTTPRedirectsURL("http://"+domain, domain)
+//
+// Requests that fail with a transient error are retried with `r`.
+func preloadableHTTPRedirects(domain string, r *retrier) (general, firstRedirectHSTS Issues) {
+	return preloadableHTTPRedirectsURL("http://"+domain, domain, r)
 }
 
-func preloadableHTTPSRedirects(domain string) Issues {
-	return preloadableHTTPSRedirectsURL("https://" + domain)
+func preloadableHTTPSRedirects(domain string, r *retrier) Issues {
+	return preloadableHTTPSRedirectsURL("https://"+domain, r)
 }
 
 func preloadableRedirectChain(initialURL string, chain []*url.URL) Issues {
@@ -53,10 +55,14 @@ func preloadableRedirectChain(initialURL string, chain []*url.URL) Issues {
 }
 
 // `cont` indicates whether the scan should continue.
-func checkHSTSOverHTTP(initialURL string) (issues Issues, cont bool) {
+func checkHSTSOverHTTP(initialURL string, r *retrier) (issues Issues, cont bool) {
 	issues = Issues{}
 
-	resp, err := getFirstResponse(initialURL)
+	var resp *http.Response
+	err := r.do(func() (err error) {
+		resp, err = getFirstResponse(initialURL)
+		return err
+	})
 	if err != nil {
 		return Issues{}.addWarningf(
 			"redirects.http.does_not_exist",
@@ -84,13 +90,13 @@ func checkHSTSOverHTTP(initialURL string) (issues Issues, cont bool) {
 
 // Taking a URL allows us to test more easily. Use preloadableHTTPRedirects()
 // where possible.
-func preloadableHTTPRedirectsURL(initialURL string, domain string) (general, firstRedirectHSTS Issues) {
-	general, cont := checkHSTSOverHTTP(initialURL)
+func preloadableHTTPRedirectsURL(initialURL string, domain string, r *retrier) (general, firstRedirectHSTS Issues) {
+	general, cont := checkHSTSOverHTTP(initialURL, r)
 	if !cont {
 		return general, Issues{}
 	}
 
-	chain, preloadableRedirectsIssues := preloadableRedirects(initialURL)
+	chain, preloadableRedirectsIssues := preloadableRedirects(initialURL, r)
 	general = combineIssues(general, preloadableRedirectsIssues)
 	if len(chain) == 0 {
 		return general.addErrorf(
@@ -104,7 +110,11 @@ func preloadableHTTPRedirectsURL(initialURL string, domain string) (general, fir
 
 	if chain[0].Scheme == httpsScheme && chain[0].Hostname() == domain {
 		// Check for HSTS on the first redirect.
-		resp, err := getFirstResponse(chain[0].String())
+		var resp *http.Response
+		err := r.do(func() (err error) {
+			resp, err = getFirstResponse(chain[0].String())
+			return err
+		})
 		if err != nil {
 			// We cannot connect this time. This error has high priority,
 			// so return immediately and allow it to mask other errors.
@@ -164,12 +174,12 @@ func preloadableHTTPRedirectsURL(initialURL string, domain string) (general, fir
 
 // Taking a URL allows us to test more easily. Use preloadableHTTPSRedirects()
 // where possible.
-func preloadableHTTPSRedirectsURL(initialURL string) Issues {
-	chain, issues := preloadableRedirects(initialURL)
+func preloadableHTTPSRedirectsURL(initialURL string, r *retrier) Issues {
+	chain, issues := preloadableRedirects(initialURL, r)
 	return combineIssues(issues, preloadableRedirectChain(initialURL, chain))
 }
 
-func preloadableRedirects(initialURL string) (chain []*url.URL, issues Issues) {
+func preloadableRedirects(initialURL string, r *retrier) (chain []*url.URL, issues Issues) {
 	var redirectChain []*url.URL
 	tooManyRedirects := errors.New("TOO_MANY_REDIRECTS")
 
@@ -181,7 +191,8 @@ func preloadableRedirects(initialURL string) (chain []*url.URL, issues Issues) {
 				return tooManyRedirects
 			}
 
-			return nil
+			// Each redirect is a new request.
+			return r.wait()
 		},
 		Timeout: dialTimeout,
 	}
@@ -191,7 +202,12 @@ func preloadableRedirects(initialURL string) (chain []*url.URL, issues Issues) {
 	}
 
 	req.Header.Set("User-Agent", "hstspreload-bot")
-	_, err = client.Do(req)
+	err = r.do(func() error {
+		// Only keep the redirects of the last attempt.
+		redirectChain = nil
+		_, err := client.Do(req)
+		return err
+	})
 
 	if err != nil {
 		if strings.HasSuffix(err.Error(), tooManyRedirects.Error()) {
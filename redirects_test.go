@@ -1,6 +1,9 @@
 package hstspreload
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"sync"
 	"testing"
@@ -50,7 +53,7 @@ func TestTooManyRedirects(t *testing.T) {
 	t.Parallel()
 
 	for _, tt := range tooManyRedirectsTests {
-		chain, issues := preloadableRedirects(tt.url)
+		chain, issues := preloadableRedirects(tt.url, nil)
 		if !chainsEqual(chain, tt.expectedChain) {
 			t.Errorf("[%s] Unexpected chain: %v", tt.description, chain)
 		}
@@ -61,6 +64,33 @@ func TestTooManyRedirects(t *testing.T) {
 	}
 }
 
+func TestRedirectsWaitForEachRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	followed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/first":
+			// The check is stopped before the redirect is followed.
+			cancel()
+			http.Redirect(w, req, "/second", http.StatusMovedPermanently)
+		default:
+			followed = true
+		}
+	}))
+	defer server.Close()
+
+	var attempts int
+	r := newRetrier(CheckOptions{Retry: &RetryPolicy{MaxAttempts: 1}, Context: ctx}, &attempts)
+	_, issues := preloadableRedirects(server.URL+"/first", r)
+	if followed {
+		t.Errorf("The redirect was followed after the context was done")
+	}
+	expected := Issues{Errors: []Issue{{Code: "redirects.follow_error"}}}
+	if !issues.Match(expected) {
+		t.Errorf(issuesShouldMatch, issues, expected)
+	}
+}
+
 func TestInsecureRedirect(t *testing.T) {
 	// Skip this test because it is failing due to relying on behavior of an
 	// external domain: https://github.com/chromium/hstspreload/issues/112.
@@ -71,7 +101,7 @@ func TestInsecureRedirect(t *testing.T) {
 
 	u := "https://httpbin.org/redirect-to?url=http://httpbin.org"
 
-	chain, issues := preloadableRedirects(u)
+	chain, issues := preloadableRedirects(u, nil)
 	if !chainsEqual(chain, []string{"http://httpbin.org"}) {
 		t.Errorf("Unexpected chain: %v", chain)
 	}
@@ -79,7 +109,7 @@ func TestInsecureRedirect(t *testing.T) {
 		t.Errorf(issuesShouldBeEmpty, issues)
 	}
 
-	httpsIssues := preloadableHTTPSRedirectsURL(u)
+	httpsIssues := preloadableHTTPSRedirectsURL(u, nil)
 	expected := Issues{Errors: []Issue{{
 		Code:    "redirects.insecure.initial",
 		Message: "`https://httpbin.org/redirect-to?url=http://httpbin.org` redirects to an insecure page: `http://httpbin.org`",
@@ -99,7 +129,7 @@ func TestIndirectInsecureRedirect(t *testing.T) {
 
 	u := "https://httpbin.org/redirect-to?url=https://httpbin.org/redirect-to?url=http://httpbin.org"
 
-	chain, issues := preloadableRedirects(u)
+	chain, issues := preloadableRedirects(u, nil)
 	if !chainsEqual(chain, []string{"https://httpbin.org/redirect-to?url=http://httpbin.org", "http://httpbin.org"}) {
 		t.Errorf("Unexpected chain: %v", chain)
 	}
@@ -107,7 +137,7 @@ func TestIndirectInsecureRedirect(t *testing.T) {
 		t.Errorf(issuesShouldBeEmpty, issues)
 	}
 
-	httpsIssues := preloadableHTTPSRedirectsURL(u)
+	httpsIssues := preloadableHTTPSRedirectsURL(u, nil)
 	expected := Issues{Errors: []Issue{{
 		Code:    "redirects.insecure.subsequent",
 		Message: "`https://httpbin.org/redirect-to?url=https://httpbin.org/redirect-to?url=http://httpbin.org` redirects to an insecure page on redirect #2: `http://httpbin.org`",
@@ -127,7 +157,7 @@ func TestExplicitPortFirstRedirect(t *testing.T) {
 
 	u := "https://tls-v1-1.badssl.com"
 
-	chain, issues := preloadableRedirects(u)
+	chain, issues := preloadableRedirects(u, nil)
 	if !chainsEqual(chain, []string{"https://tls-v1-1.badssl.com:1011/"}) {
 		t.Errorf("Unexpected chain: %v", chain)
 	}
@@ -135,7 +165,7 @@ func TestExplicitPortFirstRedirect(t *testing.T) {
 		t.Errorf(issuesShouldBeEmpty, issues)
 	}
 
-	httpsIssues := preloadableHTTPSRedirectsURL(u)
+	httpsIssues := preloadableHTTPSRedirectsURL(u, nil)
 	expected := Issues{}
 	if !httpsIssues.Match(expected) {
 		t.Errorf(issuesShouldMatch, httpsIssues, expected)
@@ -154,7 +184,7 @@ func TestHTTPUnavailable(t *testing.T) {
 	domain := "oskuro.net"
 
 	// Test the helper
-	issues, cont := checkHSTSOverHTTP(u)
+	issues, cont := checkHSTSOverHTTP(u, nil)
 	expected := Issues{Warnings: []Issue{{
 		Code:    "redirects.http.does_not_exist",
 		Message: "The site appears to be unavailable over plain HTTP (http://oskuro.net). This can prevent users without a freshly updated modern browser from connecting to the site when they visit a URL with the http:// scheme (or with an unspecified scheme). However, this is okay if the site does not wish to support those users.",
@@ -167,7 +197,7 @@ func TestHTTPUnavailable(t *testing.T) {
 	}
 
 	// Mini integration test
-	mainIssues, firstRedirectHSTSIssues := preloadableHTTPRedirectsURL(u, domain)
+	mainIssues, firstRedirectHSTSIssues := preloadableHTTPRedirectsURL(u, domain, nil)
 	expected = Issues{
 		Warnings: []Issue{{Code: "redirects.http.does_not_exist"}},
 	}
@@ -191,13 +221,13 @@ func TestHSTSOverHTTP(t *testing.T) {
 	u := "http://history.google.com"
 	domain := "history.google.com"
 
-	_, issues := preloadableRedirects(u)
+	_, issues := preloadableRedirects(u, nil)
 	if !issues.Match(Issues{}) {
 		t.Errorf(issuesShouldBeEmpty, issues)
 	}
 
 	// Test the helper
-	issues, cont := checkHSTSOverHTTP(u)
+	issues, cont := checkHSTSOverHTTP(u, nil)
 	expected := Issues{Warnings: []Issue{{
 		Code:    "redirects.http.useless_header",
 		Message: "The HTTP page at http://history.google.com sends an HSTS header. This has no effect over HTTP, and should be removed.",
@@ -210,7 +240,7 @@ func TestHSTSOverHTTP(t *testing.T) {
 	}
 
 	// Mini integration test
-	mainIssues, firstRedirectHSTSIssues := preloadableHTTPRedirectsURL(u, domain)
+	mainIssues, firstRedirectHSTSIssues := preloadableHTTPRedirectsURL(u, domain, nil)
 	expected = Issues{
 		Errors:   []Issue{{Code: "redirects.http.first_redirect.insecure"}},
 		Warnings: []Issue{{Code: "redirects.http.useless_header"}},
@@ -231,7 +261,7 @@ func TestHTTPNoRedirect(t *testing.T) {
 	u := "http://httpbin.org"
 	domain := "httpbin.org"
 
-	chain, issues := preloadableRedirects(u)
+	chain, issues := preloadableRedirects(u, nil)
 	if !chainsEqual(chain, []string{}) {
 		t.Errorf("Unexpected chain: %v", chain)
 	}
@@ -240,7 +270,7 @@ func TestHTTPNoRedirect(t *testing.T) {
 		t.Errorf(issuesShouldBeEmpty, issues)
 	}
 
-	mainIssues, firstRedirectHSTSIssues := preloadableHTTPRedirectsURL(u, domain)
+	mainIssues, firstRedirectHSTSIssues := preloadableHTTPRedirectsURL(u, domain, nil)
 	expected := Issues{Errors: []Issue{{
 		Code:    "redirects.http.no_redirect",
 		Message: "`http://httpbin.org` does not redirect to `https://httpbin.org`.",
@@ -321,7 +351,7 @@ func TestPreloadableHTTPRedirects(t *testing.T) {
 
 	for _, tt := range preloadableHTTPRedirectsTests {
 		go func(tt preloadableHTTPRedirectsTest) {
-			mainIssues, firstRedirectHSTSIssues := preloadableHTTPRedirects(tt.domain)
+			mainIssues, firstRedirectHSTSIssues := preloadableHTTPRedirects(tt.domain, nil)
 
 			if !mainIssues.Match(tt.expectedMainIssues) {
 				t.Errorf("[%s] main issues for %s: "+issuesShouldMatch, tt.description, tt.domain, mainIssues, tt.expectedMainIssues)
@@ -0,0 +1,209 @@
+package hstspreload
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+// A RetryPolicy controls how network operations are retried when they
+// fail with a transient error, such as a timeout or a reset connection.
+//
+// Each operation of a domain check (the initial HTTPS request, the HTTP
+// and HTTPS redirect checks, and the www checks) is retried separately.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of each operation,
+	// including the first one. Values below 1 are treated as 1.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. If 0, there is no cap.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the delay grows after each retry.
+	// Values below 1 are treated as 2.
+	Multiplier float64
+	// Jitter is the fraction (between 0 and 1) of each delay that is
+	// randomized, so that many checks failing at the same time do not all
+	// retry at the same time.
+	Jitter float64
+	// Retryable reports whether an error is worth retrying. If nil,
+	// IsTransientError is used.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy is used when no RetryPolicy is given. It retries
+// transient errors once, without a delay.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 2,
+	Multiplier:  2,
+	Jitter:      0.5,
+}
+
+// Backoff returns the delay before attempt number `attempt` + 1, after
+// `attempt` attempts have failed.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= d * jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransientError(err)
+}
+
+// IsTransientError reports whether a network error is likely to go away
+// if the operation is retried: timeouts, temporary DNS failures, and
+// connections that were refused, reset or closed unexpectedly.
+// Certificate errors and missing domains are not transient.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	for _, target := range []error{
+		context.DeadlineExceeded,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+		syscall.ECONNABORTED,
+		syscall.EPIPE,
+		syscall.ETIMEDOUT,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Attempts counts the network attempts made by each operation of a domain
+// check. An operation that was not run has 0 attempts.
+type Attempts struct {
+	// HTTPS counts the attempts of the initial HTTPS request.
+	HTTPS int `json:"https"`
+	// HTTPRedirects counts the requests over HTTP (and to the first
+	// redirect) of the HTTP redirect check.
+	HTTPRedirects int `json:"http_redirects"`
+	// HTTPSRedirects counts the attempts of the HTTPS redirect check.
+	HTTPSRedirects int `json:"https_redirects"`
+	// WWW counts the connection attempts to the www subdomain.
+	WWW int `json:"www"`
+}
+
+// A retrier runs operations with a RetryPolicy, and counts the attempts.
+// It also waits for the RequestLimiter and checks the context of the check
+// before each request. A nil *retrier makes a single attempt.
+type retrier struct {
+	policy   *RetryPolicy
+	limiter  *RequestLimiter
+	ctx      context.Context
+	fetchAIA bool
+	attempts *int
+	// sleep waits between attempts. If nil, time.Sleep is used.
+	sleep func(time.Duration)
+}
+
+// newRetrier returns a retrier for the options of a check, that counts its
+// attempts in `attempts`. If opts.Retry is nil, DefaultRetryPolicy is used.
+func newRetrier(opts CheckOptions, attempts *int) *retrier {
+	r := &retrier{policy: opts.Retry, limiter: opts.RequestLimiter, ctx: opts.Context, fetchAIA: opts.FetchAIA, attempts: attempts}
+	if r.policy == nil {
+		r.policy = &DefaultRetryPolicy
+	}
+	if r.ctx == nil {
+		r.ctx = context.Background()
+	}
+	return r
+}
+
+// wait is called before each network request. It waits for the
+// RequestLimiter, and returns an error if the context of the check is done.
+func (r *retrier) wait() error {
+	if r == nil {
+		return nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	r.limiter.Wait()
+	return r.ctx.Err()
+}
+
+// chainVerifier returns defaultChainVerifier. If AIA fetching is enabled,
+// AIA fetches wait like other requests.
+func (r *retrier) chainVerifier() chainVerifier {
+	v := defaultChainVerifier
+	if r != nil && r.fetchAIA {
+		v.fetchAIA = func(u string) ([]*x509.Certificate, error) {
+			if err := r.wait(); err != nil {
+				return nil, err
+			}
+			return fetchAIAIssuers(u)
+		}
+	}
+	return v
+}
+
+// do calls `fn` until it succeeds, it fails with an error that is not
+// retryable, or the maximum number of attempts is reached. It returns the
+// last error.
+func (r *retrier) do(fn func() error) error {
+	if r == nil {
+		return fn()
+	}
+	sleep := r.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := r.wait(); err != nil {
+			return err
+		}
+		*r.attempts++
+		err := fn()
+		if err == nil || attempt >= r.policy.MaxAttempts || !r.policy.retryable(err) {
+			return err
+		}
+		sleep(r.policy.Backoff(attempt))
+	}
+}
@@ -0,0 +1,140 @@
+package hstspreload
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		if d := p.Backoff(i + 1); d != e {
+			t.Errorf("Backoff(%d) = %s, expected %s", i+1, d, e)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Backoff(2); d < time.Second || d > 2*time.Second {
+			t.Fatalf("Backoff(2) with jitter = %s, expected between 1s and 2s", d)
+		}
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("something else"), false},
+		{io.EOF, true},
+		{fmt.Errorf("tls handshake: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, true},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{x509.UnknownAuthorityError{}, false},
+	}
+
+	for _, tt := range tests {
+		if transient := IsTransientError(tt.err); transient != tt.transient {
+			t.Errorf("IsTransientError(%#v) = %t, expected %t", tt.err, transient, tt.transient)
+		}
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	var attempts int
+	var delays []time.Duration
+	r := newRetrier(CheckOptions{Retry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second}}, &attempts)
+	r.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	// Transient errors are retried until the maximum number of attempts.
+	err := r.do(func() error { return io.EOF })
+	if err != io.EOF || attempts != 3 {
+		t.Errorf("Expected 3 attempts and the last error, got %d attempts and %v", attempts, err)
+	}
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Errorf("Unexpected delays: %v", delays)
+	}
+
+	// Other errors are not retried.
+	attempts = 0
+	if err := r.do(func() error { return x509.UnknownAuthorityError{} }); err == nil || attempts != 1 {
+		t.Errorf("Expected a single attempt, got %d", attempts)
+	}
+
+	// Success stops the retries.
+	attempts = 0
+	calls := 0
+	err = r.do(func() error {
+		calls++
+		if calls < 2 {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("Expected success after 2 attempts, got %d attempts and %v", attempts, err)
+	}
+
+	// A custom classification replaces IsTransientError.
+	attempts = 0
+	r.policy.Retryable = func(error) bool { return false }
+	if r.do(func() error { return io.EOF }); attempts != 1 {
+		t.Errorf("Expected a single attempt, got %d", attempts)
+	}
+
+	// A nil retrier makes a single attempt.
+	var nilRetrier *retrier
+	calls = 0
+	nilRetrier.do(func() error { calls++; return io.EOF })
+	if calls != 1 {
+		t.Errorf("Expected a single call, got %d", calls)
+	}
+}
+
+func TestRetrierOptions(t *testing.T) {
+	var attempts int
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newRetrier(CheckOptions{RequestLimiter: NewRequestLimiter(50), Context: ctx}, &attempts)
+
+	// Each attempt waits for the limiter.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		r.do(func() error { return nil })
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("3 requests at 50 per second took %s, expected at least 40ms", elapsed)
+	}
+
+	// No attempts are made once the context is done.
+	cancel()
+	attempts = 0
+	if err := r.do(func() error { return nil }); err != context.Canceled || attempts != 0 {
+		t.Errorf("Expected no attempts and context.Canceled, got %d attempts and %v", attempts, err)
+	}
+}
+
+func TestNilRequestLimiter(t *testing.T) {
+	if l := NewRequestLimiter(0); l != nil {
+		t.Errorf("Expected no limiter for 0 requests per second, got %#v", l)
+	}
+	// A nil limiter does not wait.
+	var l *RequestLimiter
+	l.Wait()
+}
@@ -3,6 +3,104 @@ package hstspreload
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	// oidSCTList is the X.509 extension that embeds SCTs in a certificate
+	// (RFC 6962, section 3.3).
+	oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	// oidOCSPSCTList is the OCSP singleExtension that carries SCTs in a
+	// stapled OCSP response (RFC 6962, section 3.3).
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// TLSFeatures summarizes how a server delivers Certificate Transparency
+// information and OCSP responses over a TLS connection.
+type TLSFeatures struct {
+	// SCTs are embedded in the leaf certificate.
+	SCTInCertificate bool `json:"sct_in_certificate"`
+	// SCTs are sent in the signed_certificate_timestamp TLS extension.
+	SCTInTLSExtension bool `json:"sct_in_tls_extension"`
+	// SCTs are included in the stapled OCSP response.
+	SCTInOCSP bool `json:"sct_in_ocsp"`
+	// An OCSP response is stapled to the handshake.
+	OCSPStapled bool `json:"ocsp_stapled"`
+	// The stapled OCSP response is correctly signed, reports the leaf
+	// certificate as good, and is within its validity period.
+	OCSPValid bool `json:"ocsp_valid"`
+}
+
+// HasSCTs reports whether SCTs were delivered by any of the three
+// mechanisms.
+func (f TLSFeatures) HasSCTs() bool {
+	return f.SCTInCertificate || f.SCTInTLSExtension || f.SCTInOCSP
+}
+
+// tlsFeaturesAt inspects a verified TLS connection for Certificate
+// Transparency and OCSP stapling information, evaluating the validity
+// period of a stapled OCSP response at `now`. If the stapled response
+// could not be used, `ocspErr` explains why.
+func tlsFeaturesAt(connState tls.ConnectionState, now time.Time) (features TLSFeatures, ocspErr error) {
+	features.SCTInTLSExtension = len(connState.SignedCertificateTimestamps) > 0
+
+	if len(connState.VerifiedChains) == 0 || len(connState.VerifiedChains[0]) == 0 {
+		return features, nil
+	}
+	leaf := connState.VerifiedChains[0][0]
+	features.SCTInCertificate = hasExtension(leaf, oidSCTList)
+
+	if len(connState.OCSPResponse) == 0 {
+		return features, nil
+	}
+	features.OCSPStapled = true
+
+	var issuer *x509.Certificate
+	if len(connState.VerifiedChains[0]) > 1 {
+		issuer = connState.VerifiedChains[0][1]
+	}
+	resp, err := ocsp.ParseResponseForCert(connState.OCSPResponse, leaf, issuer)
+	if err != nil {
+		return features, err
+	}
+	for _, ext := range resp.Extensions {
+		if ext.Id.Equal(oidOCSPSCTList) {
+			features.SCTInOCSP = true
+		}
+	}
+
+	switch {
+	case resp.Status == ocsp.Revoked:
+		return features, errOCSPRevoked
+	case resp.Status != ocsp.Good:
+		return features, errOCSPUnknown
+	case now.Before(resp.ThisUpdate):
+		return features, errOCSPNotYetValid
+	case !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate):
+		return features, errOCSPExpired
+	}
+	features.OCSPValid = true
+	return features, nil
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	errOCSPRevoked     = errors.New("certificate is revoked")
+	errOCSPUnknown     = errors.New("certificate status is unknown")
+	errOCSPNotYetValid = errors.New("response is not yet valid")
+	errOCSPExpired     = errors.New("response has expired")
 )
 
 func checkChain(connState tls.ConnectionState) Issues {
@@ -31,6 +129,59 @@ func checkSHA1(chain []*x509.Certificate) Issues {
 	return issues
 }
 
+func checkCertificateTransparency(features TLSFeatures) Issues {
+	issues := Issues{}
+
+	if !features.HasSCTs() {
+		return issues.addWarningf(
+			IssueCode("domain.tls.sct.missing"),
+			"No Certificate Transparency information",
+			"The site did not provide any Signed Certificate Timestamps (SCTs) "+
+				"in its certificate, in the TLS handshake, or in a stapled OCSP response. "+
+				"Chrome requires Certificate Transparency for publicly trusted certificates, "+
+				"so a preloaded site without it may not be reachable in Chrome. "+
+				"This is expected for certificates from a private CA. "+
+				"See https://developer.chrome.com/docs/privacy-security/certificate-transparency",
+		)
+	}
+
+	return issues
+}
+
+// checkOCSPStapling reports problems with a stapled OCSP response, given
+// the error returned by tlsFeaturesAt.
+func checkOCSPStapling(features TLSFeatures, err error) Issues {
+	issues := Issues{}
+
+	if !features.OCSPStapled || err == nil {
+		return issues
+	}
+
+	switch err {
+	case errOCSPRevoked:
+		return issues.addErrorf(
+			IssueCode("domain.tls.ocsp.revoked"),
+			"Revoked certificate",
+			"The OCSP response stapled by the site reports that its certificate has been revoked.",
+		)
+	case errOCSPNotYetValid, errOCSPExpired:
+		return issues.addWarningf(
+			IssueCode("domain.tls.ocsp.expired"),
+			"Stale OCSP response",
+			"The OCSP response stapled by the site is outside of its validity period (%s). "+
+				"Make sure that your server refreshes stapled OCSP responses.",
+			err,
+		)
+	default:
+		return issues.addWarningf(
+			IssueCode("domain.tls.ocsp.invalid"),
+			"Invalid OCSP response",
+			"The OCSP response stapled by the site could not be used (%s).",
+			err,
+		)
+	}
+}
+
 func checkCipherSuite(connState tls.ConnectionState) Issues {
 	issues := Issues{}
 
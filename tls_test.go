@@ -0,0 +1,201 @@
+package hstspreload
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+/******** Test certificates. ********/
+
+var testNow = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+var testSerial int64
+
+// newTestCert creates a certificate for `template` signed by `parent`. If
+// `parent` is nil, the certificate is self-signed.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSerial++
+	template.SerialNumber = big.NewInt(testSerial)
+	if template.NotBefore.IsZero() {
+		template.NotBefore = testNow.Add(-24 * time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = testNow.Add(24 * time.Hour)
+	}
+
+	signerCert, signerKey := template, crypto.Signer(key)
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, key.Public(), signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key}
+}
+
+func newTestCA(t *testing.T, name string, parent *testCert) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, parent)
+}
+
+func newTestLeaf(t *testing.T, domain string, parent *testCert, extensions ...pkix.Extension) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: domain},
+		DNSNames:        []string{domain},
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: extensions,
+	}, parent)
+}
+
+func newTestOCSPResponse(t *testing.T, leaf, issuer *testCert, status int, thisUpdate, nextUpdate time.Time, extensions ...pkix.Extension) []byte {
+	t.Helper()
+
+	resp, err := ocsp.CreateResponse(issuer.cert, issuer.cert, ocsp.Response{
+		Status:          status,
+		SerialNumber:    leaf.cert.SerialNumber,
+		ThisUpdate:      thisUpdate,
+		NextUpdate:      nextUpdate,
+		RevokedAt:       thisUpdate,
+		ExtraExtensions: extensions,
+	}, issuer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+/******** TLS feature tests. ********/
+
+func TestTLSFeatures(t *testing.T) {
+	root := newTestCA(t, "Test Root", nil)
+	intermediate := newTestCA(t, "Test Intermediate", root)
+	// The contents of the SCT list are not inspected.
+	sctExtension := pkix.Extension{Id: oidSCTList, Value: []byte{0x04, 0x00}}
+	ocspSCTExtension := pkix.Extension{Id: oidOCSPSCTList, Value: []byte{0x04, 0x00}}
+
+	plainLeaf := newTestLeaf(t, "example.com", intermediate)
+	sctLeaf := newTestLeaf(t, "example.com", intermediate, sctExtension)
+
+	connState := func(leaf *testCert, tlsSCTs [][]byte, staple []byte) tls.ConnectionState {
+		return tls.ConnectionState{
+			VerifiedChains:              [][]*x509.Certificate{{leaf.cert, intermediate.cert, root.cert}},
+			SignedCertificateTimestamps: tlsSCTs,
+			OCSPResponse:                staple,
+		}
+	}
+
+	tests := []struct {
+		description      string
+		connState        tls.ConnectionState
+		expectedFeatures TLSFeatures
+		expectedCT       Issues
+		expectedOCSP     Issues
+	}{
+		{
+			"no SCTs, no staple",
+			connState(plainLeaf, nil, nil),
+			TLSFeatures{},
+			Issues{Warnings: []Issue{{Code: "domain.tls.sct.missing"}}},
+			Issues{},
+		},
+		{
+			"SCT in certificate",
+			connState(sctLeaf, nil, nil),
+			TLSFeatures{SCTInCertificate: true},
+			Issues{},
+			Issues{},
+		},
+		{
+			"SCT in TLS extension",
+			connState(plainLeaf, [][]byte{{0x00}}, nil),
+			TLSFeatures{SCTInTLSExtension: true},
+			Issues{},
+			Issues{},
+		},
+		{
+			"SCT in valid OCSP staple",
+			connState(plainLeaf, nil, newTestOCSPResponse(t, plainLeaf, intermediate, ocsp.Good, testNow.Add(-time.Hour), testNow.Add(time.Hour), ocspSCTExtension)),
+			TLSFeatures{SCTInOCSP: true, OCSPStapled: true, OCSPValid: true},
+			Issues{},
+			Issues{},
+		},
+		{
+			"expired OCSP staple",
+			connState(sctLeaf, nil, newTestOCSPResponse(t, sctLeaf, intermediate, ocsp.Good, testNow.Add(-2*time.Hour), testNow.Add(-time.Hour))),
+			TLSFeatures{SCTInCertificate: true, OCSPStapled: true},
+			Issues{},
+			Issues{Warnings: []Issue{{
+				Code:    "domain.tls.ocsp.expired",
+				Message: "The OCSP response stapled by the site is outside of its validity period (response has expired). Make sure that your server refreshes stapled OCSP responses.",
+			}}},
+		},
+		{
+			"revoked OCSP staple",
+			connState(sctLeaf, nil, newTestOCSPResponse(t, sctLeaf, intermediate, ocsp.Revoked, testNow.Add(-time.Hour), testNow.Add(time.Hour))),
+			TLSFeatures{SCTInCertificate: true, OCSPStapled: true},
+			Issues{},
+			Issues{Errors: []Issue{{Code: "domain.tls.ocsp.revoked"}}},
+		},
+		{
+			"OCSP staple signed by the wrong issuer",
+			connState(sctLeaf, nil, newTestOCSPResponse(t, sctLeaf, root, ocsp.Good, testNow.Add(-time.Hour), testNow.Add(time.Hour))),
+			TLSFeatures{SCTInCertificate: true, OCSPStapled: true},
+			Issues{},
+			Issues{Warnings: []Issue{{Code: "domain.tls.ocsp.invalid"}}},
+		},
+		{
+			"garbage OCSP staple",
+			connState(sctLeaf, nil, []byte("not an OCSP response")),
+			TLSFeatures{SCTInCertificate: true, OCSPStapled: true},
+			Issues{},
+			Issues{Warnings: []Issue{{Code: "domain.tls.ocsp.invalid"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		features, ocspErr := tlsFeaturesAt(tt.connState, testNow)
+		if features != tt.expectedFeatures {
+			t.Errorf("[%s] Unexpected features: %+v", tt.description, features)
+		}
+
+		if issues := checkCertificateTransparency(features); !issues.Match(tt.expectedCT) {
+			t.Errorf("[%s] "+issuesShouldMatch, tt.description, issues, tt.expectedCT)
+		}
+
+		if issues := checkOCSPStapling(features, ocspErr); !issues.Match(tt.expectedOCSP) {
+			t.Errorf("[%s] "+issuesShouldMatch, tt.description, issues, tt.expectedOCSP)
+		}
+	}
+}
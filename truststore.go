@@ -0,0 +1,79 @@
+package hstspreload
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// A TrustStore is a named set of root certificates, such as the roots
+// trusted by Chrome on a particular platform (e.g. "android", "chromeos",
+// or "desktop").
+type TrustStore struct {
+	Name  string
+	Roots *x509.CertPool
+}
+
+// NewTrustStoreFromPEM creates a TrustStore from a bundle of PEM-encoded
+// root certificates.
+func NewTrustStoreFromPEM(name string, pemCerts []byte) (TrustStore, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pemCerts) {
+		return TrustStore{}, errors.New("no certificates found")
+	}
+	return TrustStore{Name: name, Roots: roots}, nil
+}
+
+// NewTrustStoreFromFile reads a TrustStore from a PEM bundle file.
+func NewTrustStoreFromFile(name string, fileName string) (TrustStore, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return TrustStore{}, err
+	}
+
+	store, err := NewTrustStoreFromPEM(name, b)
+	if err != nil {
+		return TrustStore{}, fmt.Errorf("%s: %s", fileName, err)
+	}
+	return store, nil
+}
+
+// checkTrustStores verifies the certificate chain presented by the server
+// against each of `stores`, and reports an error for each store that
+// does not trust it. The chains are diagnosed with the settings of `base`.
+func checkTrustStores(domain string, certs []*x509.Certificate, stores []TrustStore, base chainVerifier) Issues {
+	issues := Issues{}
+	if len(certs) == 0 {
+		return issues
+	}
+
+	for _, store := range stores {
+		v := base
+		v.roots = store.Roots
+		if v.verify(domain, certs[0], certs[1:]) == nil {
+			continue
+		}
+
+		diagnosis := v.diagnoseChain(domain, certs)
+		problem := diagnosis.summary()
+		if problem == "" {
+			problem = "unknown problem"
+			if diagnosis.err != nil {
+				problem = diagnosis.err.Error()
+			}
+		}
+
+		issues = issues.addErrorf(
+			IssueCode("domain.tls.trust_store.untrusted"),
+			"Certificate chain not trusted on some platforms",
+			"The certificate chain for https://%s does not verify using the `%s` trust store: %s. "+
+				"Users on that platform will see a certificate error that they cannot bypass once the site is preloaded.",
+			domain,
+			store.Name,
+			problem,
+		)
+	}
+
+	return issues
+}
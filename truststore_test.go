@@ -0,0 +1,81 @@
+package hstspreload
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewTrustStoreFromFile(t *testing.T) {
+	root := newTestCA(t, "Test Root", nil)
+
+	fileName := filepath.Join(t.TempDir(), "roots.pem")
+	pemCerts := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw})
+	if err := os.WriteFile(fileName, pemCerts, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewTrustStoreFromFile("desktop", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Name != "desktop" {
+		t.Errorf("Wrong name: %q", store.Name)
+	}
+
+	if _, err := root.cert.Verify(x509.VerifyOptions{Roots: store.Roots, CurrentTime: testNow}); err != nil {
+		t.Errorf("Root is not in the trust store: %s", err)
+	}
+
+	if _, err := NewTrustStoreFromPEM("empty", []byte("not a certificate")); err == nil {
+		t.Errorf("Expected an error for a bundle without certificates.")
+	}
+}
+
+func TestCheckTrustStores(t *testing.T) {
+	base := chainVerifier{now: func() time.Time { return testNow }}
+
+	oldRoot := newTestCA(t, "Old Root", nil)
+	newRoot := newTestCA(t, "New Root", nil)
+	intermediate := newTestCA(t, "Test Intermediate", newRoot)
+	leaf := newTestLeaf(t, "example.com", intermediate)
+
+	trustStore := func(name string, roots ...*testCert) TrustStore {
+		pool := x509.NewCertPool()
+		for _, r := range roots {
+			pool.AddCert(r.cert)
+		}
+		return TrustStore{Name: name, Roots: pool}
+	}
+	stores := []TrustStore{
+		trustStore("desktop", oldRoot, newRoot),
+		trustStore("android", oldRoot),
+		trustStore("chromeos", newRoot),
+	}
+
+	issues := checkTrustStores("example.com", []*x509.Certificate{leaf.cert, intermediate.cert, newRoot.cert}, stores, base)
+	expected := Issues{Errors: []Issue{{
+		Code:    "domain.tls.trust_store.untrusted",
+		Message: "The certificate chain for https://example.com does not verify using the `android` trust store: untrusted root certificate \"New Root\". Users on that platform will see a certificate error that they cannot bypass once the site is preloaded.",
+	}}}
+	if !issues.Match(expected) {
+		t.Errorf(issuesShouldMatch, issues, expected)
+	}
+
+	issues = checkTrustStores("example.com", []*x509.Certificate{leaf.cert}, stores, base)
+	expected = Issues{Errors: []Issue{
+		{Code: "domain.tls.trust_store.untrusted"},
+		{Code: "domain.tls.trust_store.untrusted"},
+		{Code: "domain.tls.trust_store.untrusted"},
+	}}
+	if !issues.Match(expected) {
+		t.Errorf(issuesShouldMatch, issues, expected)
+	}
+
+	if issues := checkTrustStores("example.com", []*x509.Certificate{leaf.cert, intermediate.cert}, nil, base); !issues.Match(Issues{}) {
+		t.Errorf(issuesShouldBeEmpty, issues)
+	}
+}